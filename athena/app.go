@@ -2,9 +2,12 @@ package athena
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"reflect"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/lunagic/athena/athenaservices/cache"
@@ -30,6 +33,7 @@ func NewApp(
 		config:       config,
 		handlers:     map[string]http.Handler{},
 		logger:       slog.Default(),
+		consumers:    &sync.WaitGroup{},
 		typeScript: typeScriptConfig{
 			namespace:             "Athena",
 			typesMap:              map[string]reflect.Type{},
@@ -58,6 +62,10 @@ func NewApp(
 		}
 	}
 
+	if app.databaseSeeders != nil && app.database == nil {
+		return nil, errSeedersWithoutDatabase
+	}
+
 	if err := app.calculateTypeScript(); err != nil {
 		return nil, err
 	}
@@ -66,6 +74,12 @@ func NewApp(
 		if _, err := app.database.AutoMigrate(ctx, app.databaseAutoMigrationEntities); err != nil {
 			return nil, err
 		}
+
+		if app.databaseSeeders != nil && app.config.IsDevelopment() {
+			if _, err := app.databaseSeeders.Run(ctx, app.database); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return app, nil
@@ -81,16 +95,36 @@ type App struct {
 	autoRouter                    autoRouterConfig
 	databaseAutoMigrationEntities []database.Entity
 	database                      *database.Service
+	databaseSeeders               *database.SeederRegistry
 	handlers                      map[string]http.Handler
 	middlewares                   poseidon.Middlewares
+	queueConsumers                []func(ctx context.Context)
+	consumers                     *sync.WaitGroup
+	closers                       []io.Closer
 }
 
+// Start background tasks and queue consumers and serve the application over
+// HTTP until the context is done. The queue consumers are drained and the
+// closers given with WithClosers are closed before it returns.
 func (app *App) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	if err := app.Background(ctx); err != nil {
 		return err
 	}
 
-	return app.Serve(ctx)
+	err := app.Serve(ctx)
+
+	// Also stop consuming when serving failed
+	cancel()
+	app.Wait()
+
+	for _, closer := range app.closers {
+		err = errors.Join(err, closer.Close())
+	}
+
+	return err
 }
 
 // Start background tasks and serve the application over HTTP
@@ -1,6 +1,7 @@
 package athena
 
 import (
+	"io"
 	"log/slog"
 )
 
@@ -13,3 +14,14 @@ func WithLogger(logger *slog.Logger) ConfigurationFunc {
 		return nil
 	}
 }
+
+// WithClosers hands the closers to the app, it closes them once Start returns.
+// Use it for services only the app uses, like a cache driver created for the
+// background jobs.
+func WithClosers(closers ...io.Closer) ConfigurationFunc {
+	return func(app *App) error {
+		app.closers = append(app.closers, closers...)
+
+		return nil
+	}
+}
@@ -28,6 +28,9 @@ func NewBackgroundJob(name string, interval time.Duration, action func(ctx conte
 	}
 }
 
+// WithBackgroundJobs runs the jobs on the primary instance, which is elected
+// through the cache driver. The driver stays open when the app stops, hand it
+// to WithClosers as well when nothing else uses it.
 func WithBackgroundJobs(cacheDriver cache.Driver, jobs []BackgroundJob) ConfigurationFunc {
 	return func(app *App) error {
 		app.jobsCacheService = cacheDriver
@@ -37,56 +40,51 @@ func WithBackgroundJobs(cacheDriver cache.Driver, jobs []BackgroundJob) Configur
 	}
 }
 
+// Background starts the background jobs and the queue consumers, they stop
+// once the context is done. Wait waits for the consumers to drain.
 func (app *App) Background(ctx context.Context) error {
+	for _, consumer := range app.queueConsumers {
+		app.consumers.Add(1)
+		go func() {
+			defer app.consumers.Done()
+			consumer(ctx)
+		}()
+	}
+
 	cacheGetter := cache.NewRepository[string, primarySchedulerPayload](app.jobsCacheService, "athena-primary-scheduler")
 	jobLastRunTracker := cache.NewRepository[string, time.Time](app.jobsCacheService, "athena-job-last-ran")
 
-	checkIn := func() error {
-		return cacheGetter.Set(
-			ctx,
-			"data",
-			primarySchedulerPayload{
-				UUID:      app.instanceUUID,
-				CheckedIn: time.Now(),
-			},
-			time.Hour,
-		)
+	// The primary instance keeps the key from expiring by checking in, once it
+	// stops the key expires and the first instance to store it takes over
+	maxTimeWithoutCheckIn := time.Second * 6
+
+	payload := func() primarySchedulerPayload {
+		return primarySchedulerPayload{
+			UUID:      app.instanceUUID,
+			CheckedIn: time.Now(),
+		}
 	}
 
-	backOffTimeToHandleCollisions := time.Second * 3
-	maxTimeWithoutCheckIn := time.Second * 6
+	checkIn := func() error {
+		return cacheGetter.Set(ctx, "data", payload(), maxTimeWithoutCheckIn)
+	}
 
-	var primaryServerChecker func(checkNumber int) bool
-	primaryServerChecker = func(checkNumber int) bool {
-		existingCheckInData, err := cacheGetter.Get(ctx, "data")
+	primaryServerChecker := func() bool {
+		claimed, err := cacheGetter.SetIfNotExists(ctx, "data", payload(), maxTimeWithoutCheckIn)
 		if err != nil {
-			if !errors.Is(err, cache.ErrNotFound) {
-				return false
-			}
+			return false
 		}
 
-		// Check to see if this instance should take over as primary
-		if existingCheckInData.UUID != app.instanceUUID {
-			// Exit early if the primary instance has checked in recently
-			if time.Since(existingCheckInData.CheckedIn) <= maxTimeWithoutCheckIn {
-				return false
-			}
-
-			// Attempt to claim role of primary instance
-			if err := checkIn(); err != nil {
-				return false
-			}
-
-			// Wait for other server's attempts to check in to complete
-			// (wait to see who wins in the check in)
-			time.Sleep(backOffTimeToHandleCollisions)
-
-			// We also just return here to start the loop over again
-			// this could return in us being the primary the next time this function runs
-			return primaryServerChecker(checkNumber + 1)
+		if claimed {
+			return true
 		}
 
-		return true
+		existingCheckInData, err := cacheGetter.Get(ctx, "data")
+		if err != nil {
+			return false
+		}
+
+		return existingCheckInData.UUID == app.instanceUUID
 	}
 
 	jobCanRun := func(ctx context.Context, job BackgroundJob) bool {
@@ -115,7 +113,7 @@ func (app *App) Background(ctx context.Context) error {
 				func(ctx context.Context) error {
 					// Check if we should be the primary server
 					// If we are not the primary instance so we do nothing
-					if !primaryServerChecker(1) {
+					if !primaryServerChecker() {
 						return nil
 					}
 
@@ -148,3 +146,9 @@ func (app *App) Background(ctx context.Context) error {
 
 	return nil
 }
+
+// Wait blocks until the queue consumers started by Background stopped and
+// their handlers in flight finished or ran out of their drain timeout
+func (app *App) Wait() {
+	app.consumers.Wait()
+}
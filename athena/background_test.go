@@ -2,10 +2,12 @@ package athena_test
 
 import (
 	"context"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/lunagic/athena/athena"
+	"github.com/lunagic/athena/athenaservices/cache"
 	"gotest.tools/v3/assert"
 )
 
@@ -54,3 +56,46 @@ func TestBackgroundJobs(t *testing.T) {
 	// Once if the minute changes over during the test (might happen)
 	assert.Assert(t, counter == 1 || counter == 2)
 }
+
+// closeTrackingDriver records whether the app closed it
+type closeTrackingDriver struct {
+	cache.Driver
+	closed atomic.Bool
+}
+
+func (driver *closeTrackingDriver) Close() error {
+	driver.closed.Store(true)
+	return driver.Driver.Close()
+}
+
+func TestBackgroundJobsCloseCache(t *testing.T) {
+	start := func(configFuncs ...athena.ConfigurationFunc) {
+		ctx, cancel := context.WithCancel(t.Context())
+
+		config := athena.NewDefaultConfig()
+		config.AppHTTPPort = 0
+
+		app, err := athena.NewApp(ctx, config, configFuncs...)
+		assert.NilError(t, err)
+
+		startErr := make(chan error, 1)
+		go func() {
+			startErr <- app.Start(ctx)
+		}()
+
+		cancel()
+		assert.NilError(t, <-startErr)
+	}
+
+	memory, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	// Drivers shared with others stay open
+	cacheDriver := &closeTrackingDriver{Driver: memory}
+	start(athena.WithBackgroundJobs(cacheDriver, nil))
+	assert.Assert(t, !cacheDriver.closed.Load())
+
+	// Drivers handed to the app are closed along with it
+	start(athena.WithBackgroundJobs(cacheDriver, nil), athena.WithClosers(cacheDriver))
+	assert.Assert(t, cacheDriver.closed.Load())
+}
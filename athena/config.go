@@ -1,10 +1,13 @@
 package athena
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/lunagic/athena/athenaservices/cache"
@@ -23,12 +26,17 @@ type Config struct {
 	AppHTTPHost string `env:"APP_HTTP_HOST"`
 	AppHTTPPort int    `env:"APP_HTTP_PORT"`
 	AppKey      string `env:"APP_KEY"`
+	AppEnv      string `env:"APP_ENV"`
 	// App Drivers
 	AppDriverMailer   string `env:"APP_DRIVER_MAILER"`
 	AppDriverStorage  string `env:"APP_DRIVER_STORAGE"`
 	AppDriverDatabase string `env:"APP_DRIVER_DATABASE"`
 	AppDriverCache    string `env:"APP_DRIVER_CACHE"`
 	AppDriverQueue    string `env:"APP_DRIVER_QUEUE"`
+	// Cache
+	CacheMemoryMaxEntries int    `env:"CACHE_MEMORY_MAX_ENTRIES"`
+	CacheMemoryMaxBytes   int    `env:"CACHE_MEMORY_MAX_BYTES"`
+	CacheFilePath         string `env:"CACHE_FILE_PATH"`
 	// Services
 	AmazonS3AccessKeyID     string `env:"AMAZON_S3_ACCESS_KEY_ID"`
 	AmazonS3AccessKeySecret string `env:"AMAZON_S3_ACCESS_KEY_SECRET"`
@@ -49,10 +57,16 @@ type Config struct {
 	RabbitMQPass            string `env:"RABBITMQ_PASS"`
 	RabbitMQPort            int    `env:"RABBITMQ_PORT"`
 	RabbitMQUser            string `env:"RABBITMQ_USER"`
+	RedisClusterAddresses   string `env:"REDIS_CLUSTER_ADDRESSES"`
 	RedisHost               string `env:"REDIS_HOST"`
 	RedisNumber             int    `env:"REDIS_NUMBER"`
 	RedisPass               string `env:"REDIS_PASS"`
 	RedisPort               int    `env:"REDIS_PORT"`
+	RedisSentinelAddresses  string `env:"REDIS_SENTINEL_ADDRESSES"`
+	RedisSentinelMaster     string `env:"REDIS_SENTINEL_MASTER"`
+	RedisSentinelPass       string `env:"REDIS_SENTINEL_PASS"`
+	RedisTLS                bool   `env:"REDIS_TLS"`
+	RedisURL                string `env:"REDIS_URL"`
 	RedisUser               string `env:"REDIS_USER"`
 	SMTPHost                string `env:"SMTP_HOST"`
 	SMTPName                string `env:"SMTP_NAME"`
@@ -71,8 +85,10 @@ func NewDefaultConfig() Config {
 		AppDriverMailer:   "smtp",
 		AppDriverQueue:    "memory",
 		AppDriverStorage:  "local",
+		AppEnv:            "production",
 		AppHTTPHost:       "0.0.0.0",
 		AppHTTPPort:       2291,
+		CacheFilePath:     "tmp/cache",
 		MySQLHost:         "127.0.0.1",
 		MySQLPort:         3306,
 		PostgresHost:      "127.0.0.1",
@@ -90,6 +106,7 @@ func NewDefaultConfig() Config {
 func NewTestConfig(t *testing.T) Config {
 	config := NewDefaultConfig()
 	config.SQLitePath = fmt.Sprintf("%s/database.sqlite", t.TempDir())
+	config.CacheFilePath = fmt.Sprintf("%s/cache", t.TempDir())
 
 	return config
 }
@@ -110,6 +127,11 @@ func (config Config) Vault() vault.Vault {
 	return vault.New([]byte(config.AppKey))
 }
 
+// IsDevelopment is only true when APP_ENV is explicitly set to development
+func (config Config) IsDevelopment() bool {
+	return config.AppEnv == "development"
+}
+
 func (config Config) ListenAddr() string {
 	return fmt.Sprintf("%s:%d", config.AppHTTPHost, config.AppHTTPPort)
 }
@@ -184,23 +206,102 @@ func (config Config) Database(configFuncs ...database.ServiceConfigFunc) (*datab
 	return nil, fmt.Errorf("invalid database driver: %s", config.AppDriverDatabase)
 }
 
-func (config Config) Cache() (cache.Driver, error) {
+type cacheConfig struct {
+	database *database.Service
+}
+
+type CacheConfigFunc func(config *cacheConfig)
+
+// WithCacheDatabase keeps the database cache in the service, usually the one
+// the app uses, instead of opening another connection pool for it
+func WithCacheDatabase(service *database.Service) CacheConfigFunc {
+	return func(config *cacheConfig) {
+		config.database = service
+	}
+}
+
+func (config Config) Cache(configFuncs ...CacheConfigFunc) (cache.Driver, error) {
+	cacheConfig := &cacheConfig{}
+	for _, configFunc := range configFuncs {
+		configFunc(cacheConfig)
+	}
+
 	switch config.AppDriverCache {
 	case "memory":
-		return cache.NewDriverMemory()
+		return cache.NewDriverMemory(
+			cache.WithMaxEntries(config.CacheMemoryMaxEntries),
+			cache.WithMaxBytes(int64(config.CacheMemoryMaxBytes)),
+		)
 	case "redis":
-		return cache.NewDriverRedis(cache.DriverRedisConfig{
-			Host:   config.RedisHost,
-			Number: config.RedisNumber,
-			Pass:   config.RedisPass,
-			Port:   config.RedisPort,
-			User:   config.RedisUser,
+		return config.cacheRedis()
+	case "tiered":
+		local, err := cache.NewDriverMemory(
+			cache.WithMaxEntries(config.CacheMemoryMaxEntries),
+			cache.WithMaxBytes(int64(config.CacheMemoryMaxBytes)),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		remote, err := config.cacheRedis()
+		if err != nil {
+			return nil, errors.Join(err, local.Close())
+		}
+
+		driver, err := cache.NewDriverTwoTier(local, remote, cache.DriverTwoTierConfig{})
+		if err != nil {
+			return nil, errors.Join(err, local.Close(), remote.Close())
+		}
+
+		return driver, nil
+	case "database":
+		databaseService := cacheConfig.database
+		if databaseService == nil {
+			var err error
+			databaseService, err = config.Database()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return cache.NewDriverDatabase(context.Background(), databaseService, cache.DriverDatabaseConfig{})
+	case "file":
+		return cache.NewDriverFile(cache.DriverFileConfig{
+			Path: config.CacheFilePath,
 		})
 	}
 
 	return nil, fmt.Errorf("invalid cache driver: %s", config.AppDriverCache)
 }
 
+func (config Config) cacheRedis() (cache.Driver, error) {
+	return cache.NewDriverRedis(cache.DriverRedisConfig{
+		URL:                config.RedisURL,
+		Host:               config.RedisHost,
+		Number:             config.RedisNumber,
+		Pass:               config.RedisPass,
+		Port:               config.RedisPort,
+		User:               config.RedisUser,
+		TLS:                config.RedisTLS,
+		SentinelMasterName: config.RedisSentinelMaster,
+		SentinelAddresses:  splitList(config.RedisSentinelAddresses),
+		SentinelPass:       config.RedisSentinelPass,
+		ClusterAddresses:   splitList(config.RedisClusterAddresses),
+	})
+}
+
+// splitList splits comma separated values, leaving out blanks
+func splitList(value string) []string {
+	values := []string{}
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+
+	return values
+}
+
 func (config Config) Queue() (queue.Driver, error) {
 	switch config.AppDriverQueue {
 	case "memory":
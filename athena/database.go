@@ -1,6 +1,10 @@
 package athena
 
-import "github.com/lunagic/athena/athenaservices/database"
+import (
+	"errors"
+
+	"github.com/lunagic/athena/athenaservices/database"
+)
 
 func WithDatabaseAutoMigration(databaseService *database.Service, entities []database.Entity) ConfigurationFunc {
 	return func(app *App) error {
@@ -10,3 +14,15 @@ func WithDatabaseAutoMigration(databaseService *database.Service, entities []dat
 		return nil
 	}
 }
+
+var errSeedersWithoutDatabase = errors.New("database seeders require WithDatabaseAutoMigration")
+
+// WithDatabaseSeeders runs the seeders after the auto migration, but only when
+// APP_ENV is set to development
+func WithDatabaseSeeders(seeders *database.SeederRegistry) ConfigurationFunc {
+	return func(app *App) error {
+		app.databaseSeeders = seeders
+
+		return nil
+	}
+}
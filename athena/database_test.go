@@ -0,0 +1,90 @@
+package athena_test
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athena"
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func TestDatabaseSeeders(t *testing.T) {
+	seeded := 0
+	seeders := database.NewSeederRegistry()
+	assert.NilError(t, seeders.Register("users", func(ctx context.Context, service *database.Service) error {
+		seeded++
+		return nil
+	}))
+
+	newApp := func(config athena.Config) error {
+		databaseService, err := config.Database()
+		assert.NilError(t, err)
+
+		// The order of the configuration functions doesn't matter
+		_, err = athena.NewApp(
+			t.Context(),
+			config,
+			athena.WithDatabaseSeeders(seeders),
+			athena.WithDatabaseAutoMigration(databaseService, []database.Entity{UserModel{}}),
+		)
+
+		return err
+	}
+
+	// Seeders only run when development is asked for
+	config := athena.NewTestConfig(t)
+	assert.NilError(t, newApp(config))
+	assert.Equal(t, seeded, 0)
+
+	config.AppEnv = "development"
+	assert.NilError(t, newApp(config))
+	assert.Equal(t, seeded, 1)
+
+	_, err := athena.NewApp(t.Context(), config, athena.WithDatabaseSeeders(seeders))
+	assert.ErrorContains(t, err, "WithDatabaseAutoMigration")
+}
+
+func TestCacheDatabase(t *testing.T) {
+	config := athena.NewTestConfig(t)
+	config.AppDriverCache = "database"
+
+	statements := 0
+	databaseService, err := config.Database(database.WithPreRunFunc(func(ctx context.Context, statement string, args []any) error {
+		statements++
+		return nil
+	}))
+	assert.NilError(t, err)
+
+	cacheDriver, err := config.Cache(athena.WithCacheDatabase(databaseService))
+	assert.NilError(t, err)
+	defer func() {
+		_ = cacheDriver.Close()
+	}()
+
+	// The cache runs its statements through the service it was given
+	before := statements
+	assert.NilError(t, cacheDriver.Set(t.Context(), "key", "value", time.Minute))
+	assert.Assert(t, statements > before)
+}
+
+func TestCacheTieredFailure(t *testing.T) {
+	config := athena.NewTestConfig(t)
+	config.AppDriverCache = "tiered"
+	config.RedisURL = "not a redis url"
+
+	before := runtime.NumGoroutine()
+
+	_, err := config.Cache()
+	assert.Assert(t, err != nil)
+
+	// The local tier created before redis failed doesn't keep running
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Assert(t, runtime.NumGoroutine() <= before)
+}
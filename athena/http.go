@@ -2,14 +2,18 @@ package athena
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/lunagic/poseidon/poseidon"
 )
 
+const shutdownTimeout = 30 * time.Second
+
 func WithHandler(path string, handler http.Handler) ConfigurationFunc {
 	return func(app *App) error {
 		app.handlers[path] = handler
@@ -37,10 +41,29 @@ func (app *App) Serve(ctx context.Context) error {
 		"addr", fmt.Sprintf("http://%s", strings.ReplaceAll(listener.Addr().String(), "[::]", "0.0.0.0")),
 	)
 
-	return (&http.Server{
+	server := &http.Server{
 		Handler: app.Handler(),
 		Addr:    app.config.ListenAddr(),
-	}).Serve(listener)
+	}
+
+	// Stop accepting connections once the context is done and give the
+	// requests in flight some time to finish
+	shutdownErr := make(chan error, 1)
+	stop := context.AfterFunc(ctx, func() {
+		app.logger.Info("Server Shutting Down")
+
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+		defer cancel()
+
+		shutdownErr <- server.Shutdown(shutdownCtx)
+	})
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		stop()
+		return err
+	}
+
+	return <-shutdownErr
 }
 
 func (app App) Handler() http.Handler {
@@ -6,6 +6,10 @@ import (
 	"github.com/lunagic/athena/athenaservices/queue"
 )
 
+// WithQueue consumes the queue once Background or Start runs. Consuming stops
+// when ctx or the context given to them is done, the handlers in flight get
+// the drain timeout of the queue to finish and are waited for before Start
+// returns.
 func WithQueue[T any](
 	ctx context.Context,
 	q queue.Queue[T],
@@ -15,9 +19,25 @@ func WithQueue[T any](
 	) error,
 ) ConfigurationFunc {
 	return func(app *App) error {
-		go func() {
-			_ = q.Consume(ctx, handler)
-		}()
+		app.queueConsumers = append(app.queueConsumers, func(appCtx context.Context) {
+			appCtx, cancel := context.WithCancel(appCtx)
+			defer cancel()
+
+			stop := context.AfterFunc(ctx, cancel)
+			defer stop()
+
+			if err := q.Consume(appCtx, func(ctx context.Context, payload T) error {
+				err := handler(ctx, payload)
+				if err != nil {
+					app.logger.WarnContext(ctx, "Queue Message Failed", "queue", q.Name(), "attempt", queue.Attempt(ctx), "error", err)
+				}
+
+				return err
+			}); err != nil {
+				app.logger.ErrorContext(appCtx, "Queue Consumer Failed", "queue", q.Name(), "error", err)
+			}
+		})
+
 		return nil
 	}
 }
@@ -0,0 +1,150 @@
+package athena_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athena"
+	"github.com/lunagic/athena/athenaservices/queue"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+func TestWithQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	config := athena.NewDefaultConfig()
+	config.AppHTTPPort = 0
+
+	queueDriver, err := queue.NewDriverMemory()
+	assert.NilError(t, err)
+
+	jobs, err := queue.NewQueue[string](ctx, queueDriver, "jobs", queue.WithMaxAttempts(1))
+	assert.NilError(t, err)
+
+	logs := &syncBuffer{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	app, err := athena.NewApp(
+		ctx,
+		config,
+		athena.WithLogger(slog.New(slog.NewTextHandler(logs, nil))),
+		athena.WithQueue(ctx, jobs, func(ctx context.Context, payload string) error {
+			if payload == "fail" {
+				return errors.New("handler failed")
+			}
+
+			close(started)
+			<-release
+
+			return nil
+		}),
+	)
+	assert.NilError(t, err)
+
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- app.Start(ctx)
+	}()
+
+	assert.NilError(t, jobs.Publish(ctx, "fail"))
+	poll.WaitOn(t, func(t poll.LogT) poll.Result {
+		if strings.Contains(logs.String(), `msg="Queue Message Failed" queue=jobs attempt=1 error="handler failed"`) {
+			return poll.Success()
+		}
+
+		return poll.Continue("waiting for the failure to be logged")
+	})
+
+	assert.NilError(t, jobs.Publish(ctx, "slow"))
+	<-started
+
+	// Shutting down waits for the message in flight
+	cancel()
+	select {
+	case err := <-startErr:
+		t.Fatalf("start returned before the queue drained: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NilError(t, <-startErr)
+}
+
+func TestWithQueueBackground(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	queueDriver, err := queue.NewDriverMemory()
+	assert.NilError(t, err)
+
+	jobs, err := queue.NewQueue[string](ctx, queueDriver, "jobs", queue.WithDrainTimeout(50*time.Millisecond))
+	assert.NilError(t, err)
+
+	handled := make(chan string, 1)
+	app, err := athena.NewApp(
+		ctx,
+		athena.NewDefaultConfig(),
+		athena.WithQueue(ctx, jobs, func(ctx context.Context, payload string) error {
+			handled <- payload
+
+			// Handlers see the shutdown once the drain timeout passes
+			<-ctx.Done()
+
+			return nil
+		}),
+	)
+	assert.NilError(t, err)
+
+	// Apps running Background without Start consume too
+	assert.NilError(t, app.Background(ctx))
+	assert.NilError(t, jobs.Publish(ctx, "job"))
+
+	select {
+	case payload := <-handled:
+		assert.Equal(t, payload, "job")
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the queue")
+	}
+
+	cancel()
+
+	waited := make(chan struct{})
+	go func() {
+		app.Wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the handler wasn't cancelled after the drain timeout")
+	}
+}
+
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (buffer *syncBuffer) Write(p []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	return buffer.buffer.Write(p)
+}
+
+func (buffer *syncBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	return buffer.buffer.String()
+}
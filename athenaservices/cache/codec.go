@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/lunagic/athena/athenaservices/vault"
+)
+
+var ErrUnknownEncoding = errors.New("cached value uses an unknown encoding")
+
+// Codec turns values into bytes and back
+type Codec interface {
+	Marshal(value any) ([]byte, error)
+	Unmarshal(data []byte, target any) error
+}
+
+var (
+	CodecJSON Codec = jsonCodec{}
+	CodecGob  Codec = gobCodec{}
+)
+
+// NewCodec builds a codec from a pair of functions, for example
+// msgpack.Marshal and msgpack.Unmarshal for MessagePack
+func NewCodec(marshal func(value any) ([]byte, error), unmarshal func(data []byte, target any) error) Codec {
+	return codecFuncs{
+		marshal:   marshal,
+		unmarshal: unmarshal,
+	}
+}
+
+type codecFuncs struct {
+	marshal   func(value any) ([]byte, error)
+	unmarshal func(data []byte, target any) error
+}
+
+func (codec codecFuncs) Marshal(value any) ([]byte, error) {
+	return codec.marshal(value)
+}
+
+func (codec codecFuncs) Unmarshal(data []byte, target any) error {
+	return codec.unmarshal(data, target)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(value any) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, target any) error {
+	return json.Unmarshal(data, target)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(value any) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	if err := gob.NewEncoder(&buffer).Encode(value); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, target any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(target)
+}
+
+type Compression byte
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+)
+
+type RepositoryConfigFunc func(config *repositoryConfig)
+
+type repositoryConfig struct {
+	codec                Codec
+	compression          Compression
+	compressionThreshold int
+	vault                *vault.Vault
+}
+
+// WithCodec replaces JSON, values stored with another codec are only readable
+// by repositories using the same one
+func WithCodec(codec Codec) RepositoryConfigFunc {
+	return func(config *repositoryConfig) {
+		config.codec = codec
+	}
+}
+
+// WithCompression compresses values once they are at least threshold bytes
+func WithCompression(compression Compression, threshold int) RepositoryConfigFunc {
+	return func(config *repositoryConfig) {
+		config.compression = compression
+		config.compressionThreshold = threshold
+	}
+}
+
+// WithEncryption encrypts values with the vault before they reach the driver
+func WithEncryption(v vault.Vault) RepositoryConfigFunc {
+	return func(config *repositoryConfig) {
+		config.vault = &v
+	}
+}
+
+// Values that aren't plain JSON start with envelopeMarker, a byte that can't
+// start JSON, followed by a byte of envelope flags and the base64 payload.
+// Values without it are plain JSON so existing entries stay readable, except
+// for repositories with encryption which only read encrypted values.
+const envelopeMarker = 0x01
+
+const (
+	envelopeCompressionMask byte = 0b011
+	envelopeEncrypted       byte = 0b100
+)
+
+func (config *repositoryConfig) encode(value any) (string, error) {
+	data, err := config.codec.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	flags := byte(0)
+	if config.compression != CompressionNone && len(data) >= config.compressionThreshold {
+		data, err = compress(config.compression, data)
+		if err != nil {
+			return "", err
+		}
+
+		flags |= byte(config.compression)
+	}
+
+	if config.vault != nil {
+		data, err = config.vault.Encrypt(data)
+		if err != nil {
+			return "", err
+		}
+
+		flags |= envelopeEncrypted
+	}
+
+	if _, isJSON := config.codec.(jsonCodec); isJSON && flags == 0 {
+		return string(data), nil
+	}
+
+	return string([]byte{envelopeMarker, flags}) + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func (config *repositoryConfig) decode(raw string, target any) error {
+	// Values written around the encryption, by hand or by a repository
+	// without the key, can't be trusted by repositories with encryption
+	if len(raw) == 0 || raw[0] != envelopeMarker {
+		if config.vault != nil {
+			return ErrUnknownEncoding
+		}
+
+		return json.Unmarshal([]byte(raw), target)
+	}
+
+	if len(raw) < 2 {
+		return ErrUnknownEncoding
+	}
+
+	flags := raw[1]
+	if config.vault != nil && flags&envelopeEncrypted == 0 {
+		return ErrUnknownEncoding
+	}
+
+	data, err := base64.StdEncoding.DecodeString(raw[2:])
+	if err != nil {
+		return err
+	}
+
+	if flags&envelopeEncrypted != 0 {
+		// Refuse to hand out encrypted values to repositories without the key
+		if config.vault == nil {
+			return ErrUnknownEncoding
+		}
+
+		data, err = config.vault.Decrypt(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if compression := Compression(flags & envelopeCompressionMask); compression != CompressionNone {
+		data, err = decompress(compression, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return config.codec.Unmarshal(data, target)
+}
+
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil)
+	})
+)
+
+func compress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionGzip:
+		buffer := bytes.Buffer{}
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+
+		return buffer.Bytes(), nil
+	case CompressionZstd:
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+
+		return encoder.EncodeAll(data, nil), nil
+	default:
+		return nil, ErrUnknownEncoding
+	}
+}
+
+func decompress(compression Compression, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = reader.Close()
+		}()
+
+		return io.ReadAll(reader)
+	case CompressionZstd:
+		decoder, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+
+		return decoder.DecodeAll(data, nil)
+	default:
+		return nil, ErrUnknownEncoding
+	}
+}
@@ -0,0 +1,94 @@
+package cache_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/cache"
+	"github.com/lunagic/athena/athenaservices/vault"
+	"gotest.tools/v3/assert"
+)
+
+type codecTestValue struct {
+	Name      string
+	Body      string
+	CreatedAt time.Time
+}
+
+func TestCodecs(t *testing.T) {
+	v := vault.New([]byte("secret_key_secret_key_secret_key"))
+	value := codecTestValue{
+		Name:      "report",
+		Body:      strings.Repeat("all work and no play ", 200),
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+	}
+
+	testCases := map[string][]cache.RepositoryConfigFunc{
+		"json":      nil,
+		"gob":       {cache.WithCodec(cache.CodecGob)},
+		"gzip":      {cache.WithCompression(cache.CompressionGzip, 1024)},
+		"zstd":      {cache.WithCodec(cache.CodecGob), cache.WithCompression(cache.CompressionZstd, 1024)},
+		"encrypted": {cache.WithEncryption(v)},
+		"all":       {cache.WithCodec(cache.CodecGob), cache.WithCompression(cache.CompressionZstd, 0), cache.WithEncryption(v)},
+	}
+
+	for name, configFuncs := range testCases {
+		t.Run(name, func(t *testing.T) {
+			driver, err := cache.NewDriverMemory()
+			assert.NilError(t, err)
+
+			repository := cache.NewRepository[string, codecTestValue](driver, "codec", configFuncs...)
+			assert.NilError(t, repository.Set(t.Context(), "key", value, time.Minute))
+
+			actualValue, err := repository.Get(t.Context(), "key")
+			assert.NilError(t, err)
+			assert.DeepEqual(t, actualValue, value)
+
+			raw, err := driver.Get(t.Context(), "codec-key")
+			assert.NilError(t, err)
+
+			switch name {
+			case "json":
+				assert.Assert(t, strings.HasPrefix(raw, `{"Name":"report"`))
+			case "gzip", "zstd":
+				assert.Assert(t, len(raw) < len(value.Body))
+			case "encrypted", "all":
+				assert.Assert(t, !strings.Contains(raw, "report"))
+			}
+		})
+	}
+
+	{ // Plain JSON stored before codecs were configured stays readable
+		driver, err := cache.NewDriverMemory()
+		assert.NilError(t, err)
+
+		assert.NilError(t, cache.NewRepository[string, codecTestValue](driver, "codec").Set(t.Context(), "key", value, time.Minute))
+
+		repository := cache.NewRepository[string, codecTestValue](driver, "codec", cache.WithCompression(cache.CompressionGzip, 0))
+		actualValue, err := repository.Get(t.Context(), "key")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, actualValue, value)
+	}
+
+	{ // Repositories with encryption only read encrypted values
+		driver, err := cache.NewDriverMemory()
+		assert.NilError(t, err)
+
+		encrypted := cache.NewRepository[string, codecTestValue](driver, "codec", cache.WithEncryption(v))
+
+		assert.NilError(t, driver.Set(t.Context(), "codec-raw", `{"Name":"forged"}`, time.Minute))
+		_, err = encrypted.Get(t.Context(), "raw")
+		assert.ErrorIs(t, err, cache.ErrUnknownEncoding)
+
+		compressed := cache.NewRepository[string, codecTestValue](driver, "codec", cache.WithCompression(cache.CompressionGzip, 0))
+		assert.NilError(t, compressed.Set(t.Context(), "compressed", value, time.Minute))
+		_, err = encrypted.Get(t.Context(), "compressed")
+		assert.ErrorIs(t, err, cache.ErrUnknownEncoding)
+
+		// Encrypted values aren't handed to repositories without the key
+		assert.NilError(t, encrypted.Set(t.Context(), "key", value, time.Minute))
+		_, err = cache.NewRepository[string, codecTestValue](driver, "codec").Get(t.Context(), "key")
+		assert.ErrorIs(t, err, cache.ErrUnknownEncoding)
+	}
+}
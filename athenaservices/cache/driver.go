@@ -8,10 +8,62 @@ import (
 	"time"
 )
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrNotInteger = errors.New("value is not an integer")
+)
+
+// NoExpiration is the TTL of keys that never expire, it can also be passed as
+// a duration to store a key without expiration
+const NoExpiration time.Duration = -1
 
+// Driver stores string values by key. Durations of zero or less mean the key
+// doesn't expire, the memory driver used to expire such keys right away so
+// code relying on that has to Delete the key instead.
 type Driver interface {
+	Close() error
+	Decrement(ctx context.Context, key string, delta int64) (int64, error)
 	Delete(ctx context.Context, key string) error
+	DeleteByPrefix(ctx context.Context, prefix string) error
+	Expire(ctx context.Context, key string, duration time.Duration) error
+	Flush(ctx context.Context) error
 	Get(ctx context.Context, key string) (string, error)
+	GetMany(ctx context.Context, keys []string) (map[string]string, error)
+	Increment(ctx context.Context, key string, delta int64) (int64, error)
+	// IncrementWithExpiry is Increment with the duration applied to keys it
+	// creates, in a single step so counters never end up without an expiry
+	IncrementWithExpiry(ctx context.Context, key string, delta int64, duration time.Duration) (int64, error)
 	Set(ctx context.Context, key string, value string, duration time.Duration) error
+	SetIfNotExists(ctx context.Context, key string, value string, duration time.Duration) (bool, error)
+	SetMany(ctx context.Context, values map[string]string, duration time.Duration) error
+	TTL(ctx context.Context, key string) (time.Duration, error)
+}
+
+// compareAndDeleter is implemented by drivers that can delete a key only while
+// it holds a value in a single step
+type compareAndDeleter interface {
+	compareAndDelete(ctx context.Context, key string, value string) error
+}
+
+// compareAndDelete deletes the key while it holds the value. Drivers that can't
+// do that in one step get a Get and a Delete, a key that changed in between is
+// deleted anyway.
+func compareAndDelete(ctx context.Context, driver Driver, key string, value string) error {
+	if deleter, ok := driver.(compareAndDeleter); ok {
+		return deleter.compareAndDelete(ctx, key, value)
+	}
+
+	current, err := driver.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if current != value {
+		return nil
+	}
+
+	return driver.Delete(ctx, key)
 }
@@ -0,0 +1,216 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/database"
+)
+
+type DriverDatabaseConfig struct {
+	// CleanupInterval is how often expired entries are deleted, defaults to a
+	// minute
+	CleanupInterval time.Duration
+}
+
+// NewDriverDatabase stores the cache in the athena_cache table so it survives
+// restarts and is shared by every process using the database
+func NewDriverDatabase(ctx context.Context, service *database.Service, config DriverDatabaseConfig) (Driver, error) {
+	if config.CleanupInterval <= 0 {
+		config.CleanupInterval = time.Minute
+	}
+
+	store, err := database.NewCacheStore(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	driver := &driverDatabase{
+		store: store,
+		stop:  make(chan struct{}),
+	}
+
+	go func() {
+		ticker := time.NewTicker(config.CleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-driver.stop:
+				return
+			case <-ticker.C:
+				_, _ = driver.store.DeleteExpired(context.Background())
+			}
+		}
+	}()
+
+	return driver, nil
+}
+
+type driverDatabase struct {
+	store    *database.CacheStore
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Close stops the cleanup goroutine, the database service is left open
+func (driver *driverDatabase) Close() error {
+	driver.stopOnce.Do(func() {
+		close(driver.stop)
+	})
+
+	return nil
+}
+
+func (driver *driverDatabase) Decrement(ctx context.Context, key string, delta int64) (int64, error) {
+	return driver.Increment(ctx, key, -delta)
+}
+
+func (driver *driverDatabase) Delete(ctx context.Context, key string) error {
+	return driver.store.Delete(ctx, key)
+}
+
+func (driver *driverDatabase) compareAndDelete(ctx context.Context, key string, value string) error {
+	return driver.store.DeleteIfValue(ctx, key, value)
+}
+
+func (driver *driverDatabase) DeleteByPrefix(ctx context.Context, prefix string) error {
+	return driver.store.DeleteByPrefix(ctx, prefix)
+}
+
+func (driver *driverDatabase) Expire(ctx context.Context, key string, duration time.Duration) error {
+	found, err := driver.store.Expire(ctx, key, expiresAt(duration))
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (driver *driverDatabase) Flush(ctx context.Context) error {
+	return driver.store.Flush(ctx)
+}
+
+func (driver *driverDatabase) Get(ctx context.Context, key string) (string, error) {
+	entry, err := driver.entry(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	return entry.Value, nil
+}
+
+func (driver *driverDatabase) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	entries, err := driver.store.Get(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for key, entry := range entries {
+		values[key] = entry.Value
+	}
+
+	return values, nil
+}
+
+// Increment retries until its update lands on the value it read, the database
+// has no portable way to add to a number stored as text
+func (driver *driverDatabase) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	return driver.IncrementWithExpiry(ctx, key, delta, NoExpiration)
+}
+
+func (driver *driverDatabase) IncrementWithExpiry(ctx context.Context, key string, delta int64, duration time.Duration) (int64, error) {
+	for {
+		entry, err := driver.entry(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			added, err := driver.store.Add(ctx, key, strconv.FormatInt(delta, 10), expiresAt(duration))
+			if err != nil {
+				return 0, err
+			}
+
+			if added {
+				return delta, nil
+			}
+
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		current, err := strconv.ParseInt(entry.Value, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+
+		if delta == 0 {
+			return current, nil
+		}
+
+		swapped, err := driver.store.Swap(ctx, key, entry.Value, strconv.FormatInt(current+delta, 10))
+		if err != nil {
+			return 0, err
+		}
+
+		if swapped {
+			return current + delta, nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (driver *driverDatabase) Set(ctx context.Context, key string, value string, duration time.Duration) error {
+	return driver.store.Set(ctx, key, value, expiresAt(duration))
+}
+
+func (driver *driverDatabase) SetIfNotExists(ctx context.Context, key string, value string, duration time.Duration) (bool, error) {
+	return driver.store.Add(ctx, key, value, expiresAt(duration))
+}
+
+func (driver *driverDatabase) SetMany(ctx context.Context, values map[string]string, duration time.Duration) error {
+	for key, value := range values {
+		if err := driver.store.Set(ctx, key, value, expiresAt(duration)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (driver *driverDatabase) TTL(ctx context.Context, key string) (time.Duration, error) {
+	entry, err := driver.entry(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if entry.ExpiresAt.IsZero() {
+		return NoExpiration, nil
+	}
+
+	return time.Until(entry.ExpiresAt), nil
+}
+
+func (driver *driverDatabase) entry(ctx context.Context, key string) (database.CacheEntry, error) {
+	entries, err := driver.store.Get(ctx, []string{key})
+	if err != nil {
+		return database.CacheEntry{}, err
+	}
+
+	entry, found := entries[key]
+	if !found {
+		return database.CacheEntry{}, ErrNotFound
+	}
+
+	return entry, nil
+}
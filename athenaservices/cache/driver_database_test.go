@@ -0,0 +1,92 @@
+package cache_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/cache"
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func TestDriverDatabase(t *testing.T) {
+	t.Parallel()
+
+	service, err := database.New(database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())))
+	assert.NilError(t, err)
+
+	driver, err := cache.NewDriverDatabase(t.Context(), service, cache.DriverDatabaseConfig{})
+	assert.NilError(t, err)
+	defer func() {
+		_ = driver.Close()
+	}()
+
+	testCase(t, driver)
+
+	{ // Entries survive a new driver on the same database
+		assert.NilError(t, driver.Set(t.Context(), "kept", "value", time.Minute))
+
+		other, err := cache.NewDriverDatabase(t.Context(), service, cache.DriverDatabaseConfig{})
+		assert.NilError(t, err)
+		defer func() {
+			_ = other.Close()
+		}()
+
+		value, err := other.Get(t.Context(), "kept")
+		assert.NilError(t, err)
+		assert.Equal(t, value, "value")
+	}
+
+	{ // Prefixes are matched literally
+		assert.NilError(t, driver.Set(t.Context(), "100%_a", "value", time.Minute))
+		assert.NilError(t, driver.Set(t.Context(), "1000_a", "value", time.Minute))
+		assert.NilError(t, driver.DeleteByPrefix(t.Context(), "100%_"))
+
+		_, err := driver.Get(t.Context(), "100%_a")
+		assert.ErrorIs(t, err, cache.ErrNotFound)
+		_, err = driver.Get(t.Context(), "1000_a")
+		assert.NilError(t, err)
+	}
+
+	{ // Keys and values differing only in case are told apart
+		assert.NilError(t, driver.Set(t.Context(), "User-A", "upper", time.Minute))
+		assert.NilError(t, driver.Set(t.Context(), "user-a", "lower", time.Minute))
+
+		values, err := driver.GetMany(t.Context(), []string{"User-A", "user-a"})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, values, map[string]string{"User-A": "upper", "user-a": "lower"})
+
+		assert.NilError(t, driver.DeleteByPrefix(t.Context(), "User-"))
+		_, err = driver.Get(t.Context(), "User-A")
+		assert.ErrorIs(t, err, cache.ErrNotFound)
+		_, err = driver.Get(t.Context(), "user-a")
+		assert.NilError(t, err)
+
+		set, err := driver.SetIfNotExists(t.Context(), "user-a", "other", time.Minute)
+		assert.NilError(t, err)
+		assert.Assert(t, !set)
+	}
+
+	{ // Keys aren't limited in length
+		key := strings.Repeat("long-", 100)
+		assert.NilError(t, driver.Set(t.Context(), key, "value", time.Minute))
+
+		value, err := driver.Get(t.Context(), key)
+		assert.NilError(t, err)
+		assert.Equal(t, value, "value")
+	}
+
+	{ // Expired entries are swept
+		assert.NilError(t, driver.Set(t.Context(), "swept", "value", time.Millisecond))
+		time.Sleep(5 * time.Millisecond)
+
+		store, err := database.NewCacheStore(t.Context(), service)
+		assert.NilError(t, err)
+
+		deleted, err := store.DeleteExpired(t.Context())
+		assert.NilError(t, err)
+		assert.Equal(t, deleted, int64(1))
+	}
+}
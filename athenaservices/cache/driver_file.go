@@ -0,0 +1,429 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DriverFileConfig struct {
+	Path string
+	// CleanupInterval is how often expired entries are deleted, defaults to a
+	// minute
+	CleanupInterval time.Duration
+}
+
+// NewDriverFile stores every entry in its own file below the path, processes
+// sharing the path share the cache
+func NewDriverFile(config DriverFileConfig) (Driver, error) {
+	if config.CleanupInterval <= 0 {
+		config.CleanupInterval = time.Minute
+	}
+
+	if err := os.MkdirAll(config.Path, 0755); err != nil {
+		return nil, err
+	}
+
+	driver := &driverFile{
+		path: config.Path,
+		stop: make(chan struct{}),
+	}
+
+	go func() {
+		ticker := time.NewTicker(config.CleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-driver.stop:
+				return
+			case <-ticker.C:
+				_ = driver.deleteWhere(context.Background(), func(entry fileEntry) bool {
+					return entry.expired(time.Now())
+				})
+			}
+		}
+	}()
+
+	return driver, nil
+}
+
+type driverFile struct {
+	path     string
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// fileEntry is the content of an entry file, the key is kept so entries can
+// be found by prefix
+type fileEntry struct {
+	Key       string
+	Value     string
+	ExpiresAt time.Time
+}
+
+func (entry fileEntry) expired(now time.Time) bool {
+	return !entry.ExpiresAt.IsZero() && !now.Before(entry.ExpiresAt)
+}
+
+// Close stops the cleanup goroutine
+func (driver *driverFile) Close() error {
+	driver.stopOnce.Do(func() {
+		close(driver.stop)
+	})
+
+	return nil
+}
+
+func (driver *driverFile) Decrement(ctx context.Context, key string, delta int64) (int64, error) {
+	return driver.Increment(ctx, key, -delta)
+}
+
+func (driver *driverFile) Delete(ctx context.Context, key string) error {
+	return driver.update(ctx, key, func(entry fileEntry, found bool) (*fileEntry, error) {
+		return nil, nil
+	})
+}
+
+func (driver *driverFile) compareAndDelete(ctx context.Context, key string, value string) error {
+	return driver.update(ctx, key, func(entry fileEntry, found bool) (*fileEntry, error) {
+		if found && entry.Value == value {
+			return nil, nil
+		}
+
+		return &entry, nil
+	})
+}
+
+func (driver *driverFile) DeleteByPrefix(ctx context.Context, prefix string) error {
+	return driver.deleteWhere(ctx, func(entry fileEntry) bool {
+		return strings.HasPrefix(entry.Key, prefix)
+	})
+}
+
+func (driver *driverFile) Expire(ctx context.Context, key string, duration time.Duration) error {
+	return driver.update(ctx, key, func(entry fileEntry, found bool) (*fileEntry, error) {
+		if !found {
+			return nil, ErrNotFound
+		}
+
+		entry.ExpiresAt = expiresAt(duration)
+
+		return &entry, nil
+	})
+}
+
+func (driver *driverFile) Flush(ctx context.Context) error {
+	return driver.deleteWhere(ctx, func(entry fileEntry) bool {
+		return true
+	})
+}
+
+func (driver *driverFile) Get(ctx context.Context, key string) (string, error) {
+	entry, found, err := driver.read(driver.entryPath(key))
+	if err != nil {
+		return "", err
+	}
+
+	if !found {
+		return "", ErrNotFound
+	}
+
+	return entry.Value, nil
+}
+
+func (driver *driverFile) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, key := range keys {
+		entry, found, err := driver.read(driver.entryPath(key))
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			values[key] = entry.Value
+		}
+	}
+
+	return values, nil
+}
+
+func (driver *driverFile) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	return driver.IncrementWithExpiry(ctx, key, delta, NoExpiration)
+}
+
+func (driver *driverFile) IncrementWithExpiry(ctx context.Context, key string, delta int64, duration time.Duration) (int64, error) {
+	result := int64(0)
+	err := driver.update(ctx, key, func(entry fileEntry, found bool) (*fileEntry, error) {
+		current := int64(0)
+		if found {
+			value, err := strconv.ParseInt(entry.Value, 10, 64)
+			if err != nil {
+				return nil, ErrNotInteger
+			}
+
+			current = value
+		} else {
+			entry.ExpiresAt = expiresAt(duration)
+		}
+
+		result = current + delta
+		entry.Key = key
+		entry.Value = strconv.FormatInt(result, 10)
+
+		return &entry, nil
+	})
+
+	return result, err
+}
+
+func (driver *driverFile) Set(ctx context.Context, key string, value string, duration time.Duration) error {
+	return driver.update(ctx, key, func(entry fileEntry, found bool) (*fileEntry, error) {
+		return &fileEntry{
+			Key:       key,
+			Value:     value,
+			ExpiresAt: expiresAt(duration),
+		}, nil
+	})
+}
+
+func (driver *driverFile) SetIfNotExists(ctx context.Context, key string, value string, duration time.Duration) (bool, error) {
+	set := false
+	err := driver.update(ctx, key, func(entry fileEntry, found bool) (*fileEntry, error) {
+		if found {
+			return &entry, nil
+		}
+
+		set = true
+
+		return &fileEntry{
+			Key:       key,
+			Value:     value,
+			ExpiresAt: expiresAt(duration),
+		}, nil
+	})
+
+	return set, err
+}
+
+func (driver *driverFile) SetMany(ctx context.Context, values map[string]string, duration time.Duration) error {
+	for key, value := range values {
+		if err := driver.Set(ctx, key, value, duration); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (driver *driverFile) TTL(ctx context.Context, key string) (time.Duration, error) {
+	entry, found, err := driver.read(driver.entryPath(key))
+	if err != nil {
+		return 0, err
+	}
+
+	if !found {
+		return 0, ErrNotFound
+	}
+
+	if entry.ExpiresAt.IsZero() {
+		return NoExpiration, nil
+	}
+
+	return time.Until(entry.ExpiresAt), nil
+}
+
+// entryPath hashes the key so any key makes a valid file name, the first
+// characters of the hash spread the files over directories
+func (driver *driverFile) entryPath(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(hash[:])
+
+	return filepath.Join(driver.path, name[:2], name)
+}
+
+// read returns the entry when it exists and hasn't expired
+func (driver *driverFile) read(path string) (fileEntry, bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileEntry{}, false, nil
+	}
+	if err != nil {
+		return fileEntry{}, false, err
+	}
+
+	entry := fileEntry{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return fileEntry{}, false, err
+	}
+
+	if entry.expired(time.Now()) {
+		return fileEntry{}, false, nil
+	}
+
+	return entry, true, nil
+}
+
+// update changes the entry while holding its lock, a nil entry deletes it
+func (driver *driverFile) update(ctx context.Context, key string, change func(entry fileEntry, found bool) (*fileEntry, error)) error {
+	path := driver.entryPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(ctx, path+".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entry, found, err := driver.read(path)
+	if err != nil {
+		return err
+	}
+
+	changed, err := change(entry, found)
+	if err != nil {
+		return err
+	}
+
+	if changed == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		return nil
+	}
+
+	data, err := json.Marshal(changed)
+	if err != nil {
+		return err
+	}
+
+	// Readers never see a partial entry since the rename replaces it at once
+	file, err := os.CreateTemp(filepath.Dir(path), "*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		_ = os.Remove(file.Name())
+
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// deleteWhere removes the entries matching the condition, the condition is
+// checked again under the lock of the entry in case another process changed it
+func (driver *driverFile) deleteWhere(ctx context.Context, condition func(entry fileEntry) bool) error {
+	return filepath.WalkDir(driver.path, func(path string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		if dirEntry.IsDir() || filepath.Ext(path) != "" {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry := fileEntry{}
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+
+		if !condition(entry) {
+			return nil
+		}
+
+		return driver.update(ctx, entry.Key, func(current fileEntry, found bool) (*fileEntry, error) {
+			if found && !condition(current) {
+				return &current, nil
+			}
+
+			return nil, nil
+		})
+	})
+}
+
+// lockFile takes a lock shared between processes, it waits while another
+// process holds it. The operating system releases the lock of a process that
+// crashed so locks are never taken over while their holder is still running.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	wait := time.Millisecond
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		locked, err := tryLockFile(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+
+		if locked {
+			// The holder before us removed the file while we opened it, the
+			// lock has to be on the file others will open
+			if current, err := os.Stat(path); err == nil && sameFile(file, current) {
+				return func() {
+					_ = os.Remove(path)
+					_ = unlockFile(file)
+					_ = file.Close()
+				}, nil
+			}
+
+			_ = unlockFile(file)
+			_ = file.Close()
+			continue
+		}
+
+		_ = file.Close()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+
+		wait = min(wait*2, 50*time.Millisecond)
+	}
+}
+
+func sameFile(file *os.File, info fs.FileInfo) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(opened, info)
+}
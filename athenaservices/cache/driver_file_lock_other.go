@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package cache
+
+import (
+	"errors"
+	"os"
+)
+
+func tryLockFile(file *os.File) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+func unlockFile(file *os.File) error {
+	return errors.ErrUnsupported
+}
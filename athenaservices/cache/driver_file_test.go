@@ -0,0 +1,111 @@
+package cache_test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/cache"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+func TestDriverFile(t *testing.T) {
+	t.Parallel()
+
+	path := t.TempDir()
+	driver, err := cache.NewDriverFile(cache.DriverFileConfig{
+		Path:            path,
+		CleanupInterval: 10 * time.Millisecond,
+	})
+	assert.NilError(t, err)
+	defer func() {
+		_ = driver.Close()
+	}()
+
+	testCase(t, driver)
+
+	// A second driver on the same path stands in for another process
+	other, err := cache.NewDriverFile(cache.DriverFileConfig{Path: path})
+	assert.NilError(t, err)
+	defer func() {
+		_ = other.Close()
+	}()
+
+	{ // Entries are shared through the path
+		assert.NilError(t, driver.Set(t.Context(), "kept", "value", time.Minute))
+
+		value, err := other.Get(t.Context(), "kept")
+		assert.NilError(t, err)
+		assert.Equal(t, value, "value")
+	}
+
+	{ // Counters don't lose updates between processes
+		waitGroup := sync.WaitGroup{}
+		for _, d := range []cache.Driver{driver, other, driver, other} {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+
+				for range 50 {
+					_, err := d.Increment(t.Context(), "counter", 1)
+					assert.NilError(t, err)
+				}
+			}()
+		}
+		waitGroup.Wait()
+
+		value, err := driver.Get(t.Context(), "counter")
+		assert.NilError(t, err)
+		assert.Equal(t, value, "200")
+	}
+
+	{ // Lock files left by a crashed process don't block anyone
+		assert.NilError(t, driver.Set(t.Context(), "crashed", "value", time.Minute))
+
+		entries := []string{}
+		assert.NilError(t, filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() {
+				entries = append(entries, path)
+			}
+
+			return err
+		}))
+
+		for _, entry := range entries {
+			assert.NilError(t, os.WriteFile(entry+".lock", nil, 0644))
+		}
+
+		ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+		defer cancel()
+		assert.NilError(t, other.Set(ctx, "crashed", "changed", time.Minute))
+	}
+
+	{ // Expired entries are swept
+		assert.NilError(t, driver.Flush(t.Context()))
+		assert.NilError(t, driver.Set(t.Context(), "swept", "value", time.Millisecond))
+
+		poll.WaitOn(t, func(t poll.LogT) poll.Result {
+			files := 0
+			if err := filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+				if err == nil && !entry.IsDir() {
+					files++
+				}
+
+				return err
+			}); err != nil {
+				return poll.Error(err)
+			}
+
+			if files > 0 {
+				return poll.Continue("%d files left", files)
+			}
+
+			return poll.Success()
+		}, poll.WithTimeout(time.Second))
+	}
+}
@@ -0,0 +1,488 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics is an in-process registry of what instrumented drivers did, grouped
+// by key prefix. One registry can be shared by several drivers.
+type Metrics struct {
+	mutex    sync.Mutex
+	prefixes map[string]*PrefixMetrics
+}
+
+type PrefixMetrics struct {
+	Prefix       string
+	Operations   int64
+	Hits         int64
+	Misses       int64
+	Errors       int64
+	BytesRead    int64
+	BytesWritten int64
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+func (metrics PrefixMetrics) HitRatio() float64 {
+	if metrics.Hits+metrics.Misses == 0 {
+		return 0
+	}
+
+	return float64(metrics.Hits) / float64(metrics.Hits+metrics.Misses)
+}
+
+func (metrics PrefixMetrics) AverageLatency() time.Duration {
+	if metrics.Operations == 0 {
+		return 0
+	}
+
+	return metrics.TotalLatency / time.Duration(metrics.Operations)
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		prefixes: map[string]*PrefixMetrics{},
+	}
+}
+
+// Snapshot returns a copy of the metrics of every prefix sorted by prefix
+func (metrics *Metrics) Snapshot() []PrefixMetrics {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	snapshot := []PrefixMetrics{}
+	for _, prefixMetrics := range metrics.prefixes {
+		snapshot = append(snapshot, *prefixMetrics)
+	}
+
+	slices.SortFunc(snapshot, func(a PrefixMetrics, b PrefixMetrics) int {
+		return strings.Compare(a.Prefix, b.Prefix)
+	})
+
+	return snapshot
+}
+
+func (metrics *Metrics) Reset() {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.prefixes = map[string]*PrefixMetrics{}
+}
+
+func (metrics *Metrics) record(latency time.Duration, observation observation) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	prefixMetrics, found := metrics.prefixes[observation.prefix]
+	if !found {
+		prefixMetrics = &PrefixMetrics{Prefix: observation.prefix}
+		metrics.prefixes[observation.prefix] = prefixMetrics
+	}
+
+	prefixMetrics.Operations++
+	prefixMetrics.Hits += observation.hits
+	prefixMetrics.Misses += observation.misses
+	prefixMetrics.BytesRead += observation.bytesRead
+	prefixMetrics.BytesWritten += observation.bytesWritten
+	prefixMetrics.TotalLatency += latency
+	prefixMetrics.MaxLatency = max(prefixMetrics.MaxLatency, latency)
+
+	if observation.failed() {
+		prefixMetrics.Errors++
+	}
+}
+
+// OtherPrefix groups the keys that don't start with a known prefix, keeping
+// the number of groups bounded whatever the keys look like
+const OtherPrefix = "(other)"
+
+type DriverInstrumentedConfigFunc func(driver *driverInstrumented)
+
+// WithKeyPrefixes groups keys not written through a repository, a key belongs
+// to a prefix when the prefix is followed by a - or :
+func WithKeyPrefixes(prefixes ...string) DriverInstrumentedConfigFunc {
+	return func(driver *driverInstrumented) {
+		for _, prefix := range prefixes {
+			driver.registerPrefix(prefix)
+		}
+	}
+}
+
+// WithMetrics records the operations in the registry
+func WithMetrics(metrics *Metrics) DriverInstrumentedConfigFunc {
+	return func(driver *driverInstrumented) {
+		driver.metrics = metrics
+	}
+}
+
+// WithDebugLogger logs every operation as a debug event
+func WithDebugLogger(logger *slog.Logger) DriverInstrumentedConfigFunc {
+	return func(driver *driverInstrumented) {
+		driver.logger = logger
+	}
+}
+
+// NewDriverInstrumented wraps the driver to record hits, misses, errors,
+// latencies and payload sizes. Keys are grouped by the prefix of the
+// repository using them or one given with WithKeyPrefixes, other keys under
+// OtherPrefix. Statistics of the wrapped driver are still reported.
+func NewDriverInstrumented(driver Driver, configFuncs ...DriverInstrumentedConfigFunc) Driver {
+	instrumented := &driverInstrumented{
+		driver:   driver,
+		prefixes: []string{tagVersionPrefix},
+	}
+
+	for _, configFunc := range configFuncs {
+		configFunc(instrumented)
+	}
+
+	// Keep the optional interfaces of the wrapped driver available, the two
+	// tier driver broadcasts through it
+	broadcaster, broadcasts := driver.(invalidationBroadcaster)
+	statsReporter, reportsStats := driver.(StatsReporter)
+
+	switch {
+	case broadcasts && reportsStats:
+		return &driverInstrumentedBroadcasterStats{
+			driverInstrumented:      instrumented,
+			invalidationBroadcaster: broadcaster,
+			StatsReporter:           statsReporter,
+		}
+	case broadcasts:
+		return &driverInstrumentedBroadcaster{
+			driverInstrumented:      instrumented,
+			invalidationBroadcaster: broadcaster,
+		}
+	case reportsStats:
+		return &driverInstrumentedStats{
+			driverInstrumented: instrumented,
+			StatsReporter:      statsReporter,
+		}
+	}
+
+	return instrumented
+}
+
+type driverInstrumentedBroadcaster struct {
+	*driverInstrumented
+	invalidationBroadcaster
+}
+
+type driverInstrumentedStats struct {
+	*driverInstrumented
+	StatsReporter
+}
+
+type driverInstrumentedBroadcasterStats struct {
+	*driverInstrumented
+	invalidationBroadcaster
+	StatsReporter
+}
+
+type driverInstrumented struct {
+	driver   Driver
+	metrics  *Metrics
+	logger   *slog.Logger
+	mutex    sync.RWMutex
+	prefixes []string
+}
+
+// prefixRegistrar is implemented by drivers that want to know the prefixes
+// repositories use
+type prefixRegistrar interface {
+	registerPrefix(prefix string)
+}
+
+func (driver *driverInstrumented) registerPrefix(prefix string) {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+
+	if slices.Contains(driver.prefixes, prefix) {
+		return
+	}
+
+	driver.prefixes = append(driver.prefixes, prefix)
+
+	// Longest first so nested prefixes match the most specific one
+	slices.SortFunc(driver.prefixes, func(a string, b string) int {
+		return len(b) - len(a)
+	})
+}
+
+func (driver *driverInstrumented) prefix(key string) string {
+	driver.mutex.RLock()
+	defer driver.mutex.RUnlock()
+
+	for _, prefix := range driver.prefixes {
+		if strings.HasPrefix(key, prefix+"-") || strings.HasPrefix(key, prefix+":") {
+			return prefix
+		}
+	}
+
+	return OtherPrefix
+}
+
+// observation is what a single operation did to the keys of one prefix
+type observation struct {
+	operation    string
+	key          string
+	prefix       string
+	hits         int64
+	misses       int64
+	bytesRead    int64
+	bytesWritten int64
+	err          error
+}
+
+// failed leaves out not found errors, they are misses
+func (observation observation) failed() bool {
+	return observation.err != nil && !errors.Is(observation.err, ErrNotFound)
+}
+
+func (driver *driverInstrumented) record(ctx context.Context, start time.Time, observations ...observation) {
+	latency := time.Since(start)
+
+	for _, observation := range observations {
+		if driver.metrics != nil {
+			driver.metrics.record(latency, observation)
+		}
+
+		if driver.logger != nil {
+			attributes := []any{
+				"operation", observation.operation,
+				"key", observation.key,
+				"prefix", observation.prefix,
+				"latency", latency,
+				"hits", observation.hits,
+				"misses", observation.misses,
+				"bytesRead", observation.bytesRead,
+				"bytesWritten", observation.bytesWritten,
+			}
+
+			if observation.failed() {
+				attributes = append(attributes, "error", observation.err)
+			}
+
+			driver.logger.DebugContext(ctx, "Cache Operation", attributes...)
+		}
+	}
+}
+
+// observeKey records an operation on a single key
+func (driver *driverInstrumented) observeKey(ctx context.Context, start time.Time, operation string, key string, err error, apply func(observation *observation)) {
+	observation := observation{
+		operation: operation,
+		key:       key,
+		prefix:    driver.prefix(key),
+		err:       err,
+	}
+
+	if apply != nil {
+		apply(&observation)
+	}
+
+	driver.record(ctx, start, observation)
+}
+
+func (driver *driverInstrumented) Close() error {
+	return driver.driver.Close()
+}
+
+func (driver *driverInstrumented) Decrement(ctx context.Context, key string, delta int64) (int64, error) {
+	start := time.Now()
+	result, err := driver.driver.Decrement(ctx, key, delta)
+	driver.observeKey(ctx, start, "Decrement", key, err, nil)
+
+	return result, err
+}
+
+func (driver *driverInstrumented) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := driver.driver.Delete(ctx, key)
+	driver.observeKey(ctx, start, "Delete", key, err, nil)
+
+	return err
+}
+
+func (driver *driverInstrumented) compareAndDelete(ctx context.Context, key string, value string) error {
+	start := time.Now()
+	err := compareAndDelete(ctx, driver.driver, key, value)
+	driver.observeKey(ctx, start, "CompareAndDelete", key, err, nil)
+
+	return err
+}
+
+func (driver *driverInstrumented) DeleteByPrefix(ctx context.Context, prefix string) error {
+	start := time.Now()
+	err := driver.driver.DeleteByPrefix(ctx, prefix)
+	driver.observeKey(ctx, start, "DeleteByPrefix", prefix, err, nil)
+
+	return err
+}
+
+func (driver *driverInstrumented) Expire(ctx context.Context, key string, duration time.Duration) error {
+	start := time.Now()
+	err := driver.driver.Expire(ctx, key, duration)
+	driver.observeKey(ctx, start, "Expire", key, err, nil)
+
+	return err
+}
+
+func (driver *driverInstrumented) Flush(ctx context.Context) error {
+	start := time.Now()
+	err := driver.driver.Flush(ctx)
+	driver.record(ctx, start, observation{
+		operation: "Flush",
+		prefix:    "*",
+		err:       err,
+	})
+
+	return err
+}
+
+func (driver *driverInstrumented) Get(ctx context.Context, key string) (string, error) {
+	start := time.Now()
+	value, err := driver.driver.Get(ctx, key)
+	driver.observeKey(ctx, start, "Get", key, err, func(observation *observation) {
+		observeRead(observation, value, err)
+	})
+
+	return value, err
+}
+
+// GetMany records one observation per prefix of the keys
+func (driver *driverInstrumented) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	start := time.Now()
+	values, err := driver.driver.GetMany(ctx, keys)
+
+	observations := []observation{}
+	byPrefix := map[string]int{}
+	for _, key := range keys {
+		prefix := driver.prefix(key)
+
+		index, found := byPrefix[prefix]
+		if !found {
+			index = len(observations)
+			byPrefix[prefix] = index
+			observations = append(observations, observation{
+				operation: "GetMany",
+				key:       key,
+				prefix:    prefix,
+				err:       err,
+			})
+		}
+
+		// Repositories read their metadata along with the values
+		if err != nil || isMetadataKey(key) {
+			continue
+		}
+
+		if value, found := values[key]; found {
+			observations[index].hits++
+			observations[index].bytesRead += int64(len(value))
+		} else {
+			observations[index].misses++
+		}
+	}
+
+	driver.record(ctx, start, observations...)
+
+	return values, err
+}
+
+func (driver *driverInstrumented) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	start := time.Now()
+	result, err := driver.driver.Increment(ctx, key, delta)
+	driver.observeKey(ctx, start, "Increment", key, err, nil)
+
+	return result, err
+}
+
+func (driver *driverInstrumented) IncrementWithExpiry(ctx context.Context, key string, delta int64, duration time.Duration) (int64, error) {
+	start := time.Now()
+	result, err := driver.driver.IncrementWithExpiry(ctx, key, delta, duration)
+	driver.observeKey(ctx, start, "IncrementWithExpiry", key, err, nil)
+
+	return result, err
+}
+
+func (driver *driverInstrumented) Set(ctx context.Context, key string, value string, duration time.Duration) error {
+	start := time.Now()
+	err := driver.driver.Set(ctx, key, value, duration)
+	driver.observeKey(ctx, start, "Set", key, err, func(observation *observation) {
+		observation.bytesWritten = int64(len(value))
+	})
+
+	return err
+}
+
+func (driver *driverInstrumented) SetIfNotExists(ctx context.Context, key string, value string, duration time.Duration) (bool, error) {
+	start := time.Now()
+	set, err := driver.driver.SetIfNotExists(ctx, key, value, duration)
+	driver.observeKey(ctx, start, "SetIfNotExists", key, err, func(observation *observation) {
+		if set {
+			observation.bytesWritten = int64(len(value))
+		}
+	})
+
+	return set, err
+}
+
+func (driver *driverInstrumented) SetMany(ctx context.Context, values map[string]string, duration time.Duration) error {
+	start := time.Now()
+	err := driver.driver.SetMany(ctx, values, duration)
+
+	observations := []observation{}
+	byPrefix := map[string]int{}
+	for key, value := range values {
+		prefix := driver.prefix(key)
+
+		index, found := byPrefix[prefix]
+		if !found {
+			index = len(observations)
+			byPrefix[prefix] = index
+			observations = append(observations, observation{
+				operation: "SetMany",
+				key:       key,
+				prefix:    prefix,
+				err:       err,
+			})
+		}
+
+		observations[index].bytesWritten += int64(len(value))
+	}
+
+	driver.record(ctx, start, observations...)
+
+	return err
+}
+
+func (driver *driverInstrumented) TTL(ctx context.Context, key string) (time.Duration, error) {
+	start := time.Now()
+	ttl, err := driver.driver.TTL(ctx, key)
+	driver.observeKey(ctx, start, "TTL", key, err, nil)
+
+	return ttl, err
+}
+
+func isMetadataKey(key string) bool {
+	return strings.HasSuffix(key, tagsKey("")) ||
+		strings.HasSuffix(key, rememberMetaKey("")) ||
+		strings.HasSuffix(key, rememberLockKey(""))
+}
+
+func observeRead(observation *observation, value string, err error) {
+	switch {
+	case err == nil:
+		observation.hits = 1
+		observation.bytesRead = int64(len(value))
+	case errors.Is(err, ErrNotFound):
+		observation.misses = 1
+	}
+}
@@ -0,0 +1,87 @@
+package cache_test
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/cache"
+	"gotest.tools/v3/assert"
+)
+
+func TestDriverInstrumented(t *testing.T) {
+	t.Parallel()
+
+	memory, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	metrics := cache.NewMetrics()
+	logs := &bytes.Buffer{}
+	driver := cache.NewDriverInstrumented(
+		memory,
+		cache.WithMetrics(metrics),
+		cache.WithKeyPrefixes("counter"),
+		cache.WithDebugLogger(slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+	)
+	defer func() {
+		_ = driver.Close()
+	}()
+
+	testCase(t, driver)
+	metrics.Reset()
+
+	// Prefixes containing the separator are told apart once a repository uses them
+	profiles := cache.NewRepository[int, string](driver, "user-profile")
+	sessions := cache.NewRepository[string, string](driver, "session")
+
+	assert.NilError(t, profiles.Set(t.Context(), 1, "Alice", time.Minute))
+	_, err = profiles.Get(t.Context(), 1)
+	assert.NilError(t, err)
+	_, err = profiles.Get(t.Context(), 2)
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+	_, err = sessions.Get(t.Context(), "missing")
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+
+	_, err = driver.Increment(t.Context(), "counter:a", 1)
+	assert.NilError(t, err)
+	assert.NilError(t, driver.Set(t.Context(), "text:a", "not a number", time.Minute))
+	_, err = driver.Increment(t.Context(), "text:a", 1)
+	assert.ErrorIs(t, err, cache.ErrNotInteger)
+
+	// Keys without a known prefix share one group however many there are
+	for i := range 10 {
+		_, err = driver.Get(t.Context(), fmt.Sprintf("random-%d", i))
+		assert.ErrorIs(t, err, cache.ErrNotFound)
+	}
+
+	byPrefix := map[string]cache.PrefixMetrics{}
+	for _, prefixMetrics := range metrics.Snapshot() {
+		byPrefix[prefixMetrics.Prefix] = prefixMetrics
+	}
+
+	profileMetrics := byPrefix["user-profile"]
+	assert.Equal(t, profileMetrics.Hits, int64(1))
+	assert.Equal(t, profileMetrics.Misses, int64(1))
+	assert.Equal(t, profileMetrics.Errors, int64(0))
+	assert.Equal(t, profileMetrics.BytesWritten, int64(len(`"Alice"`)))
+	assert.Equal(t, profileMetrics.BytesRead, int64(len(`"Alice"`)))
+	assert.Equal(t, profileMetrics.HitRatio(), 0.5)
+	assert.Assert(t, profileMetrics.Operations > 0 && profileMetrics.MaxLatency > 0)
+
+	assert.Equal(t, byPrefix["session"].Misses, int64(1))
+	assert.Equal(t, byPrefix["counter"].Operations, int64(1))
+	assert.Equal(t, byPrefix[cache.OtherPrefix].Errors, int64(1))
+	assert.Equal(t, byPrefix[cache.OtherPrefix].Misses, int64(10))
+	assert.Equal(t, len(byPrefix), 4)
+
+	// The statistics of the wrapped driver are still reported
+	statsReporter, ok := driver.(cache.StatsReporter)
+	assert.Assert(t, ok)
+	assert.Equal(t, statsReporter.Stats(), memory.(cache.StatsReporter).Stats())
+
+	assert.Assert(t, strings.Contains(logs.String(), `msg="Cache Operation" operation=GetMany key=user-profile-1 prefix=user-profile`))
+	assert.Assert(t, strings.Contains(logs.String(), "error="))
+}
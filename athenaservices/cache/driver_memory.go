@@ -1,26 +1,118 @@
 package cache
 
 import (
+	"container/heap"
+	"container/list"
 	"context"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type recallItem struct {
-	Key       string
-	Value     string
-	ExpiresAt time.Time
+type EvictionPolicy string
+
+const (
+	EvictionLRU EvictionPolicy = "lru"
+	EvictionLFU EvictionPolicy = "lfu"
+)
+
+// Stats are counted since the driver was created, evictions only count
+// entries removed to stay within the limits
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Entries   int64
+	Bytes     int64
 }
 
-func NewDriverMemory() (Driver, error) {
+// StatsReporter is implemented by drivers that keep statistics
+type StatsReporter interface {
+	Stats() Stats
+}
+
+type DriverMemoryConfigFunc func(driver *driverMemory)
+
+// WithMaxEntries limits the number of entries, the least valuable entries
+// according to the eviction policy are removed to make room
+func WithMaxEntries(maxEntries int) DriverMemoryConfigFunc {
+	return func(driver *driverMemory) {
+		driver.maxEntries = maxEntries
+	}
+}
+
+// WithMaxBytes limits the size of the keys and values held
+func WithMaxBytes(maxBytes int64) DriverMemoryConfigFunc {
+	return func(driver *driverMemory) {
+		driver.maxBytes = maxBytes
+	}
+}
+
+func WithEvictionPolicy(policy EvictionPolicy) DriverMemoryConfigFunc {
+	return func(driver *driverMemory) {
+		driver.policy = policy
+	}
+}
+
+// WithShards splits the keys over shards that are locked separately. Limits
+// are divided between the shards so eviction is approximate with more than
+// one shard. Without limits 16 shards are used by default and with limits a
+// single one so they are exact.
+func WithShards(shards int) DriverMemoryConfigFunc {
+	return func(driver *driverMemory) {
+		driver.shardCount = max(shards, 1)
+	}
+}
+
+func WithCleanupInterval(interval time.Duration) DriverMemoryConfigFunc {
+	return func(driver *driverMemory) {
+		driver.cleanupInterval = interval
+	}
+}
+
+func NewDriverMemory(configFuncs ...DriverMemoryConfigFunc) (Driver, error) {
 	driver := &driverMemory{
-		mutex: &sync.Mutex{},
-		data:  map[string]recallItem{},
+		policy:          EvictionLRU,
+		cleanupInterval: time.Minute,
+		stop:            make(chan struct{}),
+	}
+
+	for _, configFunc := range configFuncs {
+		configFunc(driver)
+	}
+
+	if driver.shardCount == 0 {
+		driver.shardCount = 16
+		if driver.maxEntries > 0 || driver.maxBytes > 0 {
+			driver.shardCount = 1
+		}
+	}
+
+	for range driver.shardCount {
+		driver.shards = append(driver.shards, &memoryShard{
+			data:       map[string]*memoryEntry{},
+			policy:     newEvictionTracker(driver.policy),
+			maxEntries: divideLimit(int64(driver.maxEntries), driver.shardCount),
+			maxBytes:   divideLimit(driver.maxBytes, driver.shardCount),
+			evictions:  &driver.evictions,
+		})
 	}
 
 	go func() {
-		for range time.NewTicker(time.Minute).C {
-			driver.cleanup()
+		ticker := time.NewTicker(driver.cleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-driver.stop:
+				return
+			case <-ticker.C:
+				driver.cleanup()
+			}
 		}
 	}()
 
@@ -28,63 +120,487 @@ func NewDriverMemory() (Driver, error) {
 }
 
 type driverMemory struct {
-	mutex *sync.Mutex
-	data  map[string]recallItem
+	shards          []*memoryShard
+	shardCount      int
+	maxEntries      int
+	maxBytes        int64
+	policy          EvictionPolicy
+	cleanupInterval time.Duration
+	stop            chan struct{}
+	stopOnce        sync.Once
+	hits            atomic.Int64
+	misses          atomic.Int64
+	evictions       atomic.Int64
+}
+
+// Close stops the cleanup goroutine
+func (driver *driverMemory) Close() error {
+	driver.stopOnce.Do(func() {
+		close(driver.stop)
+	})
+
+	return nil
+}
+
+func (driver *driverMemory) Decrement(ctx context.Context, key string, delta int64) (int64, error) {
+	return driver.Increment(ctx, key, -delta)
 }
 
 func (driver *driverMemory) Delete(ctx context.Context, key string) error {
-	driver.mutex.Lock()
-	defer driver.mutex.Unlock()
+	shard := driver.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if entry, found := shard.data[key]; found {
+		shard.remove(entry)
+	}
+
+	return nil
+}
+
+func (driver *driverMemory) compareAndDelete(ctx context.Context, key string, value string) error {
+	shard := driver.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if entry, found := shard.get(key, time.Now()); found && entry.Value == value {
+		shard.remove(entry)
+	}
+
+	return nil
+}
+
+func (driver *driverMemory) DeleteByPrefix(ctx context.Context, prefix string) error {
+	for _, shard := range driver.shards {
+		shard.mutex.Lock()
+		for key, entry := range shard.data {
+			if strings.HasPrefix(key, prefix) {
+				shard.remove(entry)
+			}
+		}
+		shard.mutex.Unlock()
+	}
 
-	delete(driver.data, key)
+	return nil
+}
+
+func (driver *driverMemory) Expire(ctx context.Context, key string, duration time.Duration) error {
+	shard := driver.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	entry, found := shard.get(key, time.Now())
+	if !found {
+		return ErrNotFound
+	}
+
+	entry.ExpiresAt = expiresAt(duration)
+
+	return nil
+}
+
+func (driver *driverMemory) Flush(ctx context.Context) error {
+	for _, shard := range driver.shards {
+		shard.mutex.Lock()
+		shard.data = map[string]*memoryEntry{}
+		shard.policy = newEvictionTracker(driver.policy)
+		shard.bytes = 0
+		shard.mutex.Unlock()
+	}
 
 	return nil
 }
 
 func (driver *driverMemory) Get(ctx context.Context, key string) (string, error) {
-	driver.mutex.Lock()
-	defer driver.mutex.Unlock()
+	shard := driver.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
 
-	item, found := driver.data[key]
+	entry, found := shard.get(key, time.Now())
 	if !found {
+		driver.misses.Add(1)
 		return "", ErrNotFound
 	}
 
-	if time.Since(item.ExpiresAt) >= 0 {
-		return "", ErrNotFound
+	driver.hits.Add(1)
+	shard.policy.accessed(entry)
+
+	return entry.Value, nil
+}
+
+func (driver *driverMemory) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, key := range keys {
+		value, err := driver.Get(ctx, key)
+		if err != nil {
+			continue
+		}
+
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+// Increment keeps the expiration of existing keys, missing keys start at zero
+// and don't expire
+func (driver *driverMemory) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	return driver.IncrementWithExpiry(ctx, key, delta, NoExpiration)
+}
+
+func (driver *driverMemory) IncrementWithExpiry(ctx context.Context, key string, delta int64, duration time.Duration) (int64, error) {
+	shard := driver.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	current := int64(0)
+	expiration := expiresAt(duration)
+	if entry, found := shard.get(key, time.Now()); found {
+		value, err := strconv.ParseInt(entry.Value, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+
+		current = value
+		expiration = entry.ExpiresAt
 	}
 
-	return item.Value, nil
+	current += delta
+	shard.set(key, strconv.FormatInt(current, 10), expiration)
+
+	return current, nil
 }
 
 func (driver *driverMemory) Set(ctx context.Context, key string, value string, duration time.Duration) error {
-	driver.mutex.Lock()
-	defer driver.mutex.Unlock()
+	shard := driver.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	shard.set(key, value, expiresAt(duration))
 
-	driver.data[key] = recallItem{
-		Key:       key,
-		Value:     value,
-		ExpiresAt: time.Now().Add(duration),
+	return nil
+}
+
+func (driver *driverMemory) SetIfNotExists(ctx context.Context, key string, value string, duration time.Duration) (bool, error) {
+	shard := driver.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if _, found := shard.get(key, time.Now()); found {
+		return false, nil
+	}
+
+	shard.set(key, value, expiresAt(duration))
+
+	return true, nil
+}
+
+// SetMany holds the locks of every shard involved so the values show up at
+// once
+func (driver *driverMemory) SetMany(ctx context.Context, values map[string]string, duration time.Duration) error {
+	shardIndexes := []int{}
+	for key := range values {
+		if index := driver.shardIndex(key); !slices.Contains(shardIndexes, index) {
+			shardIndexes = append(shardIndexes, index)
+		}
+	}
+
+	// Always lock in the same order to avoid deadlocks
+	slices.Sort(shardIndexes)
+	for _, index := range shardIndexes {
+		driver.shards[index].mutex.Lock()
+		defer driver.shards[index].mutex.Unlock()
+	}
+
+	for key, value := range values {
+		driver.shard(key).set(key, value, expiresAt(duration))
 	}
 
 	return nil
 }
 
+func (driver *driverMemory) TTL(ctx context.Context, key string) (time.Duration, error) {
+	shard := driver.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	entry, found := shard.get(key, time.Now())
+	if !found {
+		return 0, ErrNotFound
+	}
+
+	if entry.ExpiresAt.IsZero() {
+		return NoExpiration, nil
+	}
+
+	return time.Until(entry.ExpiresAt), nil
+}
+
+func (driver *driverMemory) Stats() Stats {
+	stats := Stats{
+		Hits:      driver.hits.Load(),
+		Misses:    driver.misses.Load(),
+		Evictions: driver.evictions.Load(),
+	}
+
+	for _, shard := range driver.shards {
+		shard.mutex.Lock()
+		stats.Entries += int64(len(shard.data))
+		stats.Bytes += shard.bytes
+		shard.mutex.Unlock()
+	}
+
+	return stats
+}
+
+func (driver *driverMemory) shardIndex(key string) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+
+	return int(hash.Sum32() % uint32(len(driver.shards)))
+}
+
+func (driver *driverMemory) shard(key string) *memoryShard {
+	return driver.shards[driver.shardIndex(key)]
+}
+
 func (driver *driverMemory) cleanup() {
-	driver.mutex.Lock()
-	defer driver.mutex.Unlock()
+	now := time.Now()
+	for _, shard := range driver.shards {
+		shard.mutex.Lock()
+		for _, entry := range shard.data {
+			if entry.expired(now) {
+				shard.remove(entry)
+			}
+		}
+		shard.mutex.Unlock()
+	}
+}
+
+func expiresAt(duration time.Duration) time.Time {
+	if duration <= 0 {
+		return time.Time{}
+	}
 
-	keysToDelete := []string{}
+	return time.Now().Add(duration)
+}
 
-	for key, value := range driver.data {
-		if !time.Now().After(value.ExpiresAt) {
-			continue
+// divideLimit splits a limit between the shards, rounding up so small limits
+// don't become zero (unlimited)
+func divideLimit(limit int64, shards int) int64 {
+	if limit <= 0 {
+		return 0
+	}
+
+	return (limit + int64(shards) - 1) / int64(shards)
+}
+
+type memoryEntry struct {
+	Key       string
+	Value     string
+	ExpiresAt time.Time
+	// Bookkeeping of the eviction policies
+	element   *list.Element
+	frequency int64
+	lastUsed  int64
+	heapIndex int
+}
+
+// expired is false for entries without an expiration
+func (entry *memoryEntry) expired(now time.Time) bool {
+	return !entry.ExpiresAt.IsZero() && !now.Before(entry.ExpiresAt)
+}
+
+func (entry *memoryEntry) size() int64 {
+	return int64(len(entry.Key) + len(entry.Value))
+}
+
+// memoryShard must only be used with its mutex held
+type memoryShard struct {
+	mutex      sync.Mutex
+	data       map[string]*memoryEntry
+	policy     evictionTracker
+	bytes      int64
+	maxEntries int64
+	maxBytes   int64
+	evictions  *atomic.Int64
+}
+
+func (shard *memoryShard) get(key string, now time.Time) (*memoryEntry, bool) {
+	entry, found := shard.data[key]
+	if !found {
+		return nil, false
+	}
+
+	if entry.expired(now) {
+		shard.remove(entry)
+		return nil, false
+	}
+
+	return entry, true
+}
+
+func (shard *memoryShard) set(key string, value string, expiresAt time.Time) {
+	entry, found := shard.data[key]
+	if found {
+		shard.bytes += int64(len(value) - len(entry.Value))
+		entry.Value = value
+		entry.ExpiresAt = expiresAt
+		shard.policy.accessed(entry)
+	} else {
+		entry = &memoryEntry{
+			Key:       key,
+			Value:     value,
+			ExpiresAt: expiresAt,
 		}
 
-		keysToDelete = append(keysToDelete, key)
+		// Make room first so a new entry isn't its own victim, which LFU would
+		// otherwise always pick
+		for shard.overLimit(1, entry.size()) {
+			if !shard.evict() {
+				break
+			}
+		}
+
+		shard.data[key] = entry
+		shard.bytes += entry.size()
+		shard.policy.added(entry)
+	}
+
+	for shard.overLimit(0, 0) {
+		if !shard.evict() {
+			break
+		}
 	}
+}
 
-	for _, key := range keysToDelete {
-		delete(driver.data, key)
+func (shard *memoryShard) evict() bool {
+	victim := shard.policy.victim()
+	if victim == nil {
+		return false
 	}
+
+	shard.remove(victim)
+	shard.evictions.Add(1)
+
+	return true
+}
+
+// overLimit tells if the shard is over its limits once the extra entries and
+// bytes are added
+func (shard *memoryShard) overLimit(extraEntries int64, extraBytes int64) bool {
+	return (shard.maxEntries > 0 && int64(len(shard.data))+extraEntries > shard.maxEntries) ||
+		(shard.maxBytes > 0 && shard.bytes+extraBytes > shard.maxBytes)
+}
+
+func (shard *memoryShard) remove(entry *memoryEntry) {
+	delete(shard.data, entry.Key)
+	shard.bytes -= entry.size()
+	shard.policy.removed(entry)
+}
+
+// evictionTracker orders the entries of a shard by how valuable they are
+type evictionTracker interface {
+	added(entry *memoryEntry)
+	accessed(entry *memoryEntry)
+	removed(entry *memoryEntry)
+	victim() *memoryEntry
+}
+
+func newEvictionTracker(policy EvictionPolicy) evictionTracker {
+	if policy == EvictionLFU {
+		return &lfuTracker{}
+	}
+
+	return &lruTracker{list: list.New()}
+}
+
+// lruTracker keeps the most recently used entries at the front of the list
+type lruTracker struct {
+	list *list.List
+}
+
+func (tracker *lruTracker) added(entry *memoryEntry) {
+	entry.element = tracker.list.PushFront(entry)
+}
+
+func (tracker *lruTracker) accessed(entry *memoryEntry) {
+	tracker.list.MoveToFront(entry.element)
+}
+
+func (tracker *lruTracker) removed(entry *memoryEntry) {
+	tracker.list.Remove(entry.element)
+}
+
+func (tracker *lruTracker) victim() *memoryEntry {
+	back := tracker.list.Back()
+	if back == nil {
+		return nil
+	}
+
+	return back.Value.(*memoryEntry)
+}
+
+// lfuTracker is a heap of the entries by use count, ties going to the least
+// recently used
+type lfuTracker struct {
+	entries []*memoryEntry
+	clock   int64
+}
+
+func (tracker *lfuTracker) added(entry *memoryEntry) {
+	tracker.clock++
+	entry.frequency = 1
+	entry.lastUsed = tracker.clock
+	heap.Push(tracker, entry)
+}
+
+func (tracker *lfuTracker) accessed(entry *memoryEntry) {
+	tracker.clock++
+	entry.frequency++
+	entry.lastUsed = tracker.clock
+	heap.Fix(tracker, entry.heapIndex)
+}
+
+func (tracker *lfuTracker) removed(entry *memoryEntry) {
+	heap.Remove(tracker, entry.heapIndex)
+}
+
+func (tracker *lfuTracker) victim() *memoryEntry {
+	if len(tracker.entries) == 0 {
+		return nil
+	}
+
+	return tracker.entries[0]
+}
+
+func (tracker *lfuTracker) Len() int {
+	return len(tracker.entries)
+}
+
+func (tracker *lfuTracker) Less(i int, j int) bool {
+	if tracker.entries[i].frequency != tracker.entries[j].frequency {
+		return tracker.entries[i].frequency < tracker.entries[j].frequency
+	}
+
+	return tracker.entries[i].lastUsed < tracker.entries[j].lastUsed
+}
+
+func (tracker *lfuTracker) Swap(i int, j int) {
+	tracker.entries[i], tracker.entries[j] = tracker.entries[j], tracker.entries[i]
+	tracker.entries[i].heapIndex = i
+	tracker.entries[j].heapIndex = j
+}
+
+func (tracker *lfuTracker) Push(x any) {
+	entry := x.(*memoryEntry)
+	entry.heapIndex = len(tracker.entries)
+	tracker.entries = append(tracker.entries, entry)
+}
+
+func (tracker *lfuTracker) Pop() any {
+	last := tracker.entries[len(tracker.entries)-1]
+	tracker.entries = tracker.entries[:len(tracker.entries)-1]
+
+	return last
 }
@@ -1,9 +1,12 @@
 package cache_test
 
 import (
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/lunagic/athena/athenaservices/cache"
+	"gotest.tools/v3/assert"
 )
 
 func TestDriverMemory(t *testing.T) {
@@ -13,6 +16,98 @@ func TestDriverMemory(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer func() {
+		_ = driver.Close()
+	}()
 
 	testCase(t, driver)
 }
+
+func TestDriverMemoryEviction(t *testing.T) {
+	t.Parallel()
+
+	{ // LRU evicts the least recently used entry
+		driver, err := cache.NewDriverMemory(cache.WithShards(1), cache.WithMaxEntries(2))
+		assert.NilError(t, err)
+		defer func() {
+			_ = driver.Close()
+		}()
+
+		assert.NilError(t, driver.Set(t.Context(), "a", "1", time.Minute))
+		assert.NilError(t, driver.Set(t.Context(), "b", "2", time.Minute))
+		_, err = driver.Get(t.Context(), "a")
+		assert.NilError(t, err)
+		assert.NilError(t, driver.Set(t.Context(), "c", "3", time.Minute))
+
+		_, err = driver.Get(t.Context(), "b")
+		assert.ErrorIs(t, err, cache.ErrNotFound)
+
+		assert.DeepEqual(t, driver.(cache.StatsReporter).Stats(), cache.Stats{
+			Hits:      1,
+			Misses:    1,
+			Evictions: 1,
+			Entries:   2,
+			Bytes:     4,
+		})
+	}
+
+	{ // LFU evicts the least frequently used entry
+		driver, err := cache.NewDriverMemory(cache.WithShards(1), cache.WithMaxEntries(2), cache.WithEvictionPolicy(cache.EvictionLFU))
+		assert.NilError(t, err)
+		defer func() {
+			_ = driver.Close()
+		}()
+
+		assert.NilError(t, driver.Set(t.Context(), "a", "1", time.Minute))
+		assert.NilError(t, driver.Set(t.Context(), "b", "2", time.Minute))
+		for range 3 {
+			_, err = driver.Get(t.Context(), "a")
+			assert.NilError(t, err)
+		}
+		_, err = driver.Get(t.Context(), "b")
+		assert.NilError(t, err)
+		assert.NilError(t, driver.Set(t.Context(), "c", "3", time.Minute))
+
+		_, err = driver.Get(t.Context(), "b")
+		assert.ErrorIs(t, err, cache.ErrNotFound)
+		_, err = driver.Get(t.Context(), "a")
+		assert.NilError(t, err)
+	}
+
+	{ // Byte limits count keys and values
+		driver, err := cache.NewDriverMemory(cache.WithShards(1), cache.WithMaxBytes(10))
+		assert.NilError(t, err)
+		defer func() {
+			_ = driver.Close()
+		}()
+
+		assert.NilError(t, driver.Set(t.Context(), "a", "1234", time.Minute))
+		assert.NilError(t, driver.Set(t.Context(), "b", "1234", time.Minute))
+		assert.NilError(t, driver.Set(t.Context(), "c", "1234", time.Minute))
+
+		stats := driver.(cache.StatsReporter).Stats()
+		assert.Equal(t, stats.Entries, int64(2))
+		assert.Equal(t, stats.Bytes, int64(10))
+
+		_, err = driver.Get(t.Context(), "a")
+		assert.ErrorIs(t, err, cache.ErrNotFound)
+	}
+
+	{ // Limits are exact without asking for shards
+		driver, err := cache.NewDriverMemory(cache.WithMaxEntries(3))
+		assert.NilError(t, err)
+		defer func() {
+			_ = driver.Close()
+		}()
+
+		for i := range 10 {
+			assert.NilError(t, driver.Set(t.Context(), strconv.Itoa(i), "value", time.Minute))
+		}
+
+		assert.Equal(t, driver.(cache.StatsReporter).Stats().Entries, int64(3))
+		for _, key := range []string{"7", "8", "9"} {
+			_, err = driver.Get(t.Context(), key)
+			assert.NilError(t, err)
+		}
+	}
+}
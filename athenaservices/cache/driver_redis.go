@@ -2,34 +2,176 @@ package cache
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	redis "github.com/redis/go-redis/v9"
 )
 
 type DriverRedisConfig struct {
+	// URL replaces the other connection fields when set, rediss:// connects
+	// with TLS
+	URL    string
 	Host   string
 	Number int
 	Pass   string
 	Port   int
 	User   string
+	// TLS connects with TLS, TLSConfig can be given for custom certificates
+	TLS       bool
+	TLSConfig *tls.Config
+	// SentinelMasterName connects to the master Sentinel reports for the name
+	SentinelMasterName string
+	SentinelAddresses  []string
+	SentinelPass       string
+	// ClusterAddresses connects to a Redis Cluster through any of its nodes
+	ClusterAddresses []string
 }
 
 func NewDriverRedis(config DriverRedisConfig) (Driver, error) {
+	client, err := newRedisClient(config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &driverRedis{
-		client: redis.NewClient(&redis.Options{
-			Addr:     fmt.Sprintf("%s:%d", config.Host, config.Port),
-			Username: config.User,
-			Password: config.Pass,
-			DB:       config.Number,
-		}),
+		client: client,
 	}, nil
 }
 
+func newRedisClient(config DriverRedisConfig) (redis.UniversalClient, error) {
+	tlsConfig := config.TLSConfig
+	if tlsConfig == nil && config.TLS {
+		tlsConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	if config.URL != "" {
+		options, err := redis.ParseURL(config.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		if tlsConfig != nil {
+			options.TLSConfig = tlsConfig
+		}
+
+		return redis.NewClient(options), nil
+	}
+
+	options := &redis.UniversalOptions{
+		Addrs:            []string{fmt.Sprintf("%s:%d", config.Host, config.Port)},
+		Username:         config.User,
+		Password:         config.Pass,
+		DB:               config.Number,
+		TLSConfig:        tlsConfig,
+		MasterName:       config.SentinelMasterName,
+		SentinelPassword: config.SentinelPass,
+	}
+
+	if config.SentinelMasterName != "" {
+		options.Addrs = config.SentinelAddresses
+	}
+
+	// The universal client only picks cluster mode for more than one address
+	if len(config.ClusterAddresses) > 0 {
+		options.Addrs = config.ClusterAddresses
+
+		return redis.NewClusterClient(options.Cluster()), nil
+	}
+
+	return redis.NewUniversalClient(options), nil
+}
+
 type driverRedis struct {
-	client *redis.Client
+	client redis.UniversalClient
+}
+
+func (driver *driverRedis) Close() error {
+	return driver.client.Close()
+}
+
+func (driver *driverRedis) Decrement(ctx context.Context, key string, delta int64) (int64, error) {
+	result, err := driver.client.DecrBy(ctx, key, delta).Result()
+
+	return result, redisIntegerError(err)
+}
+
+func (driver *driverRedis) Delete(ctx context.Context, key string) error {
+	return driver.client.Del(ctx, key).Err()
+}
+
+var redisCompareAndDelete = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (driver *driverRedis) compareAndDelete(ctx context.Context, key string, value string) error {
+	return redisCompareAndDelete.Run(ctx, driver.client, []string{key}, value).Err()
+}
+
+// DeleteByPrefix scans rather than using KEYS so the server isn't blocked
+func (driver *driverRedis) DeleteByPrefix(ctx context.Context, prefix string) error {
+	return driver.forEachNode(ctx, func(ctx context.Context, client *redis.Client) error {
+		iterator := client.Scan(ctx, 0, redisEscapePattern(prefix)+"*", 1000).Iterator()
+
+		keys := []string{}
+		for iterator.Next(ctx) {
+			keys = append(keys, iterator.Val())
+			if len(keys) < 1000 {
+				continue
+			}
+
+			if err := driver.deleteKeys(ctx, keys); err != nil {
+				return err
+			}
+			keys = []string{}
+		}
+
+		if err := iterator.Err(); err != nil {
+			return err
+		}
+
+		return driver.deleteKeys(ctx, keys)
+	})
+}
+
+func (driver *driverRedis) Expire(ctx context.Context, key string, duration time.Duration) error {
+	var (
+		found bool
+		err   error
+	)
+	if duration <= 0 {
+		// PERSIST is false for keys without an expiration too
+		found, err = driver.client.Persist(ctx, key).Result()
+		if err == nil && !found {
+			found, err = driver.exists(ctx, key)
+		}
+	} else {
+		found, err = driver.client.PExpire(ctx, key, duration).Result()
+	}
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (driver *driverRedis) Flush(ctx context.Context) error {
+	return driver.forEachNode(ctx, func(ctx context.Context, client *redis.Client) error {
+		return client.FlushDB(ctx).Err()
+	})
 }
 
 func (driver *driverRedis) Get(ctx context.Context, key string) (string, error) {
@@ -45,10 +187,219 @@ func (driver *driverRedis) Get(ctx context.Context, key string) (string, error)
 	return result, nil
 }
 
+func (driver *driverRedis) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	values := map[string]string{}
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	// Keys in different slots can't be read with a single MGET in a cluster
+	if driver.isCluster() {
+		commands := []*redis.StringCmd{}
+		if _, err := driver.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				commands = append(commands, pipe.Get(ctx, key))
+			}
+
+			return nil
+		}); err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+
+		for i, command := range commands {
+			value, err := command.Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			values[keys[i]] = value
+		}
+
+		return values, nil
+	}
+
+	results, err := driver.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range results {
+		if value, isString := result.(string); isString {
+			values[keys[i]] = value
+		}
+	}
+
+	return values, nil
+}
+
+func (driver *driverRedis) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	result, err := driver.client.IncrBy(ctx, key, delta).Result()
+
+	return result, redisIntegerError(err)
+}
+
+var redisIncrementWithExpiry = redis.NewScript(`
+local created = redis.call("EXISTS", KEYS[1]) == 0
+local result = redis.call("INCRBY", KEYS[1], ARGV[1])
+if created and tonumber(ARGV[2]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return result
+`)
+
+func (driver *driverRedis) IncrementWithExpiry(ctx context.Context, key string, delta int64, duration time.Duration) (int64, error) {
+	// Rounded up so durations below a millisecond still expire
+	milliseconds := (redisDuration(duration) + time.Millisecond - 1).Milliseconds()
+	result, err := redisIncrementWithExpiry.Run(ctx, driver.client, []string{key}, delta, milliseconds).Int64()
+
+	return result, redisIntegerError(err)
+}
+
 func (driver *driverRedis) Set(ctx context.Context, key string, value string, duration time.Duration) error {
-	return driver.client.Set(ctx, key, value, duration).Err()
+	return driver.client.Set(ctx, key, value, redisDuration(duration)).Err()
 }
 
-func (driver *driverRedis) Delete(ctx context.Context, key string) error {
-	return driver.client.Del(ctx, key).Err()
+func (driver *driverRedis) SetIfNotExists(ctx context.Context, key string, value string, duration time.Duration) (bool, error) {
+	return driver.client.SetNX(ctx, key, value, redisDuration(duration)).Result()
+}
+
+// SetMany sets the values in a transaction since MSET can't set expirations,
+// in a cluster the keys can be in different slots so they are only pipelined
+func (driver *driverRedis) SetMany(ctx context.Context, values map[string]string, duration time.Duration) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	pipelined := driver.client.TxPipelined
+	if driver.isCluster() {
+		pipelined = driver.client.Pipelined
+	}
+
+	_, err := pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(ctx, key, value, redisDuration(duration))
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+func (driver *driverRedis) TTL(ctx context.Context, key string) (time.Duration, error) {
+	result, err := driver.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// Redis answers -2 for missing keys and -1 for keys without an expiration
+	switch result {
+	case -2:
+		return 0, ErrNotFound
+	case -1:
+		return NoExpiration, nil
+	}
+
+	return result, nil
+}
+
+func (driver *driverRedis) publishInvalidation(ctx context.Context, channel string, message invalidation) error {
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	return driver.client.Publish(ctx, channel, string(messageBytes)).Err()
+}
+
+func (driver *driverRedis) subscribeInvalidation(ctx context.Context, channel string, handler func(message invalidation)) (func() error, error) {
+	subscription := driver.client.Subscribe(ctx, channel)
+
+	// Wait for the subscription to be confirmed so no invalidation is missed
+	if _, err := subscription.Receive(ctx); err != nil {
+		_ = subscription.Close()
+		return nil, err
+	}
+
+	go func() {
+		for redisMessage := range subscription.Channel() {
+			message := invalidation{}
+			if err := json.Unmarshal([]byte(redisMessage.Payload), &message); err != nil {
+				continue
+			}
+
+			handler(message)
+		}
+	}()
+
+	return subscription.Close, nil
+}
+
+func (driver *driverRedis) isCluster() bool {
+	_, isCluster := driver.client.(*redis.ClusterClient)
+
+	return isCluster
+}
+
+// forEachNode runs fn on every master of a cluster or on the one server
+func (driver *driverRedis) forEachNode(ctx context.Context, fn func(ctx context.Context, client *redis.Client) error) error {
+	switch client := driver.client.(type) {
+	case *redis.ClusterClient:
+		return client.ForEachMaster(ctx, fn)
+	case *redis.Client:
+		return fn(ctx, client)
+	}
+
+	return fmt.Errorf("unsupported redis client: %T", driver.client)
+}
+
+// deleteKeys deletes the keys one by one in a pipeline since a single DEL
+// can't span cluster slots
+func (driver *driverRedis) deleteKeys(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := driver.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+func (driver *driverRedis) exists(ctx context.Context, key string) (bool, error) {
+	count, err := driver.client.Exists(ctx, key).Result()
+
+	return count > 0, err
+}
+
+// redisDuration maps durations meaning no expiration to zero, negative values
+// would be taken as KEEPTTL
+func redisDuration(duration time.Duration) time.Duration {
+	if duration <= 0 {
+		return 0
+	}
+
+	return duration
+}
+
+func redisIntegerError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "not an integer") {
+		return fmt.Errorf("%w: %w", ErrNotInteger, err)
+	}
+
+	return err
+}
+
+func redisEscapePattern(pattern string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+	return replacer.Replace(pattern)
 }
@@ -65,4 +65,146 @@ func testCase(t *testing.T, driver cache.Driver) {
 		_, expiredCheckErr := driver.Get(t.Context(), key)
 		assert.ErrorIs(t, expiredCheckErr, cache.ErrNotFound)
 	}
+
+	{ // Counters start at zero and keep going from the stored value
+		key = uuid.NewString()
+
+		count, err := driver.Increment(t.Context(), key, 5)
+		assert.NilError(t, err)
+		assert.Equal(t, count, int64(5))
+
+		count, err = driver.Decrement(t.Context(), key, 2)
+		assert.NilError(t, err)
+		assert.Equal(t, count, int64(3))
+
+		actualValue, err := driver.Get(t.Context(), key)
+		assert.NilError(t, err)
+		assert.Equal(t, actualValue, "3")
+
+		notInteger := uuid.NewString()
+		assert.NilError(t, driver.Set(t.Context(), notInteger, "abc", time.Minute))
+		_, err = driver.Increment(t.Context(), notInteger, 1)
+		assert.ErrorIs(t, err, cache.ErrNotInteger)
+	}
+
+	{ // Counters created with an expiry keep it while they count
+		key = uuid.NewString()
+
+		count, err := driver.IncrementWithExpiry(t.Context(), key, 1, time.Minute)
+		assert.NilError(t, err)
+		assert.Equal(t, count, int64(1))
+
+		count, err = driver.IncrementWithExpiry(t.Context(), key, 1, time.Hour)
+		assert.NilError(t, err)
+		assert.Equal(t, count, int64(2))
+
+		ttl, err := driver.TTL(t.Context(), key)
+		assert.NilError(t, err)
+		assert.Assert(t, ttl > 0 && ttl <= time.Minute)
+	}
+
+	{ // Only the first set if not exists wins
+		key = uuid.NewString()
+
+		set, err := driver.SetIfNotExists(t.Context(), key, "first", time.Minute)
+		assert.NilError(t, err)
+		assert.Assert(t, set)
+
+		set, err = driver.SetIfNotExists(t.Context(), key, "second", time.Minute)
+		assert.NilError(t, err)
+		assert.Assert(t, !set)
+
+		actualValue, err := driver.Get(t.Context(), key)
+		assert.NilError(t, err)
+		assert.Equal(t, actualValue, "first")
+	}
+
+	{ // TTL and Expire
+		key = uuid.NewString()
+
+		_, err := driver.TTL(t.Context(), key)
+		assert.ErrorIs(t, err, cache.ErrNotFound)
+		assert.ErrorIs(t, driver.Expire(t.Context(), key, time.Minute), cache.ErrNotFound)
+
+		assert.NilError(t, driver.Set(t.Context(), key, value, cache.NoExpiration))
+		ttl, err := driver.TTL(t.Context(), key)
+		assert.NilError(t, err)
+		assert.Equal(t, ttl, cache.NoExpiration)
+
+		assert.NilError(t, driver.Expire(t.Context(), key, time.Minute))
+		ttl, err = driver.TTL(t.Context(), key)
+		assert.NilError(t, err)
+		assert.Assert(t, ttl > 50*time.Second && ttl <= time.Minute, ttl)
+
+		assert.NilError(t, driver.Expire(t.Context(), key, 0))
+		ttl, err = driver.TTL(t.Context(), key)
+		assert.NilError(t, err)
+		assert.Equal(t, ttl, cache.NoExpiration)
+	}
+
+	{ // Multiple keys at once, missing keys are left out
+		prefix := uuid.NewString()
+		values := map[string]string{
+			prefix + "-a": "a",
+			prefix + "-b": "b",
+		}
+		assert.NilError(t, driver.SetMany(t.Context(), values, time.Minute))
+
+		actualValues, err := driver.GetMany(t.Context(), []string{prefix + "-a", prefix + "-b", prefix + "-missing"})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, actualValues, values)
+
+		other := uuid.NewString()
+		assert.NilError(t, driver.Set(t.Context(), other, value, time.Minute))
+
+		assert.NilError(t, driver.DeleteByPrefix(t.Context(), prefix))
+		actualValues, err = driver.GetMany(t.Context(), []string{prefix + "-a", prefix + "-b"})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, actualValues, map[string]string{})
+
+		actualValue, err := driver.Get(t.Context(), other)
+		assert.NilError(t, err)
+		assert.Equal(t, actualValue, value)
+
+		assert.NilError(t, driver.Flush(t.Context()))
+		_, err = driver.Get(t.Context(), other)
+		assert.ErrorIs(t, err, cache.ErrNotFound)
+	}
+
+	{ // Invalidating a tag drops the values stored with it
+		tag := uuid.NewString()
+		repository := cache.NewRepository[string, string](driver, uuid.NewString())
+
+		assert.NilError(t, repository.SetWithTags(t.Context(), "tagged", value, time.Second*30, tag))
+		assert.NilError(t, repository.Set(t.Context(), "untagged", value, time.Second*30))
+
+		actualValue, err := repository.Get(t.Context(), "tagged")
+		assert.NilError(t, err)
+		assert.Equal(t, actualValue, value)
+
+		assert.NilError(t, repository.InvalidateTags(t.Context(), tag))
+
+		_, err = repository.Get(t.Context(), "tagged")
+		assert.ErrorIs(t, err, cache.ErrNotFound)
+
+		actualValue, err = repository.Get(t.Context(), "untagged")
+		assert.NilError(t, err)
+		assert.Equal(t, actualValue, value)
+	}
+
+	{ // Repositories only store values of missing keys
+		repository := cache.NewRepository[string, string](driver, uuid.NewString())
+
+		stored, err := repository.SetIfNotExists(t.Context(), "claimed", value, time.Second*30)
+		assert.NilError(t, err)
+		assert.Assert(t, stored)
+
+		stored, err = repository.SetIfNotExists(t.Context(), "claimed", "other", time.Second*30)
+		assert.NilError(t, err)
+		assert.Assert(t, !stored)
+
+		actualValue, err := repository.Get(t.Context(), "claimed")
+		assert.NilError(t, err)
+		assert.Equal(t, actualValue, value)
+	}
 }
@@ -0,0 +1,326 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DriverTwoTierConfig struct {
+	// LocalTTL caps how long values are kept in the local tier, it bounds how
+	// stale a value can get when an invalidation is missed
+	LocalTTL time.Duration
+	// Channel is where invalidations are broadcast when the remote tier
+	// supports it
+	Channel string
+}
+
+// invalidation tells the other instances which local copies are outdated
+type invalidation struct {
+	Origin string
+	Keys   []string
+	Prefix string
+	Flush  bool
+}
+
+// invalidationBroadcaster is implemented by remote drivers able to tell other
+// instances about changes
+type invalidationBroadcaster interface {
+	publishInvalidation(ctx context.Context, channel string, message invalidation) error
+	subscribeInvalidation(ctx context.Context, channel string, handler func(message invalidation)) (func() error, error)
+}
+
+// NewDriverTwoTier reads through a local driver (usually memory) in front of a
+// shared remote driver (usually Redis). Changes go to both tiers and, when
+// the remote driver supports it, are broadcast so the other instances drop
+// their local copies.
+func NewDriverTwoTier(local Driver, remote Driver, config DriverTwoTierConfig) (Driver, error) {
+	if config.LocalTTL <= 0 {
+		config.LocalTTL = 5 * time.Second
+	}
+
+	if config.Channel == "" {
+		config.Channel = "athena-cache-invalidation"
+	}
+
+	driver := &driverTwoTier{
+		local:  local,
+		remote: remote,
+		config: config,
+		origin: uuid.NewString(),
+	}
+
+	if broadcaster, ok := remote.(invalidationBroadcaster); ok {
+		unsubscribe, err := broadcaster.subscribeInvalidation(context.Background(), config.Channel, driver.invalidate)
+		if err != nil {
+			return nil, err
+		}
+
+		driver.broadcaster = broadcaster
+		driver.unsubscribe = unsubscribe
+	}
+
+	return driver, nil
+}
+
+type driverTwoTier struct {
+	local       Driver
+	remote      Driver
+	config      DriverTwoTierConfig
+	origin      string
+	broadcaster invalidationBroadcaster
+	unsubscribe func() error
+}
+
+func (driver *driverTwoTier) Close() error {
+	if driver.unsubscribe != nil {
+		if err := driver.unsubscribe(); err != nil {
+			return err
+		}
+	}
+
+	if err := driver.local.Close(); err != nil {
+		return err
+	}
+
+	return driver.remote.Close()
+}
+
+func (driver *driverTwoTier) Decrement(ctx context.Context, key string, delta int64) (int64, error) {
+	result, err := driver.remote.Decrement(ctx, key, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return result, driver.changed(ctx, invalidation{Keys: []string{key}})
+}
+
+func (driver *driverTwoTier) Delete(ctx context.Context, key string) error {
+	if err := driver.remote.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	return driver.changed(ctx, invalidation{Keys: []string{key}})
+}
+
+// compareAndDelete checks the value in the remote cache since the local copy
+// can be outdated
+func (driver *driverTwoTier) compareAndDelete(ctx context.Context, key string, value string) error {
+	if err := compareAndDelete(ctx, driver.remote, key, value); err != nil {
+		return err
+	}
+
+	return driver.changed(ctx, invalidation{Keys: []string{key}})
+}
+
+func (driver *driverTwoTier) DeleteByPrefix(ctx context.Context, prefix string) error {
+	if err := driver.remote.DeleteByPrefix(ctx, prefix); err != nil {
+		return err
+	}
+
+	return driver.changed(ctx, invalidation{Prefix: prefix})
+}
+
+func (driver *driverTwoTier) Expire(ctx context.Context, key string, duration time.Duration) error {
+	if err := driver.remote.Expire(ctx, key, duration); err != nil {
+		return err
+	}
+
+	return driver.changed(ctx, invalidation{Keys: []string{key}})
+}
+
+func (driver *driverTwoTier) Flush(ctx context.Context) error {
+	if err := driver.remote.Flush(ctx); err != nil {
+		return err
+	}
+
+	return driver.changed(ctx, invalidation{Flush: true})
+}
+
+func (driver *driverTwoTier) Get(ctx context.Context, key string) (string, error) {
+	if value, err := driver.local.Get(ctx, key); err == nil {
+		return value, nil
+	}
+
+	value, err := driver.remote.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := driver.storeLocally(ctx, map[string]string{key: value}); err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+func (driver *driverTwoTier) GetMany(ctx context.Context, keys []string) (map[string]string, error) {
+	values, err := driver.local.GetMany(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := []string{}
+	for _, key := range keys {
+		if _, found := values[key]; !found {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	remoteValues, err := driver.remote.GetMany(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := driver.storeLocally(ctx, remoteValues); err != nil {
+		return nil, err
+	}
+
+	for key, value := range remoteValues {
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+func (driver *driverTwoTier) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	result, err := driver.remote.Increment(ctx, key, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	return result, driver.changed(ctx, invalidation{Keys: []string{key}})
+}
+
+func (driver *driverTwoTier) IncrementWithExpiry(ctx context.Context, key string, delta int64, duration time.Duration) (int64, error) {
+	result, err := driver.remote.IncrementWithExpiry(ctx, key, delta, duration)
+	if err != nil {
+		return 0, err
+	}
+
+	return result, driver.changed(ctx, invalidation{Keys: []string{key}})
+}
+
+func (driver *driverTwoTier) Set(ctx context.Context, key string, value string, duration time.Duration) error {
+	if err := driver.remote.Set(ctx, key, value, duration); err != nil {
+		return err
+	}
+
+	if err := driver.changed(ctx, invalidation{Keys: []string{key}}); err != nil {
+		return err
+	}
+
+	return driver.local.Set(ctx, key, value, driver.localDuration(duration))
+}
+
+func (driver *driverTwoTier) SetIfNotExists(ctx context.Context, key string, value string, duration time.Duration) (bool, error) {
+	set, err := driver.remote.SetIfNotExists(ctx, key, value, duration)
+	if err != nil || !set {
+		return set, err
+	}
+
+	if err := driver.changed(ctx, invalidation{Keys: []string{key}}); err != nil {
+		return true, err
+	}
+
+	return true, driver.local.Set(ctx, key, value, driver.localDuration(duration))
+}
+
+func (driver *driverTwoTier) SetMany(ctx context.Context, values map[string]string, duration time.Duration) error {
+	if err := driver.remote.SetMany(ctx, values, duration); err != nil {
+		return err
+	}
+
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	if err := driver.changed(ctx, invalidation{Keys: keys}); err != nil {
+		return err
+	}
+
+	return driver.local.SetMany(ctx, values, driver.localDuration(duration))
+}
+
+// TTL always asks the remote tier since local copies expire early
+func (driver *driverTwoTier) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return driver.remote.TTL(ctx, key)
+}
+
+// changed drops the local copies and tells the other instances to do the same
+func (driver *driverTwoTier) changed(ctx context.Context, message invalidation) error {
+	driver.invalidateLocal(message)
+
+	if driver.broadcaster == nil {
+		return nil
+	}
+
+	message.Origin = driver.origin
+
+	return driver.broadcaster.publishInvalidation(ctx, driver.config.Channel, message)
+}
+
+func (driver *driverTwoTier) invalidate(message invalidation) {
+	if message.Origin == driver.origin {
+		return
+	}
+
+	driver.invalidateLocal(message)
+}
+
+func (driver *driverTwoTier) invalidateLocal(message invalidation) {
+	ctx := context.Background()
+
+	if message.Flush {
+		_ = driver.local.Flush(ctx)
+		return
+	}
+
+	if message.Prefix != "" {
+		_ = driver.local.DeleteByPrefix(ctx, message.Prefix)
+	}
+
+	for _, key := range message.Keys {
+		_ = driver.local.Delete(ctx, key)
+	}
+}
+
+// storeLocally copies values read from the remote tier into the local tier,
+// where they are kept no longer than they have left in the remote tier
+func (driver *driverTwoTier) storeLocally(ctx context.Context, values map[string]string) error {
+	for key, value := range values {
+		remaining, err := driver.remote.TTL(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// Expired since it was read
+		if remaining == 0 {
+			continue
+		}
+
+		if err := driver.local.Set(ctx, key, value, driver.localDuration(remaining)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (driver *driverTwoTier) localDuration(duration time.Duration) time.Duration {
+	if duration > 0 && duration < driver.config.LocalTTL {
+		return duration
+	}
+
+	return driver.config.LocalTTL
+}
@@ -0,0 +1,122 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/cache"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+func TestDriverTwoTier(t *testing.T) {
+	t.Parallel()
+
+	local, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	remote, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	driver, err := cache.NewDriverTwoTier(local, remote, cache.DriverTwoTierConfig{
+		LocalTTL: 200 * time.Millisecond,
+	})
+	assert.NilError(t, err)
+	defer func() {
+		_ = driver.Close()
+	}()
+
+	testCase(t, driver)
+
+	{ // Reads go through the local tier until it expires
+		assert.NilError(t, remote.Set(t.Context(), "key", "first", time.Minute))
+
+		value, err := driver.Get(t.Context(), "key")
+		assert.NilError(t, err)
+		assert.Equal(t, value, "first")
+
+		assert.NilError(t, remote.Set(t.Context(), "key", "second", time.Minute))
+
+		value, err = driver.Get(t.Context(), "key")
+		assert.NilError(t, err)
+		assert.Equal(t, value, "first")
+
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			value, err := driver.Get(t.Context(), "key")
+			if err != nil || value != "second" {
+				return poll.Continue("value is %s", value)
+			}
+
+			return poll.Success()
+		})
+	}
+
+	{ // Local copies expire with the remote value
+		for _, read := range []func() error{
+			func() error {
+				_, err := driver.Get(t.Context(), "short")
+				return err
+			},
+			func() error {
+				_, err := driver.GetMany(t.Context(), []string{"short"})
+				return err
+			},
+		} {
+			assert.NilError(t, local.Delete(t.Context(), "short"))
+			assert.NilError(t, remote.Set(t.Context(), "short", "value", 20*time.Millisecond))
+			assert.NilError(t, read())
+
+			ttl, err := local.TTL(t.Context(), "short")
+			assert.NilError(t, err)
+			assert.Assert(t, ttl > 0 && ttl <= 20*time.Millisecond, ttl)
+		}
+	}
+}
+
+// testTwoTierInvalidation checks that changes made by one instance evict the
+// local copies of another instance sharing the remote driver
+func testTwoTierInvalidation(t *testing.T, newRemote func() cache.Driver) {
+	newInstance := func() cache.Driver {
+		local, err := cache.NewDriverMemory()
+		assert.NilError(t, err)
+
+		driver, err := cache.NewDriverTwoTier(local, newRemote(), cache.DriverTwoTierConfig{
+			LocalTTL: time.Minute,
+		})
+		assert.NilError(t, err)
+
+		return driver
+	}
+
+	first := newInstance()
+	second := newInstance()
+
+	testCase(t, first)
+
+	assert.NilError(t, first.Set(t.Context(), "shared", "first", time.Minute))
+
+	value, err := second.Get(t.Context(), "shared")
+	assert.NilError(t, err)
+	assert.Equal(t, value, "first")
+
+	assert.NilError(t, first.Set(t.Context(), "shared", "second", time.Minute))
+
+	poll.WaitOn(t, func(poll.LogT) poll.Result {
+		value, err := second.Get(t.Context(), "shared")
+		if err != nil || value != "second" {
+			return poll.Continue("value is %s", value)
+		}
+
+		return poll.Success()
+	})
+
+	assert.NilError(t, first.Delete(t.Context(), "shared"))
+
+	poll.WaitOn(t, func(poll.LogT) poll.Result {
+		if _, err := second.Get(t.Context(), "shared"); err == nil {
+			return poll.Continue("value still cached")
+		}
+
+		return poll.Success()
+	})
+}
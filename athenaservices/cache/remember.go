@@ -0,0 +1,352 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errRefreshSkipped is returned by background refreshes that found another
+// instance already refreshing
+var errRefreshSkipped = errors.New("refresh skipped")
+
+type rememberConfig struct {
+	lockTTL      time.Duration
+	staleFor     time.Duration
+	earlyRefresh float64
+	tags         []string
+}
+
+type RememberConfigFunc func(config *rememberConfig)
+
+// WithRememberLock takes a lock in the driver while loading so only one
+// instance computes the value, the others wait up to the lock ttl for it
+func WithRememberLock(lockTTL time.Duration) RememberConfigFunc {
+	return func(config *rememberConfig) {
+		config.lockTTL = lockTTL
+	}
+}
+
+// WithStaleWhileRevalidate keeps serving the value for up to staleFor after it
+// expires while it is reloaded in the background
+func WithStaleWhileRevalidate(staleFor time.Duration) RememberConfigFunc {
+	return func(config *rememberConfig) {
+		config.staleFor = staleFor
+	}
+}
+
+// WithEarlyRefresh reloads the value in the background before it expires,
+// with a probability growing as expiry nears and with how long loading takes.
+// A beta of 1 is a good default, higher values refresh earlier.
+func WithEarlyRefresh(beta float64) RememberConfigFunc {
+	return func(config *rememberConfig) {
+		config.earlyRefresh = beta
+	}
+}
+
+// WithRememberTags stores the loaded value with the tags so InvalidateTags
+// drops it
+func WithRememberTags(tags ...string) RememberConfigFunc {
+	return func(config *rememberConfig) {
+		config.tags = tags
+	}
+}
+
+// rememberMeta is stored next to the value so the value itself stays readable
+// with Get
+type rememberMeta struct {
+	SoftExpiresAt time.Time
+	Delta         time.Duration
+}
+
+// Remember returns the cached value or loads and caches it. Concurrent calls
+// for the same key in this process share a single load.
+func (r *Repository[Key, Value]) Remember(
+	ctx context.Context,
+	key Key,
+	ttl time.Duration,
+	loader func(ctx context.Context) (Value, error),
+	configFuncs ...RememberConfigFunc,
+) (Value, error) {
+	config := &rememberConfig{}
+	for _, configFunc := range configFuncs {
+		configFunc(config)
+	}
+
+	cacheKey := r.cacheKey(key)
+	values, err := r.driver.GetMany(ctx, []string{cacheKey, rememberMetaKey(cacheKey), tagsKey(cacheKey)})
+	if err != nil {
+		return *new(Value), err
+	}
+
+	rawVersions, tagged := values[tagsKey(cacheKey)]
+	current, err := tagsCurrent(ctx, r.driver, rawVersions, tagged)
+	if err != nil {
+		return *new(Value), err
+	}
+
+	if raw, found := values[cacheKey]; found && current {
+		target := *new(Value)
+		if err := r.config.decode(raw, &target); err != nil {
+			return *new(Value), err
+		}
+
+		// Values stored with Set have no metadata and are fresh until they expire
+		meta := rememberMeta{}
+		if rawMeta, found := values[rememberMetaKey(cacheKey)]; found {
+			if err := json.Unmarshal([]byte(rawMeta), &meta); err != nil {
+				return *new(Value), err
+			}
+		}
+
+		if r.shouldRefresh(meta, config) {
+			r.flights.goOnce(cacheKey, func() (any, error) {
+				return r.load(context.WithoutCancel(ctx), cacheKey, ttl, loader, config, false)
+			})
+		}
+
+		return target, nil
+	}
+
+	result, err := r.flights.do(cacheKey, func() (any, error) {
+		return r.load(ctx, cacheKey, ttl, loader, config, true)
+	})
+	if errors.Is(err, errRefreshSkipped) {
+		// Joined a background refresh that didn't load anything
+		return r.load(ctx, cacheKey, ttl, loader, config, true)
+	}
+	if err != nil {
+		return *new(Value), err
+	}
+
+	value, _ := result.(Value)
+
+	return value, nil
+}
+
+func (r *Repository[Key, Value]) shouldRefresh(meta rememberMeta, config *rememberConfig) bool {
+	if meta.SoftExpiresAt.IsZero() {
+		return false
+	}
+
+	now := time.Now()
+	if !now.Before(meta.SoftExpiresAt) {
+		return true
+	}
+
+	if config.earlyRefresh <= 0 {
+		return false
+	}
+
+	// XFetch: refresh when now - delta * beta * ln(rand) passes the expiry
+	early := time.Duration(float64(meta.Delta) * config.earlyRefresh * -math.Log(1-rand.Float64()))
+
+	return !now.Add(early).Before(meta.SoftExpiresAt)
+}
+
+// load runs the loader and stores its value, waitForOthers decides what
+// happens when another instance holds the lock: wait for its value or give up
+func (r *Repository[Key, Value]) load(
+	ctx context.Context,
+	cacheKey string,
+	ttl time.Duration,
+	loader func(ctx context.Context) (Value, error),
+	config *rememberConfig,
+	waitForOthers bool,
+) (Value, error) {
+	if config.lockTTL > 0 {
+		lockKey := rememberLockKey(cacheKey)
+		token := uuid.NewString()
+
+		acquired, err := r.driver.SetIfNotExists(ctx, lockKey, token, config.lockTTL)
+		if err != nil {
+			return *new(Value), err
+		}
+
+		if !acquired {
+			if !waitForOthers {
+				return *new(Value), errRefreshSkipped
+			}
+
+			if value, err := r.waitForValue(ctx, cacheKey, config.lockTTL); err == nil {
+				return value, nil
+			} else if !errors.Is(err, ErrNotFound) {
+				return *new(Value), err
+			}
+		} else {
+			defer func() {
+				// Only release the lock while it is still ours
+				_ = compareAndDelete(ctx, r.driver, lockKey, token)
+			}()
+		}
+	}
+
+	// Versions read after loading could include an invalidation of the data
+	// the loader read, which would then be stored as current
+	versions, err := tagVersions(ctx, r.driver, config.tags)
+	if err != nil {
+		return *new(Value), err
+	}
+
+	start := time.Now()
+	value, err := loader(ctx)
+	if err != nil {
+		return *new(Value), err
+	}
+
+	encoded, err := r.config.encode(value)
+	if err != nil {
+		return *new(Value), err
+	}
+
+	meta := rememberMeta{Delta: time.Since(start)}
+
+	// Values without an expiration are never refreshed
+	if ttl > 0 {
+		meta.SoftExpiresAt = time.Now().Add(ttl)
+	}
+
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return *new(Value), err
+	}
+
+	values := map[string]string{
+		cacheKey:                  encoded,
+		rememberMetaKey(cacheKey): string(metaBytes),
+	}
+
+	if len(config.tags) > 0 {
+		versionBytes, err := json.Marshal(versions)
+		if err != nil {
+			return *new(Value), err
+		}
+
+		values[tagsKey(cacheKey)] = string(versionBytes)
+	} else if err := r.driver.Delete(ctx, tagsKey(cacheKey)); err != nil {
+		return *new(Value), err
+	}
+
+	expiry := ttl
+	if ttl > 0 {
+		expiry += config.staleFor
+	}
+
+	if err := r.driver.SetMany(ctx, values, expiry); err != nil {
+		return *new(Value), err
+	}
+
+	return value, nil
+}
+
+// waitForValue polls for the value another instance is loading
+func (r *Repository[Key, Value]) waitForValue(ctx context.Context, cacheKey string, timeout time.Duration) (Value, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return *new(Value), ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		raw, err := r.driver.Get(ctx, cacheKey)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+
+			return *new(Value), err
+		}
+
+		target := *new(Value)
+		if err := r.config.decode(raw, &target); err != nil {
+			return *new(Value), err
+		}
+
+		return target, nil
+	}
+
+	return *new(Value), ErrNotFound
+}
+
+func rememberMetaKey(cacheKey string) string {
+	return cacheKey + "#meta"
+}
+
+func rememberLockKey(cacheKey string) string {
+	return cacheKey + "#lock"
+}
+
+// flightGroup deduplicates concurrent work by key
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done   chan struct{}
+	result any
+	err    error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		calls: map[string]*flightCall{},
+	}
+}
+
+// do runs fn once for every caller asking for the key while it runs
+func (group *flightGroup) do(key string, fn func() (any, error)) (any, error) {
+	group.mutex.Lock()
+	if call, found := group.calls[key]; found {
+		group.mutex.Unlock()
+		<-call.done
+
+		return call.result, call.err
+	}
+
+	call := &flightCall{done: make(chan struct{})}
+	group.calls[key] = call
+	group.mutex.Unlock()
+
+	defer func() {
+		group.mutex.Lock()
+		delete(group.calls, key)
+		group.mutex.Unlock()
+		close(call.done)
+	}()
+
+	call.result, call.err = fn()
+
+	return call.result, call.err
+}
+
+// goOnce runs fn in the background unless work for the key is already running
+func (group *flightGroup) goOnce(key string, fn func() (any, error)) {
+	group.mutex.Lock()
+	if _, found := group.calls[key]; found {
+		group.mutex.Unlock()
+		return
+	}
+
+	call := &flightCall{done: make(chan struct{})}
+	group.calls[key] = call
+	group.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			group.mutex.Lock()
+			delete(group.calls, key)
+			group.mutex.Unlock()
+			close(call.done)
+		}()
+
+		call.result, call.err = fn()
+	}()
+}
@@ -0,0 +1,208 @@
+package cache_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/cache"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+func TestRemember(t *testing.T) {
+	driver, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	loads := atomic.Int64{}
+	loader := func(ctx context.Context) (int64, error) {
+		time.Sleep(20 * time.Millisecond)
+		return loads.Add(1), nil
+	}
+
+	{ // Concurrent misses share a single load
+		repository := cache.NewRepository[string, int64](driver, "concurrent")
+
+		waitGroup := sync.WaitGroup{}
+		for range 20 {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+
+				value, err := repository.Remember(t.Context(), "key", time.Minute, loader)
+				assert.NilError(t, err)
+				assert.Equal(t, value, int64(1))
+			}()
+		}
+		waitGroup.Wait()
+		assert.Equal(t, loads.Load(), int64(1))
+
+		// Hits don't load and the value is readable with Get
+		value, err := repository.Remember(t.Context(), "key", time.Minute, loader)
+		assert.NilError(t, err)
+		assert.Equal(t, value, int64(1))
+		assert.Equal(t, loads.Load(), int64(1))
+
+		value, err = repository.Get(t.Context(), "key")
+		assert.NilError(t, err)
+		assert.Equal(t, value, int64(1))
+	}
+
+	{ // The lock makes other instances wait for the value
+		loads.Store(0)
+		instances := []*cache.Repository[string, int64]{
+			cache.NewRepository[string, int64](driver, "locked"),
+			cache.NewRepository[string, int64](driver, "locked"),
+		}
+
+		waitGroup := sync.WaitGroup{}
+		for _, instance := range instances {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+
+				value, err := instance.Remember(t.Context(), "key", time.Minute, loader, cache.WithRememberLock(time.Second))
+				assert.NilError(t, err)
+				assert.Equal(t, value, int64(1))
+			}()
+		}
+		waitGroup.Wait()
+		assert.Equal(t, loads.Load(), int64(1))
+	}
+
+	{ // Stale values are served while they reload in the background
+		loads.Store(0)
+		repository := cache.NewRepository[string, int64](driver, "stale")
+
+		value, err := repository.Remember(t.Context(), "key", 10*time.Millisecond, loader, cache.WithStaleWhileRevalidate(time.Minute))
+		assert.NilError(t, err)
+		assert.Equal(t, value, int64(1))
+
+		time.Sleep(20 * time.Millisecond)
+
+		value, err = repository.Remember(t.Context(), "key", time.Minute, loader, cache.WithStaleWhileRevalidate(time.Minute))
+		assert.NilError(t, err)
+		assert.Equal(t, value, int64(1))
+
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			value, err := repository.Get(t.Context(), "key")
+			if err != nil || value != 2 {
+				return poll.Continue("value is %d", value)
+			}
+
+			return poll.Success()
+		})
+	}
+
+	{ // Early refresh reloads before the value expires
+		loads.Store(0)
+		repository := cache.NewRepository[string, int64](driver, "early")
+
+		value, err := repository.Remember(t.Context(), "key", time.Minute, loader)
+		assert.NilError(t, err)
+		assert.Equal(t, value, int64(1))
+
+		// A large beta makes the refresh all but certain
+		value, err = repository.Remember(t.Context(), "key", time.Minute, loader, cache.WithEarlyRefresh(1e9))
+		assert.NilError(t, err)
+		assert.Equal(t, value, int64(1))
+
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			value, err := repository.Get(t.Context(), "key")
+			if err != nil || value != 2 {
+				return poll.Continue("value is %d", value)
+			}
+
+			return poll.Success()
+		})
+	}
+
+	{ // Values without an expiration are never refreshed
+		loads.Store(0)
+		repository := cache.NewRepository[string, int64](driver, "forever")
+
+		for range 5 {
+			value, err := repository.Remember(t.Context(), "key", 0, loader, cache.WithEarlyRefresh(1e9))
+			assert.NilError(t, err)
+			assert.Equal(t, value, int64(1))
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, loads.Load(), int64(1))
+
+		ttl, err := driver.TTL(t.Context(), "forever-key")
+		assert.NilError(t, err)
+		assert.Equal(t, ttl, cache.NoExpiration)
+	}
+
+	{ // Values stored with Set aren't replaced by a refresh of the value they replaced
+		loads.Store(0)
+		repository := cache.NewRepository[string, int64](driver, "replaced")
+
+		_, err := repository.Remember(t.Context(), "key", 10*time.Millisecond, loader, cache.WithStaleWhileRevalidate(time.Minute))
+		assert.NilError(t, err)
+		assert.NilError(t, repository.Set(t.Context(), "key", 42, time.Minute))
+
+		time.Sleep(20 * time.Millisecond)
+
+		value, err := repository.Remember(t.Context(), "key", time.Minute, loader)
+		assert.NilError(t, err)
+		assert.Equal(t, value, int64(42))
+
+		time.Sleep(50 * time.Millisecond)
+		value, err = repository.Get(t.Context(), "key")
+		assert.NilError(t, err)
+		assert.Equal(t, value, int64(42))
+		assert.Equal(t, loads.Load(), int64(1))
+	}
+}
+
+func TestRememberLockRelease(t *testing.T) {
+	memory, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	remote, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	local, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	twoTier, err := cache.NewDriverTwoTier(local, remote, cache.DriverTwoTierConfig{
+		LocalTTL: time.Minute,
+	})
+	assert.NilError(t, err)
+	defer func() {
+		_ = twoTier.Close()
+	}()
+
+	for name, drivers := range map[string]struct {
+		driver cache.Driver
+		shared cache.Driver
+	}{
+		"memory":   {driver: memory, shared: memory},
+		"two tier": {driver: twoTier, shared: remote},
+	} {
+		t.Run(name, func(t *testing.T) {
+			repository := cache.NewRepository[string, int64](drivers.driver, "lock-release")
+
+			// The lock expires while loading and another instance takes it,
+			// going straight to the shared tier like an instance elsewhere would
+			_, err := repository.Remember(t.Context(), "key", time.Minute, func(ctx context.Context) (int64, error) {
+				time.Sleep(30 * time.Millisecond)
+
+				stored, err := drivers.shared.SetIfNotExists(ctx, "lock-release-key#lock", "other", time.Minute)
+				assert.NilError(t, err)
+				assert.Assert(t, stored)
+
+				return 1, nil
+			}, cache.WithRememberLock(10*time.Millisecond))
+			assert.NilError(t, err)
+
+			owner, err := drivers.shared.Get(t.Context(), "lock-release-key#lock")
+			assert.NilError(t, err)
+			assert.Equal(t, owner, "other")
+		})
+	}
+}
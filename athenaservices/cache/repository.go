@@ -2,7 +2,6 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 )
@@ -10,28 +9,50 @@ import (
 func NewRepository[Key comparable, Value any](
 	driver Driver,
 	prefix string,
+	configFuncs ...RepositoryConfigFunc,
 ) *Repository[Key, Value] {
+	config := &repositoryConfig{
+		codec: CodecJSON,
+	}
+	for _, configFunc := range configFuncs {
+		configFunc(config)
+	}
+
+	if registrar, ok := driver.(prefixRegistrar); ok {
+		registrar.registerPrefix(prefix)
+	}
+
 	return &Repository[Key, Value]{
-		driver: driver,
-		prefix: prefix,
+		driver:  driver,
+		prefix:  prefix,
+		config:  config,
+		flights: newFlightGroup(),
 	}
 }
 
 type Repository[Key comparable, Value any] struct {
-	driver Driver
-	prefix string
+	driver  Driver
+	prefix  string
+	config  *repositoryConfig
+	flights *flightGroup
 }
 
 func (r *Repository[Key, Value]) Set(ctx context.Context, key Key, value Value, duration time.Duration) error {
-	jsonBytes, err := json.Marshal(value)
+	encoded, err := r.config.encode(value)
 	if err != nil {
 		return err
 	}
 
+	// Tags and the soft expiry stored with a previous value don't apply to
+	// this one
+	if err := r.deleteAttached(ctx, r.cacheKey(key)); err != nil {
+		return err
+	}
+
 	if err := r.driver.Set(
 		ctx,
-		fmt.Sprintf("%s-%v", r.prefix, key),
-		string(jsonBytes),
+		r.cacheKey(key),
+		encoded,
 		duration,
 	); err != nil {
 		return err
@@ -40,19 +61,69 @@ func (r *Repository[Key, Value]) Set(ctx context.Context, key Key, value Value,
 	return nil
 }
 
+// SetIfNotExists stores the value only when the key is missing and reports
+// whether it did
+func (r *Repository[Key, Value]) SetIfNotExists(ctx context.Context, key Key, value Value, duration time.Duration) (bool, error) {
+	encoded, err := r.config.encode(value)
+	if err != nil {
+		return false, err
+	}
+
+	stored, err := r.driver.SetIfNotExists(ctx, r.cacheKey(key), encoded, duration)
+	if err != nil || !stored {
+		return false, err
+	}
+
+	// Tags and the soft expiry left from an expired value don't apply to this
+	// one
+	if err := r.deleteAttached(ctx, r.cacheKey(key)); err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// deleteAttached deletes what is stored next to a value about it
+func (r *Repository[Key, Value]) deleteAttached(ctx context.Context, cacheKey string) error {
+	for _, attachedKey := range []string{tagsKey(cacheKey), rememberMetaKey(cacheKey)} {
+		if err := r.driver.Delete(ctx, attachedKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (r *Repository[Key, Value]) Get(ctx context.Context, key Key) (Value, error) {
-	val, err := r.driver.Get(
-		ctx,
-		fmt.Sprintf("%s-%v", r.prefix, key),
-	)
+	cacheKey := r.cacheKey(key)
+	values, err := r.driver.GetMany(ctx, []string{cacheKey, tagsKey(cacheKey)})
+	if err != nil {
+		return *new(Value), err
+	}
+
+	val, found := values[cacheKey]
+	if !found {
+		return *new(Value), ErrNotFound
+	}
+
+	rawVersions, tagged := values[tagsKey(cacheKey)]
+	current, err := tagsCurrent(ctx, r.driver, rawVersions, tagged)
 	if err != nil {
 		return *new(Value), err
 	}
 
+	if !current {
+		return *new(Value), ErrNotFound
+	}
+
 	target := *new(Value)
-	if err := json.Unmarshal([]byte(val), &target); err != nil {
+	if err := r.config.decode(val, &target); err != nil {
 		return *new(Value), err
 	}
 
 	return target, nil
 }
+
+func (r *Repository[Key, Value]) cacheKey(key Key) string {
+	return fmt.Sprintf("%s-%v", r.prefix, key)
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Tags are versioned, every value stored with tags remembers the versions
+// they had and is treated as missing once any of them moved on. Invalidating
+// a tag is a single increment no matter how many values carry it.
+
+// TagVersions are the versions the tags had when they were read
+type TagVersions map[string]int64
+
+// SetWithTags stores the value like Set, InvalidateTags with any of the tags
+// drops it. The versions are read when it is called, a value computed from
+// data that was invalidated in the meantime would be stored as current, read
+// them with TagVersions before computing the value and use
+// SetWithTagVersions instead when that matters.
+func (r *Repository[Key, Value]) SetWithTags(ctx context.Context, key Key, value Value, duration time.Duration, tags ...string) error {
+	versions, err := r.TagVersions(ctx, tags...)
+	if err != nil {
+		return err
+	}
+
+	return r.SetWithTagVersions(ctx, key, value, duration, versions)
+}
+
+// TagVersions reads the current versions of the tags
+func (r *Repository[Key, Value]) TagVersions(ctx context.Context, tags ...string) (TagVersions, error) {
+	return tagVersions(ctx, r.driver, tags)
+}
+
+// SetWithTagVersions stores the value with versions read by TagVersions
+// before it was computed, the value is dropped right away when any of the
+// tags was invalidated since
+func (r *Repository[Key, Value]) SetWithTagVersions(ctx context.Context, key Key, value Value, duration time.Duration, versions TagVersions) error {
+	encoded, err := r.config.encode(value)
+	if err != nil {
+		return err
+	}
+
+	versionBytes, err := json.Marshal(versions)
+	if err != nil {
+		return err
+	}
+
+	cacheKey := r.cacheKey(key)
+
+	// The soft expiry of a remembered value doesn't apply to this one
+	if err := r.driver.Delete(ctx, rememberMetaKey(cacheKey)); err != nil {
+		return err
+	}
+
+	return r.driver.SetMany(ctx, map[string]string{
+		cacheKey:          encoded,
+		tagsKey(cacheKey): string(versionBytes),
+	}, duration)
+}
+
+// InvalidateTags drops every value stored with any of the tags, in every
+// repository sharing the driver
+func (r *Repository[Key, Value]) InvalidateTags(ctx context.Context, tags ...string) error {
+	return InvalidateTags(ctx, r.driver, tags...)
+}
+
+// InvalidateTags drops every value stored with any of the tags
+func InvalidateTags(ctx context.Context, driver Driver, tags ...string) error {
+	for _, tag := range tags {
+		if _, err := driver.Increment(ctx, tagVersionKey(tag), 1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tagVersions reads the current version of every tag, tags never invalidated
+// are at version 0
+func tagVersions(ctx context.Context, driver Driver, tags []string) (TagVersions, error) {
+	versions := TagVersions{}
+	if len(tags) == 0 {
+		return versions, nil
+	}
+
+	keys := []string{}
+	for _, tag := range tags {
+		keys = append(keys, tagVersionKey(tag))
+	}
+
+	values, err := driver.GetMany(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tag := range tags {
+		versions[tag] = 0
+
+		raw, found := values[tagVersionKey(tag)]
+		if !found {
+			continue
+		}
+
+		version, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, ErrNotInteger
+		}
+
+		versions[tag] = version
+	}
+
+	return versions, nil
+}
+
+// tagsCurrent reports whether the versions stored with a value are still the
+// current ones, values without tags are always current
+func tagsCurrent(ctx context.Context, driver Driver, rawVersions string, found bool) (bool, error) {
+	if !found {
+		return true, nil
+	}
+
+	stored := map[string]int64{}
+	if err := json.Unmarshal([]byte(rawVersions), &stored); err != nil {
+		return false, err
+	}
+
+	tags := []string{}
+	for tag := range stored {
+		tags = append(tags, tag)
+	}
+
+	current, err := tagVersions(ctx, driver, tags)
+	if err != nil {
+		return false, err
+	}
+
+	for tag, version := range stored {
+		if current[tag] != version {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func tagsKey(cacheKey string) string {
+	return cacheKey + "#tags"
+}
+
+const tagVersionPrefix = "athena-tag"
+
+func tagVersionKey(tag string) string {
+	return tagVersionPrefix + "-" + tag
+}
@@ -0,0 +1,99 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/cache"
+	"gotest.tools/v3/assert"
+)
+
+func TestTags(t *testing.T) {
+	driver, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	profiles := cache.NewRepository[int, string](driver, "profile")
+	permissions := cache.NewRepository[int, []string](driver, "permissions")
+
+	assert.NilError(t, profiles.SetWithTags(t.Context(), 1, "Alice", time.Minute, "user-1"))
+	assert.NilError(t, profiles.SetWithTags(t.Context(), 2, "Bob", time.Minute, "user-2"))
+	assert.NilError(t, permissions.SetWithTags(t.Context(), 1, []string{"admin"}, time.Minute, "user-1", "admins"))
+
+	profile, err := profiles.Get(t.Context(), 1)
+	assert.NilError(t, err)
+	assert.Equal(t, profile, "Alice")
+
+	// A single call drops the values in every repository
+	assert.NilError(t, profiles.InvalidateTags(t.Context(), "user-1"))
+
+	_, err = profiles.Get(t.Context(), 1)
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+	_, err = permissions.Get(t.Context(), 1)
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+
+	profile, err = profiles.Get(t.Context(), 2)
+	assert.NilError(t, err)
+	assert.Equal(t, profile, "Bob")
+
+	// Values stored after the invalidation are current again
+	assert.NilError(t, profiles.SetWithTags(t.Context(), 1, "Alice", time.Minute, "user-1"))
+	profile, err = profiles.Get(t.Context(), 1)
+	assert.NilError(t, err)
+	assert.Equal(t, profile, "Alice")
+
+	// Set drops the tags of the previous value
+	assert.NilError(t, profiles.Set(t.Context(), 2, "Robert", time.Minute))
+	assert.NilError(t, cache.InvalidateTags(t.Context(), driver, "user-2"))
+	profile, err = profiles.Get(t.Context(), 2)
+	assert.NilError(t, err)
+	assert.Equal(t, profile, "Robert")
+
+	{ // Remember reloads values whose tags were invalidated
+		loads := 0
+		loader := func(ctx context.Context) (string, error) {
+			loads++
+			return "loaded", nil
+		}
+
+		for range 2 {
+			_, err := profiles.Remember(t.Context(), 3, time.Minute, loader, cache.WithRememberTags("user-3"))
+			assert.NilError(t, err)
+		}
+		assert.Equal(t, loads, 1)
+
+		assert.NilError(t, profiles.InvalidateTags(t.Context(), "user-3"))
+		_, err := profiles.Remember(t.Context(), 3, time.Minute, loader, cache.WithRememberTags("user-3"))
+		assert.NilError(t, err)
+		assert.Equal(t, loads, 2)
+	}
+
+	{ // Invalidations while loading drop the loaded value
+		loads := 0
+		loader := func(ctx context.Context) (string, error) {
+			loads++
+			if loads == 1 {
+				assert.NilError(t, profiles.InvalidateTags(ctx, "user-4"))
+			}
+
+			return "loaded", nil
+		}
+
+		_, err := profiles.Remember(t.Context(), 4, time.Minute, loader, cache.WithRememberTags("user-4"))
+		assert.NilError(t, err)
+		_, err = profiles.Remember(t.Context(), 4, time.Minute, loader, cache.WithRememberTags("user-4"))
+		assert.NilError(t, err)
+		assert.Equal(t, loads, 2)
+	}
+
+	{ // Versions read before computing a value catch invalidations in between
+		versions, err := profiles.TagVersions(t.Context(), "user-5")
+		assert.NilError(t, err)
+
+		assert.NilError(t, profiles.InvalidateTags(t.Context(), "user-5"))
+		assert.NilError(t, profiles.SetWithTagVersions(t.Context(), 5, "outdated", time.Minute, versions))
+
+		_, err = profiles.Get(t.Context(), 5)
+		assert.ErrorIs(t, err, cache.ErrNotFound)
+	}
+}
@@ -0,0 +1,325 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/database/internal/utils"
+)
+
+type AuditOperation string
+
+const (
+	AuditOperationInsert AuditOperation = "insert"
+	AuditOperationUpdate AuditOperation = "update"
+	AuditOperationDelete AuditOperation = "delete"
+)
+
+type AuditEntry struct {
+	Table string
+	// PrimaryKey has the type of the primary key field of the entity
+	PrimaryKey any
+	Operation  AuditOperation
+	Actor      string
+	Changes    []AuditChange
+	Timestamp  time.Time
+}
+
+// AuditChange holds the value of a column before and after the change, before
+// is nil for inserts and after is nil for deletes
+type AuditChange struct {
+	Column string
+	Before any
+	After  any
+}
+
+type AuditSink interface {
+	Record(ctx context.Context, entry AuditEntry) error
+}
+
+// WithAudit records every insert, update and delete made through a
+// repository in the sink. The actor function extracts who made the change
+// from the context. The change and its recording run in one transaction, the
+// change is rolled back when recording it fails. Only sinks writing to the
+// database of the service (WithAuditTable) are rolled back along with it.
+func WithAudit(sink AuditSink, actor func(ctx context.Context) string) ServiceConfigFunc {
+	return func(service *Service) error {
+		service.auditSink = sink
+		service.auditActor = actor
+		return nil
+	}
+}
+
+// WithAuditTable records the changes in the athena_audit table of the service
+// itself, in the same transaction as the changes
+func WithAuditTable(actor func(ctx context.Context) string) ServiceConfigFunc {
+	return func(service *Service) error {
+		sink, err := NewAuditTableSink(context.Background(), service)
+		if err != nil {
+			return err
+		}
+
+		return WithAudit(sink, actor)(service)
+	}
+}
+
+// NewAuditTableSink stores the audit entries in the athena_audit table of the
+// service, creating the table when needed. Use WithAuditTable to audit the
+// service into its own database.
+func NewAuditTableSink(ctx context.Context, service *Service) (AuditSink, error) {
+	if _, err := service.AutoMigrate(ctx, []Entity{auditRecord{}}); err != nil {
+		return nil, err
+	}
+
+	return auditTableSink{
+		service: service,
+	}, nil
+}
+
+type auditTableSink struct {
+	service *Service
+}
+
+func (sink auditTableSink) Record(ctx context.Context, entry AuditEntry) error {
+	_, err := sink.service.insert(ctx, auditRecord{
+		TableName:  entry.Table,
+		PrimaryKey: fmt.Sprint(entry.PrimaryKey),
+		Operation:  string(entry.Operation),
+		Actor:      entry.Actor,
+		Changes:    entry.Changes,
+		CreatedAt:  entry.Timestamp,
+	}, false, false)
+
+	return err
+}
+
+// AuditPublisher is satisfied by a queue.Queue[AuditEntry]
+type AuditPublisher interface {
+	Publish(ctx context.Context, entry AuditEntry) error
+}
+
+// NewAuditQueueSink publishes the audit entries for downstream consumers
+func NewAuditQueueSink(publisher AuditPublisher) AuditSink {
+	return auditQueueSink{
+		publisher: publisher,
+	}
+}
+
+type auditQueueSink struct {
+	publisher AuditPublisher
+}
+
+func (sink auditQueueSink) Record(ctx context.Context, entry AuditEntry) error {
+	return sink.publisher.Publish(ctx, entry)
+}
+
+type auditRecord struct {
+	ID         int64         `db:"id,primaryKey,autoIncrement"`
+	TableName  string        `db:"table_name"`
+	PrimaryKey string        `db:"primary_key"`
+	Operation  string        `db:"operation"`
+	Actor      string        `db:"actor"`
+	Changes    []AuditChange `db:"changes"`
+	CreatedAt  time.Time     `db:"created_at"`
+}
+
+func (e auditRecord) TableStructure() Table {
+	return Table{
+		Name: "athena_audit",
+		Indexes: []TableIndex{
+			{
+				Name:    "ix_athena_audit_table_name_primary_key",
+				Columns: []string{"table_name", "primary_key"},
+			},
+		},
+	}
+}
+
+// audited runs the change in a transaction along with recording it, changes
+// of services without auditing run as they are
+func (service *Service) audited(ctx context.Context, run func(ctx context.Context) error) error {
+	if service.auditSink == nil {
+		return run(ctx)
+	}
+
+	return service.inTransaction(ctx, run)
+}
+
+// auditSnapshot fetches the row as it is in the database before it changes,
+// it returns nil when auditing is disabled or the row doesn't exist
+func (repository *Repository[ID, T]) auditSnapshot(ctx context.Context, entity T) (*T, error) {
+	if repository.selector.service.auditSink == nil {
+		return nil, nil
+	}
+
+	primaryKeyField, primaryKey := entityPrimaryKey(reflect.ValueOf(repository.T).Elem(), entity)
+	if primaryKeyField == nil {
+		return nil, nil
+	}
+
+	before, err := repository.selector.SelectSingle(ctx, WithAdditionalWhere(And(simpleOperatorOfEquality{
+		Column:   primaryKeyField,
+		Operator: "=",
+		Value:    primaryKey,
+	})))
+	if err != nil {
+		if err == ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &before, nil
+}
+
+func (service *Service) recordAudit(ctx context.Context, operation AuditOperation, primaryKey any, before Entity, after Entity) error {
+	if service.auditSink == nil {
+		return nil
+	}
+
+	entry := AuditEntry{
+		PrimaryKey: primaryKey,
+		Operation:  operation,
+		Changes:    []AuditChange{},
+		Timestamp:  time.Now().UTC(),
+	}
+
+	if service.auditActor != nil {
+		entry.Actor = service.auditActor(ctx)
+	}
+
+	beforeValues := map[string]any{}
+	if before != nil {
+		entry.Table = before.TableStructure().Name
+		beforeValues = auditColumnValues(before)
+	}
+
+	afterValues := map[string]any{}
+	if after != nil {
+		entry.Table = after.TableStructure().Name
+		afterValues = auditColumnValues(after)
+	}
+
+	// Walk the fields in order so the changes are listed in a stable order
+	example := after
+	if example == nil {
+		example = before
+	}
+
+	if err := utils.LoopOverStructFields(reflect.ValueOf(example), func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
+		tag := utils.ParseTag(fieldDefinition.Tag)
+		if tag.Column == "" {
+			return nil
+		}
+
+		change := AuditChange{
+			Column: tag.Column,
+			Before: beforeValues[tag.Column],
+			After:  afterValues[tag.Column],
+		}
+
+		// Read only columns are never written so they can't change on update
+		if operation == AuditOperationUpdate && (tag.ReadOnly || auditValuesEqual(change.Before, change.After)) {
+			return nil
+		}
+
+		entry.Changes = append(entry.Changes, change)
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if operation == AuditOperationUpdate && len(entry.Changes) == 0 {
+		return nil
+	}
+
+	return service.auditSink.Record(ctx, entry)
+}
+
+func auditColumnValues(entity Entity) map[string]any {
+	values := map[string]any{}
+	_ = utils.LoopOverStructFields(reflect.ValueOf(entity), func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
+		tag := utils.ParseTag(fieldDefinition.Tag)
+		if tag.Column == "" {
+			return nil
+		}
+
+		values[tag.Column] = fieldValue.Interface()
+
+		return nil
+	})
+
+	return values
+}
+
+func auditValuesEqual(a any, b any) bool {
+	// Times read back from the database can be in a different location
+	aTime, aIsTime := a.(time.Time)
+	bTime, bIsTime := b.(time.Time)
+	if aIsTime && bIsTime {
+		return aTime.Equal(bTime)
+	}
+
+	aBytes, aErr := json.Marshal(a)
+	bBytes, bErr := json.Marshal(b)
+	if aErr != nil || bErr != nil {
+		return reflect.DeepEqual(a, b)
+	}
+
+	return string(aBytes) == string(bBytes)
+}
+
+// entityPrimaryKey returns a pointer to the primary key field of the template
+// (for use in operators) along with the primary key value of the entity
+func entityPrimaryKey(template reflect.Value, entity Entity) (any, any) {
+	entityValue := reflect.ValueOf(entity)
+	for i := range entityValue.NumField() {
+		fieldDefinition := entityValue.Type().Field(i)
+		if !fieldDefinition.IsExported() {
+			continue
+		}
+
+		if utils.ParseTag(fieldDefinition.Tag).PrimaryKey {
+			return template.Field(i).Addr().Interface(), entityValue.Field(i).Interface()
+		}
+	}
+
+	return nil, nil
+}
+
+// entityWithPrimaryKey fills in the generated primary key, keys of other types
+// are never generated so the entity already has them
+func entityWithPrimaryKey[T Entity](entity T, primaryKey int64) (T, any) {
+	entityValue := reflect.ValueOf(&entity).Elem()
+	for i := range entityValue.NumField() {
+		fieldDefinition := entityValue.Type().Field(i)
+		if !fieldDefinition.IsExported() || !utils.ParseTag(fieldDefinition.Tag).PrimaryKey {
+			continue
+		}
+
+		field := entityValue.Field(i)
+		switch {
+		case field.CanInt():
+			field.SetInt(primaryKey)
+		case field.CanUint():
+			field.SetUint(uint64(primaryKey))
+		}
+
+		return entity, field.Interface()
+	}
+
+	return entity, nil
+}
+
+// auditRowsAffected assumes the row changed when the driver can't tell
+func auditRowsAffected(result sql.Result) bool {
+	affected, err := result.RowsAffected()
+
+	return err != nil || affected > 0
+}
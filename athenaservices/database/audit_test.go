@@ -0,0 +1,182 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+type actorContextKey struct{}
+
+type recordingAuditSink struct {
+	entries []database.AuditEntry
+}
+
+func (sink *recordingAuditSink) Record(ctx context.Context, entry database.AuditEntry) error {
+	sink.entries = append(sink.entries, entry)
+	return nil
+}
+
+type auditRow struct {
+	ID         int64  `db:"id,primaryKey,autoIncrement"`
+	TableName  string `db:"table_name"`
+	PrimaryKey string `db:"primary_key"`
+	Operation  string `db:"operation"`
+	Actor      string `db:"actor"`
+}
+
+func (e auditRow) TableStructure() database.Table {
+	return database.Table{
+		Name: "athena_audit",
+	}
+}
+
+func TestAudit(t *testing.T) {
+	sink := &recordingAuditSink{}
+	service, err := database.New(
+		database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())),
+		database.WithAudit(sink, func(ctx context.Context) string {
+			actor, _ := ctx.Value(actorContextKey{}).(string)
+			return actor
+		}),
+	)
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{Company{}})
+	assert.NilError(t, err)
+
+	ctx := context.WithValue(t.Context(), actorContextKey{}, "admin")
+	companyRepo := database.NewRepository[CompanyID, Company](service)
+
+	companyID, err := companyRepo.Insert(ctx, Company{String: "before", Int: 1})
+	assert.NilError(t, err)
+
+	assert.NilError(t, companyRepo.Update(ctx, Company{ID: companyID, String: "after", Int: 1}))
+
+	// Updates that change nothing or miss the row are not recorded
+	assert.NilError(t, companyRepo.Update(ctx, Company{ID: companyID, String: "after", Int: 1}))
+	assert.NilError(t, companyRepo.Update(ctx, Company{ID: companyID + 1, String: "missing"}))
+
+	assert.NilError(t, companyRepo.Delete(ctx, Company{ID: companyID}))
+
+	assert.Equal(t, len(sink.entries), 3)
+
+	{ // Insert
+		entry := sink.entries[0]
+		assert.Equal(t, entry.Operation, database.AuditOperationInsert)
+		assert.Equal(t, entry.Table, "company")
+		assert.Equal(t, entry.PrimaryKey, companyID)
+		assert.Equal(t, entry.Actor, "admin")
+		assert.Equal(t, entry.Changes[0].Column, "id")
+		assert.Equal(t, entry.Changes[0].Before, nil)
+		assert.Equal(t, entry.Changes[0].After, companyID)
+	}
+
+	{ // Update only lists the changed columns
+		entry := sink.entries[1]
+		assert.Equal(t, entry.Operation, database.AuditOperationUpdate)
+		assert.DeepEqual(t, entry.Changes, []database.AuditChange{
+			{Column: "name", Before: "before", After: "after"},
+		})
+	}
+
+	{ // Delete
+		entry := sink.entries[2]
+		assert.Equal(t, entry.Operation, database.AuditOperationDelete)
+		assert.Equal(t, entry.PrimaryKey, companyID)
+		assert.Equal(t, entry.Changes[1].Column, "name")
+		assert.Equal(t, entry.Changes[1].Before, "after")
+		assert.Equal(t, entry.Changes[1].After, nil)
+	}
+}
+
+func TestAuditTable(t *testing.T) {
+	service, err := database.New(
+		database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())),
+		database.WithAuditTable(nil),
+	)
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{Company{}})
+	assert.NilError(t, err)
+
+	companyRepo := database.NewRepository[CompanyID, Company](service)
+	companyID, err := companyRepo.Insert(t.Context(), Company{String: "audited"})
+	assert.NilError(t, err)
+
+	auditRepo := database.NewRepository[int64, auditRow](service)
+	rows, err := auditRepo.SelectMultiple(t.Context())
+	assert.NilError(t, err)
+	assert.Equal(t, len(rows), 1)
+	assert.Equal(t, rows[0].TableName, "company")
+	assert.Equal(t, rows[0].PrimaryKey, fmt.Sprint(companyID))
+	assert.Equal(t, rows[0].Operation, "insert")
+}
+
+type failingAuditSink struct{}
+
+func (sink failingAuditSink) Record(ctx context.Context, entry database.AuditEntry) error {
+	return errors.New("audit log is down")
+}
+
+func TestAuditRollsBackUnrecordedChanges(t *testing.T) {
+	path := fmt.Sprintf("%s/database.sqlite", t.TempDir())
+
+	plain, err := database.New(database.NewDriverSQLite(path))
+	assert.NilError(t, err)
+
+	_, err = plain.AutoMigrate(t.Context(), []database.Entity{Company{}})
+	assert.NilError(t, err)
+
+	plainRepo := database.NewRepository[CompanyID, Company](plain)
+	companyID, err := plainRepo.Insert(t.Context(), Company{String: "before"})
+	assert.NilError(t, err)
+
+	audited, err := database.New(database.NewDriverSQLite(path), database.WithAudit(failingAuditSink{}, nil))
+	assert.NilError(t, err)
+
+	auditedRepo := database.NewRepository[CompanyID, Company](audited)
+	_, err = auditedRepo.Insert(t.Context(), Company{String: "inserted"})
+	assert.ErrorContains(t, err, "audit log is down")
+	assert.ErrorContains(t, auditedRepo.Update(t.Context(), Company{ID: companyID, String: "after"}), "audit log is down")
+	assert.ErrorContains(t, auditedRepo.Delete(t.Context(), Company{ID: companyID}), "audit log is down")
+
+	companies, err := plainRepo.SelectMultiple(t.Context())
+	assert.NilError(t, err)
+	assert.Equal(t, len(companies), 1)
+	assert.Equal(t, companies[0].String, "before")
+}
+
+type unsignedEntity struct {
+	ID   uint64 `db:"id,primaryKey,autoIncrement"`
+	Name string `db:"name"`
+}
+
+func (e unsignedEntity) TableStructure() database.Table {
+	return database.Table{
+		Name: "unsigned_entity",
+	}
+}
+
+func TestAuditPrimaryKeyTypes(t *testing.T) {
+	sink := &recordingAuditSink{}
+	service, err := database.New(
+		database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())),
+		database.WithAudit(sink, nil),
+	)
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{unsignedEntity{}})
+	assert.NilError(t, err)
+
+	repo := database.NewRepository[int64, unsignedEntity](service)
+	id, err := repo.Insert(t.Context(), unsignedEntity{Name: "before"})
+	assert.NilError(t, err)
+
+	assert.Equal(t, len(sink.entries), 1)
+	assert.Equal(t, sink.entries[0].PrimaryKey, uint64(id))
+}
@@ -10,6 +10,8 @@ import (
 func (service *Service) AutoMigrate(ctx context.Context, entities []Entity) (changesExecuted int, err error) {
 	statements := []statement{}
 	for _, entity := range entities {
+		service.registerForeignKeys(entity)
+
 		result := &migrationResult{}
 		targetTable := entity.TableStructure()
 		if err := targetTable.hydrateColumns(service.driver, entity); err != nil {
@@ -38,15 +40,25 @@ func (service *Service) AutoMigrate(ctx context.Context, entities []Entity) (cha
 			return 0, err
 		}
 
+		overridden := false
 		if err := result.DoTheThing(service.driver, tableDifferences); err != nil {
 			if errors.Is(err, errNeedsAutoMigrateOverride) {
-				statements = append(result.OverrideStatements, service.driver.autoMigrateOverride(sourceTable, targetTable)...)
+				overridden = true
+				statements = append(statements, result.OverrideStatements...)
+				statements = append(statements, service.driver.autoMigrateOverride(sourceTable, targetTable)...)
 			} else {
 				return 0, err
 			}
 		} else {
 			statements = append(statements, result.GetAllStatements()...)
 		}
+
+		// Recreating the table loses whatever was attached to it
+		searchStatements, err := service.driver.autoMigrateSearch(ctx, service, targetTable, overridden)
+		if err != nil {
+			return 0, err
+		}
+		statements = append(statements, searchStatements...)
 	}
 
 	for i, statement := range statements {
@@ -101,9 +113,7 @@ func diff(source Table, target Table) (tableDifferences, error) {
 			}
 
 			// Alter ones that are not correct in the source
-			slices.Sort(source.Columns)
-			slices.Sort(target.Columns)
-			if !reflect.DeepEqual(source, target) {
+			if !indexesEqual(source, target) {
 				diff.IndexesToAlter = append(diff.IndexesToAlter, target)
 				continue
 			}
@@ -0,0 +1,357 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// CacheStore keeps cache entries in the athena_cache table, it is the storage
+// behind the database cache driver. A zero expiry means the entry never
+// expires, expired entries are ignored until DeleteExpired removes them.
+// Keys and values are compared by their hash so neither their length nor the
+// collation of the database changes which entries match.
+type CacheStore struct {
+	service *Service
+}
+
+type CacheEntry struct {
+	Value     string
+	ExpiresAt time.Time
+}
+
+type cacheRecord struct {
+	ID        int64  `db:"id,primaryKey,autoIncrement"`
+	KeyHash   string `db:"key_hash"`
+	Key       string `db:"cache_key,longText"`
+	ValueHash string `db:"value_hash"`
+	Value     string `db:"cache_value,longText"`
+	ExpiresAt int64  `db:"expires_at"`
+}
+
+func (e cacheRecord) TableStructure() Table {
+	return Table{
+		Name: "athena_cache",
+		Indexes: []TableIndex{
+			{
+				Name:    "ux_athena_cache_key_hash",
+				Columns: []string{"key_hash"},
+				Unique:  true,
+			},
+			{
+				Name:    "ix_athena_cache_expires_at",
+				Columns: []string{"expires_at"},
+			},
+		},
+	}
+}
+
+// NewCacheStore creates the athena_cache table when it doesn't exist yet
+func NewCacheStore(ctx context.Context, service *Service) (*CacheStore, error) {
+	if _, err := service.AutoMigrate(ctx, []Entity{cacheRecord{}}); err != nil {
+		return nil, err
+	}
+
+	return &CacheStore{
+		service: service,
+	}, nil
+}
+
+// Get returns the entries that exist and haven't expired
+func (store *CacheStore) Get(ctx context.Context, keys []string) (map[string]CacheEntry, error) {
+	entries := map[string]CacheEntry{}
+	if len(keys) == 0 {
+		return entries, nil
+	}
+
+	hashes := map[string]string{}
+	for _, key := range keys {
+		hashes[cacheHash(key)] = key
+	}
+
+	records := []cacheRecord{}
+	if err := store.service.runSelect(ctx, statement{
+		Query: "SELECT key_hash, cache_value, expires_at FROM athena_cache WHERE key_hash IN (:key_hashes) AND (expires_at = 0 OR expires_at > :now)",
+		Parameters: map[string]any{
+			":key_hashes": cacheHashes(keys),
+			":now":        time.Now().UnixNano(),
+		},
+	}, &records); err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		entries[hashes[record.KeyHash]] = CacheEntry{
+			Value:     record.Value,
+			ExpiresAt: cacheExpiryTime(record.ExpiresAt),
+		}
+	}
+
+	return entries, nil
+}
+
+// Set stores the entry, replacing the existing one
+func (store *CacheStore) Set(ctx context.Context, key string, value string, expiresAt time.Time) error {
+	updated, err := store.update(ctx, key, value, expiresAt)
+	if err != nil || updated {
+		return err
+	}
+
+	_, insertErr := store.insert(ctx, key, value, expiresAt)
+	if insertErr == nil {
+		return nil
+	}
+
+	// Either another writer inserted it first or the update changed nothing
+	// since MySQL doesn't count rows that already held the values
+	if _, err := store.update(ctx, key, value, expiresAt); err != nil {
+		return err
+	}
+
+	exists, err := store.exists(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return insertErr
+	}
+
+	return nil
+}
+
+// Add stores the entry unless one that hasn't expired exists
+func (store *CacheStore) Add(ctx context.Context, key string, value string, expiresAt time.Time) (bool, error) {
+	if _, err := store.service.runExecute(ctx, statement{
+		Query: "DELETE FROM athena_cache WHERE key_hash = :key_hash AND expires_at <> 0 AND expires_at <= :now",
+		Parameters: map[string]any{
+			":key_hash": cacheHash(key),
+			":now":      time.Now().UnixNano(),
+		},
+	}); err != nil {
+		return false, err
+	}
+
+	_, insertErr := store.insert(ctx, key, value, expiresAt)
+	if insertErr == nil {
+		return true, nil
+	}
+
+	// The unique index rejected it, the errors differ between databases
+	exists, err := store.exists(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		return false, insertErr
+	}
+
+	return false, nil
+}
+
+// Swap replaces the value of an entry that hasn't expired only while it still
+// holds the old value, keeping its expiry
+func (store *CacheStore) Swap(ctx context.Context, key string, oldValue string, newValue string) (bool, error) {
+	result, err := store.service.runExecute(ctx, statement{
+		Query: "UPDATE athena_cache SET cache_value = :new_value, value_hash = :new_value_hash WHERE key_hash = :key_hash AND value_hash = :old_value_hash AND (expires_at = 0 OR expires_at > :now)",
+		Parameters: map[string]any{
+			":new_value":      newValue,
+			":new_value_hash": cacheHash(newValue),
+			":key_hash":       cacheHash(key),
+			":old_value_hash": cacheHash(oldValue),
+			":now":            time.Now().UnixNano(),
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// Expire changes the expiry of an entry that hasn't expired yet
+func (store *CacheStore) Expire(ctx context.Context, key string, expiresAt time.Time) (bool, error) {
+	result, err := store.service.runExecute(ctx, statement{
+		Query: "UPDATE athena_cache SET expires_at = :expires_at WHERE key_hash = :key_hash AND (expires_at = 0 OR expires_at > :now)",
+		Parameters: map[string]any{
+			":expires_at": cacheExpiryNano(expiresAt),
+			":key_hash":   cacheHash(key),
+			":now":        time.Now().UnixNano(),
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if affected > 0 {
+		return true, nil
+	}
+
+	// MySQL doesn't count rows that already had the expiry
+	return store.exists(ctx, key)
+}
+
+func (store *CacheStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := store.service.runExecute(ctx, statement{
+		Query: "DELETE FROM athena_cache WHERE key_hash IN (:key_hashes)",
+		Parameters: map[string]any{
+			":key_hashes": cacheHashes(keys),
+		},
+	})
+
+	return err
+}
+
+// DeleteIfValue deletes the entry only while it holds the value
+func (store *CacheStore) DeleteIfValue(ctx context.Context, key string, value string) error {
+	_, err := store.service.runExecute(ctx, statement{
+		Query: "DELETE FROM athena_cache WHERE key_hash = :key_hash AND value_hash = :value_hash",
+		Parameters: map[string]any{
+			":key_hash":   cacheHash(key),
+			":value_hash": cacheHash(value),
+		},
+	})
+
+	return err
+}
+
+// DeleteByPrefix narrows the entries down with LIKE, which ignores case with
+// some collations, and checks the prefix of what it found again
+func (store *CacheStore) DeleteByPrefix(ctx context.Context, prefix string) error {
+	escaper := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+	records := []cacheRecord{}
+	if err := store.service.runSelect(ctx, statement{
+		Query: "SELECT cache_key FROM athena_cache WHERE cache_key LIKE :pattern ESCAPE '!'",
+		Parameters: map[string]any{
+			":pattern": escaper.Replace(prefix) + "%",
+		},
+	}, &records); err != nil {
+		return err
+	}
+
+	keys := []string{}
+	for _, record := range records {
+		if strings.HasPrefix(record.Key, prefix) {
+			keys = append(keys, record.Key)
+		}
+	}
+
+	return store.Delete(ctx, keys...)
+}
+
+func (store *CacheStore) Flush(ctx context.Context) error {
+	_, err := store.service.runExecute(ctx, statement{
+		Query:      "DELETE FROM athena_cache",
+		Parameters: map[string]any{},
+	})
+
+	return err
+}
+
+// DeleteExpired removes the expired entries and returns how many there were
+func (store *CacheStore) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := store.service.runExecute(ctx, statement{
+		Query: "DELETE FROM athena_cache WHERE expires_at <> 0 AND expires_at <= :now",
+		Parameters: map[string]any{
+			":now": time.Now().UnixNano(),
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (store *CacheStore) update(ctx context.Context, key string, value string, expiresAt time.Time) (bool, error) {
+	result, err := store.service.runExecute(ctx, statement{
+		Query: "UPDATE athena_cache SET cache_value = :value, value_hash = :value_hash, expires_at = :expires_at WHERE key_hash = :key_hash",
+		Parameters: map[string]any{
+			":value":      value,
+			":value_hash": cacheHash(value),
+			":expires_at": cacheExpiryNano(expiresAt),
+			":key_hash":   cacheHash(key),
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (store *CacheStore) insert(ctx context.Context, key string, value string, expiresAt time.Time) (int64, error) {
+	return store.service.insert(ctx, cacheRecord{
+		KeyHash:   cacheHash(key),
+		Key:       key,
+		ValueHash: cacheHash(value),
+		Value:     value,
+		ExpiresAt: cacheExpiryNano(expiresAt),
+	}, false, false)
+}
+
+// exists looks for an entry that hasn't expired
+func (store *CacheStore) exists(ctx context.Context, key string) (bool, error) {
+	entries, err := store.Get(ctx, []string{key})
+	if err != nil {
+		return false, err
+	}
+
+	_, found := entries[key]
+
+	return found, nil
+}
+
+func cacheExpiryNano(expiresAt time.Time) int64 {
+	if expiresAt.IsZero() {
+		return 0
+	}
+
+	return expiresAt.UnixNano()
+}
+
+func cacheExpiryTime(expiresAt int64) time.Time {
+	if expiresAt == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, expiresAt)
+}
+
+func cacheHash(value string) string {
+	hash := sha256.Sum256([]byte(value))
+
+	return hex.EncodeToString(hash[:])
+}
+
+func cacheHashes(values []string) []string {
+	hashes := []string{}
+	for _, value := range values {
+		hashes = append(hashes, cacheHash(value))
+	}
+
+	return hashes
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/lunagic/athena/athenaservices/database/internal/utils"
+)
+
+var ErrCopyCountMismatch = errors.New("copied row count mismatch")
+var ErrCopyTargetNotEmpty = errors.New("copy target is not empty")
+
+type CopyResult struct {
+	Table string
+	Rows  int64
+}
+
+type copyConfig struct {
+	batchSize int
+}
+
+type CopyConfigFunc func(config *copyConfig) error
+
+// WithCopyBatchSize sets how many rows are read from the source at once
+func WithCopyBatchSize(size int) CopyConfigFunc {
+	return func(config *copyConfig) error {
+		if size < 1 {
+			return fmt.Errorf("invalid batch size: %d", size)
+		}
+
+		config.batchSize = size
+		return nil
+	}
+}
+
+// Copy moves the rows of the entities from the source to the target, which
+// can use a different driver. The target is auto migrated and expected to be
+// empty, tables are copied in foreign key order with their primary keys kept
+// and the row counts of both sides are compared once done.
+func Copy(ctx context.Context, source *Service, target *Service, entities []Entity, configFuncs ...CopyConfigFunc) ([]CopyResult, error) {
+	config := &copyConfig{
+		batchSize: 500,
+	}
+
+	for _, configFunc := range configFuncs {
+		if err := configFunc(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := target.AutoMigrate(ctx, entities); err != nil {
+		return nil, err
+	}
+
+	schema, err := resolveSchema(target.driver, entities)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows already in the target would collide with the copied primary keys
+	// or end up mixed in with them
+	for _, entry := range schema {
+		count, err := target.countRows(ctx, entry.table.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if count > 0 {
+			return nil, fmt.Errorf("%w: %s has %d rows", ErrCopyTargetNotEmpty, entry.table.Name, count)
+		}
+	}
+
+	results := []CopyResult{}
+	for _, entry := range schema {
+		copied, err := copyTable(ctx, source, target, entry, config.batchSize)
+		if err != nil {
+			return nil, fmt.Errorf("copy of %s: %w", entry.table.Name, err)
+		}
+
+		results = append(results, CopyResult{
+			Table: entry.table.Name,
+			Rows:  copied,
+		})
+	}
+
+	for _, result := range results {
+		sourceCount, err := source.countRows(ctx, result.Table)
+		if err != nil {
+			return nil, err
+		}
+
+		targetCount, err := target.countRows(ctx, result.Table)
+		if err != nil {
+			return nil, err
+		}
+
+		if sourceCount != targetCount {
+			return nil, fmt.Errorf("%w: %s has %d rows in the source and %d in the target", ErrCopyCountMismatch, result.Table, sourceCount, targetCount)
+		}
+	}
+
+	return results, nil
+}
+
+func copyTable(ctx context.Context, source *Service, target *Service, entry schemaEntry, batchSize int) (int64, error) {
+	query, err := generateBaseQuery(entry.entity)
+	if err != nil {
+		return 0, err
+	}
+
+	primaryKeys := []TableColumn{}
+	for _, column := range entry.table.columns {
+		if column.PrimaryKey {
+			primaryKeys = append(primaryKeys, column)
+		}
+	}
+
+	// Pages continue after the last primary key read, that stays fast deep into
+	// big tables where an offset makes the database skip every earlier row.
+	// Other tables are paged by offset in whatever order the database returns.
+	primaryKeyField := ""
+	if len(primaryKeys) == 1 {
+		query.OrderBy = primaryKeys[0].Name
+
+		if err := utils.LoopOverStructFields(reflect.ValueOf(entry.entity), func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
+			if utils.ParseTag(fieldDefinition.Tag).Column == primaryKeys[0].Name {
+				primaryKeyField = fieldDefinition.Name
+			}
+
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	copied := int64(0)
+	for offset := 0; ; offset += batchSize {
+		query.Limit.Count = batchSize
+		if primaryKeyField == "" {
+			query.Limit.Offset = offset
+		}
+
+		statement, err := source.driver.generateSelect(query)
+		if err != nil {
+			return copied, err
+		}
+
+		rows := reflect.New(reflect.SliceOf(reflect.TypeOf(entry.entity)))
+		if err := source.runSelect(ctx, statement, rows.Interface()); err != nil {
+			return copied, err
+		}
+
+		for i := range rows.Elem().Len() {
+			if _, err := target.insert(ctx, rows.Elem().Index(i).Interface().(Entity), true, true); err != nil {
+				return copied, err
+			}
+
+			copied++
+		}
+
+		if rows.Elem().Len() < batchSize {
+			break
+		}
+
+		if primaryKeyField != "" {
+			query.Where = And(keysetOperator{
+				column: mappedColumn{Table: entry.table.Name, Column: primaryKeys[0].Name},
+				after:  rows.Elem().Index(rows.Elem().Len() - 1).FieldByName(primaryKeyField).Interface(),
+			})
+		}
+	}
+
+	statements, err := target.driver.generateSequenceReset(entry.table)
+	if err != nil {
+		return copied, err
+	}
+
+	for _, statement := range statements {
+		if _, err := target.runExecute(ctx, statement); err != nil {
+			return copied, err
+		}
+	}
+
+	return copied, nil
+}
+
+func (service *Service) countRows(ctx context.Context, tableName string) (int64, error) {
+	statement, err := service.driver.generateCount(tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	count := []struct {
+		Count int64 `db:"count"`
+	}{}
+	if err := service.runSelect(ctx, statement, &count); err != nil {
+		return 0, err
+	}
+
+	return count[0].Count, nil
+}
+
+// keysetOperator matches the rows after the given primary key
+type keysetOperator struct {
+	column mappedColumn
+	after  any
+}
+
+func (o keysetOperator) haveDriverRender(driver Driver) (statement, error) {
+	key := operatorParameterKey(o.column, ">", o.after)
+
+	return statement{
+		Query: fmt.Sprintf("%s > %s", driver.quoteColumn(o.column), key),
+		Parameters: map[string]any{
+			key: o.after,
+		},
+	}, nil
+}
@@ -0,0 +1,90 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func TestCopy(t *testing.T) {
+	entities := []database.Entity{UserV2{}, Company{}}
+	var sourceDB *sql.DB
+	sourceStatements := []string{}
+	source, err := database.New(
+		database.NewDriverSQLite(fmt.Sprintf("%s/source.sqlite", t.TempDir())),
+		database.WithPostConnectFunc(func(db *sql.DB) error {
+			sourceDB = db
+			return nil
+		}),
+		database.WithPreRunFunc(func(ctx context.Context, statement string, args []any) error {
+			sourceStatements = append(sourceStatements, statement)
+			return nil
+		}),
+	)
+	assert.NilError(t, err)
+
+	_, err = source.AutoMigrate(t.Context(), entities)
+	assert.NilError(t, err)
+
+	companyRepo := database.NewRepository[CompanyID, Company](source)
+	userRepo := database.NewRepository[UserID, UserV2](source)
+
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := range 5 {
+		companyID, err := companyRepo.Insert(t.Context(), Company{String: fmt.Sprintf("company-%d", i)})
+		assert.NilError(t, err)
+
+		userID, err := userRepo.Insert(t.Context(), UserV2{Email: fmt.Sprintf("user-%d@example.com", i), CompanyID: companyID})
+		assert.NilError(t, err)
+
+		// Read only columns are copied as they are
+		_, err = sourceDB.ExecContext(t.Context(), "UPDATE `user` SET `created_at` = ? WHERE `id` = ?", createdAt, userID)
+		assert.NilError(t, err)
+	}
+
+	// Gaps in the primary keys are kept
+	assert.NilError(t, userRepo.Delete(t.Context(), UserV2{ID: 2}))
+
+	target, err := database.New(database.NewDriverSQLite(fmt.Sprintf("%s/target.sqlite", t.TempDir())))
+	assert.NilError(t, err)
+
+	sourceStatements = []string{}
+	results, err := database.Copy(t.Context(), source, target, entities, database.WithCopyBatchSize(2))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, results, []database.CopyResult{
+		{Table: "company", Rows: 5},
+		{Table: "user", Rows: 4},
+	})
+
+	targetUserRepo := database.NewRepository[UserID, UserV2](target)
+	users, err := targetUserRepo.SelectMultiple(t.Context())
+	assert.NilError(t, err)
+	assert.Equal(t, len(users), 4)
+	assert.Equal(t, users[1].ID, UserID(3))
+	assert.Equal(t, users[1].Email, "user-2@example.com")
+	assert.Equal(t, users[1].CompanyID, CompanyID(3))
+	assert.Assert(t, users[1].CreatedAt.Equal(createdAt))
+
+	// Pages continue after the last primary key instead of skipping rows
+	for _, statement := range sourceStatements {
+		assert.Assert(t, !strings.Contains(statement, "OFFSET") || strings.Contains(statement, "OFFSET 0"), statement)
+	}
+
+	// Sequences continue after the copied rows
+	newID, err := targetUserRepo.Insert(t.Context(), UserV2{Email: "new@example.com", CompanyID: 1})
+	assert.NilError(t, err)
+	assert.Equal(t, newID, UserID(6))
+
+	// Rows already in the target are never mixed with copied ones
+	_, err = database.Copy(t.Context(), source, target, entities)
+	assert.ErrorIs(t, err, database.ErrCopyTargetNotEmpty)
+
+	_, err = database.Copy(t.Context(), source, target, entities, database.WithCopyBatchSize(0))
+	assert.ErrorContains(t, err, "invalid batch size")
+}
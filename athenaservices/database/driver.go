@@ -2,23 +2,28 @@ package database
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"maps"
+	"regexp"
 	"strings"
+	"time"
 )
 
 var (
 	ErrNoRows                   = errors.New("no rows found")
 	ErrBlankQuery               = errors.New("blank query")
 	ErrTableNotFound            = errors.New("table not found")
+	ErrUnsupportedIndex         = errors.New("index not supported by driver")
 	errNeedsAutoMigrateOverride = errors.New("needs auto migrate override")
 )
 
 type Driver interface {
 	Open() (*sql.DB, error)
-	setMapping(mapping map[uintptr]string)
+	setMapping(mapping map[uintptr]mappedColumn)
 	autoMigrateAdjustTableDefinition(table Table) Table
 	autoMigrateColumnAlter(table Table, column TableColumn) ([]statement, error)
 	autoMigrateColumnCreate(table Table, column TableColumn) ([]statement, error)
@@ -27,9 +32,11 @@ type Driver interface {
 	autoMigrateIndexCreate(table Table, column TableIndex) ([]statement, error)
 	autoMigrateIndexDrop(table Table, column TableIndex) ([]statement, error)
 	autoMigrateOverride(sourceTable Table, targetTable Table) []statement
+	autoMigrateSearch(ctx context.Context, service *Service, table Table, rebuild bool) ([]statement, error)
 	autoMigrateTableCreate(table Table) ([]statement, error)
 	autoMigrateTableDrop(table Table) ([]statement, error)
 	autoMigrateTableGet(ctx context.Context, service *Service, tableName string) (Table, error)
+	autoMigrateTableList(ctx context.Context, service *Service) ([]string, error)
 	convertTypeBool() string
 	convertTypeDateTime() string
 	convertTypeFloat32() string
@@ -40,18 +47,30 @@ type Driver interface {
 	convertTypeInt64() string
 	convertTypeInt8() string
 	convertTypeJSON() string
+	convertTypeLongText() string
 	convertTypeString() string
 	convertTypeUint() string
 	convertTypeUint16() string
 	convertTypeUint32() string
 	convertTypeUint64() string
 	convertTypeUint8() string
-	generateDelete(entity Entity) (statement, error)
-	generateInsert(entity Entity) (statement, error)
+	explain(ctx context.Context, service *Service, query string, args []any) ([]QueryPlanStep, error)
+	generateColumnComparison(o columnComparison) (statement, error)
+	generateCount(tableName string) (statement, error)
+	generateDelete(entity Entity, scope OperatorOfLogic) (statement, error)
+	generateInsert(entity Entity, withPrimaryKey bool, withReadOnly bool) (statement, error)
 	generateSelect(query Query) (statement, error)
+	generateJSONContains(o jsonContainsOperator) (statement, error)
+	generateJSONPath(o jsonPathOperator) (statement, error)
+	generateSearchMatch(o searchMatch) (statement, error)
+	generateSearchRelevance(o searchMatch) (statement, error)
+	generateSequenceReset(table Table) ([]statement, error)
 	generateSimpleOperatorOfEquality(o simpleOperatorOfEquality) (statement, error)
 	generateSimpleOperatorOfLogic(o simpleOperatorOfLogic) (statement, error)
-	generateUpdate(entity Entity) (statement, error)
+	generateSubquery(o subqueryOperator) (statement, error)
+	generateUpdate(entity Entity, scope OperatorOfLogic) (statement, error)
+	lockAcquire(ctx context.Context, service *Service, name string, ttl time.Duration) (heldLock, error)
+	quoteColumn(column mappedColumn) string
 	usesLastInsertId() bool
 	usesNumberedParameters() bool
 }
@@ -75,3 +94,62 @@ func generateSimpleOperatorOfLogic(driver Driver, o simpleOperatorOfLogic) (stat
 		Parameters: parameters,
 	}, nil
 }
+
+// appendScope narrows the WHERE of an update or delete with the scope
+func appendScope(driver Driver, s statement, scope OperatorOfLogic) (statement, error) {
+	if scope == nil || !scope.hasAny() {
+		return s, nil
+	}
+
+	scopeStatement, err := scope.haveDriverRender(driver)
+	if err != nil {
+		return statement{}, err
+	}
+
+	s.Query += " AND " + scopeStatement.Query
+	maps.Copy(s.Parameters, scopeStatement.Parameters)
+
+	return s, nil
+}
+
+// generateOrderAndLimit renders the ORDER BY and LIMIT clauses of a select,
+// it is empty when the query is neither ordered nor limited
+func generateOrderAndLimit(driver Driver, query Query) (statement, error) {
+	s := statement{Parameters: map[string]any{}}
+
+	orderBy := []string{}
+	if query.relevance != nil {
+		relevance, err := driver.generateSearchRelevance(*query.relevance)
+		if err != nil {
+			return statement{}, err
+		}
+
+		orderBy = append(orderBy, relevance.Query)
+		maps.Copy(s.Parameters, relevance.Parameters)
+	}
+
+	if query.OrderBy != "" {
+		orderBy = append(orderBy, driver.quoteColumn(mappedColumn{Table: query.From, Column: query.OrderBy}))
+	}
+
+	if len(orderBy) > 0 {
+		s.Query += " ORDER BY " + strings.Join(orderBy, ", ")
+	}
+
+	if query.Limit.Count > 0 {
+		s.Query += fmt.Sprintf(" LIMIT %d OFFSET %d", query.Limit.Count, query.Limit.Offset)
+	}
+
+	return s, nil
+}
+
+// operatorParameterKey derives the key from the whole condition so conditions
+// on the same column, in a query or its subqueries, don't overwrite each
+// other's parameters
+func operatorParameterKey(column mappedColumn, operator string, value any) string {
+	hash := sha256.Sum256(fmt.Appendf(nil, "%#v", []any{column, operator, value}))
+
+	return fmt.Sprintf(":%s_%s", parameterKeyCleaner.ReplaceAllString(column.Column, "_"), hex.EncodeToString(hash[:6]))
+}
+
+var parameterKeyCleaner = regexp.MustCompile(`\W`)
@@ -8,9 +8,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"math"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/lunagic/athena/athenaservices/database/internal/utils"
@@ -32,7 +36,7 @@ type DriverMySQLConfig struct {
 
 type driverMySQL struct {
 	config  DriverMySQLConfig
-	mapping map[uintptr]string
+	mapping map[uintptr]mappedColumn
 }
 
 func (driver *driverMySQL) Open() (*sql.DB, error) {
@@ -48,11 +52,19 @@ func (driver *driverMySQL) Open() (*sql.DB, error) {
 	))
 }
 
-func (driver *driverMySQL) setMapping(mapping map[uintptr]string) {
+func (driver *driverMySQL) setMapping(mapping map[uintptr]mappedColumn) {
 	driver.mapping = mapping
 }
 
 func (driver *driverMySQL) autoMigrateAdjustTableDefinition(table Table) Table {
+	for _, column := range table.searchColumns {
+		table.Indexes = append(table.Indexes, TableIndex{
+			Name:    searchIndexName(table, column),
+			Columns: []string{column},
+			Method:  "fulltext",
+		})
+	}
+
 	return table
 }
 
@@ -118,7 +130,7 @@ func (driver *driverMySQL) autoMigrateIndexAlter(table Table, index TableIndex)
 }
 
 func (driver *driverMySQL) autoMigrateIndexCreate(table Table, index TableIndex) ([]statement, error) {
-	indexStatement, err := driver.renderIndex(index)
+	indexStatement, err := driver.renderIndex(table, index)
 	if err != nil {
 		return nil, err
 	}
@@ -150,6 +162,11 @@ func (driver *driverMySQL) autoMigrateOverride(sourceTable Table, targetTable Ta
 	return nil
 }
 
+func (driver *driverMySQL) autoMigrateSearch(ctx context.Context, service *Service, table Table, rebuild bool) ([]statement, error) {
+	// The FULLTEXT indexes added when adjusting the table are all that is needed
+	return nil, nil
+}
+
 func (driver *driverMySQL) autoMigrateTableCreate(table Table) ([]statement, error) {
 	parts := []string{}
 
@@ -163,7 +180,7 @@ func (driver *driverMySQL) autoMigrateTableCreate(table Table) ([]statement, err
 	}
 
 	for _, index := range table.Indexes {
-		part, err := driver.renderIndex(index)
+		part, err := driver.renderIndex(table, index)
 		if err != nil {
 			return nil, err
 		}
@@ -204,6 +221,27 @@ func (driver *driverMySQL) autoMigrateTableDrop(table Table) ([]statement, error
 	}, nil
 }
 
+func (driver *driverMySQL) autoMigrateTableList(ctx context.Context, service *Service) ([]string, error) {
+	tableNames := []tableNameRow{}
+	if err := service.runSelect(ctx, statement{
+		Query: `
+			SELECT TABLE_NAME AS table_name
+			FROM information_schema.tables
+			WHERE
+				table_schema = :database
+				AND table_type = 'BASE TABLE'
+			ORDER BY TABLE_NAME;
+		`,
+		Parameters: map[string]any{
+			":database": driver.config.Name,
+		},
+	}, &tableNames); err != nil {
+		return nil, err
+	}
+
+	return tableNamesFromRows(tableNames), nil
+}
+
 func (driver *driverMySQL) autoMigrateTableGet(
 	ctx context.Context,
 	service *Service,
@@ -340,13 +378,18 @@ func (driver *driverMySQL) autoMigrateTableGet(
 				SELECT
 					INDEX_NAME,
 					COLUMN_NAME,
-					NON_UNIQUE
+					NON_UNIQUE,
+					COLLATION,
+					INDEX_TYPE
 				FROM
 					information_schema.statistics
 				WHERE
 					table_schema = :database
 					AND table_name = :table
 					AND INDEX_NAME != "PRIMARY"
+				ORDER BY
+					INDEX_NAME,
+					SEQ_IN_INDEX
 			`,
 			Parameters: map[string]any{
 				":database": driver.config.Name,
@@ -360,18 +403,29 @@ func (driver *driverMySQL) autoMigrateTableGet(
 
 		for _, mysqlIndex := range mysqlIndexes {
 			existingIndexIndex, alreadySeenThisKey := lookupForDuplicates[mysqlIndex.Name]
-			if alreadySeenThisKey {
-				indexes[existingIndexIndex].Columns = append(indexes[existingIndexIndex].Columns, mysqlIndex.Column)
-				continue
+			if !alreadySeenThisKey {
+				index := TableIndex{
+					Name:   mysqlIndex.Name,
+					Unique: mysqlIndex.NonUnique == "0",
+				}
+				if mysqlIndex.Type != "BTREE" {
+					index.Method = strings.ToLower(mysqlIndex.Type)
+				}
+
+				indexes = append(indexes, index)
+				existingIndexIndex = len(indexes) - 1
+				lookupForDuplicates[mysqlIndex.Name] = existingIndexIndex
 			}
-			index := TableIndex{
-				Name:    mysqlIndex.Name,
-				Columns: []string{mysqlIndex.Column},
-				Unique:  mysqlIndex.NonUnique == "0",
+
+			// Key parts of functional indexes have no column
+			if !mysqlIndex.Column.Valid {
+				continue
 			}
 
-			indexes = append(indexes, index)
-			lookupForDuplicates[mysqlIndex.Name] = len(indexes) - 1
+			indexes[existingIndexIndex].Columns = append(indexes[existingIndexIndex].Columns, mysqlIndex.Column.String)
+			if mysqlIndex.Collation != nil && *mysqlIndex.Collation == "D" {
+				indexes[existingIndexIndex].Descending = append(indexes[existingIndexIndex].Descending, mysqlIndex.Column.String)
+			}
 		}
 	}
 
@@ -435,6 +489,10 @@ func (driver *driverMySQL) convertTypeFloat64() string {
 	return "double"
 }
 
+func (driver *driverMySQL) convertTypeLongText() string {
+	return "longtext"
+}
+
 func (driver *driverMySQL) convertTypeString() string {
 	return "varchar(255)"
 }
@@ -447,7 +505,24 @@ func (driver *driverMySQL) convertTypeJSON() string {
 	return "longtext"
 }
 
-func (driver *driverMySQL) generateDelete(e Entity) (statement, error) {
+func (driver *driverMySQL) generateColumnComparison(o columnComparison) (statement, error) {
+	left, err := lookupColumn(driver.mapping, o.Left)
+	if err != nil {
+		return statement{}, err
+	}
+
+	right, err := lookupColumn(driver.mapping, o.Right)
+	if err != nil {
+		return statement{}, err
+	}
+
+	return statement{
+		Query:      fmt.Sprintf("%s %s %s", driver.quoteColumn(left), o.Operator, driver.quoteColumn(right)),
+		Parameters: map[string]any{},
+	}, nil
+}
+
+func (driver *driverMySQL) generateDelete(e Entity, scope OperatorOfLogic) (statement, error) {
 	id := int64(0)
 
 	if err := utils.LoopOverStructFields(reflect.ValueOf(e), func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
@@ -470,17 +545,17 @@ func (driver *driverMySQL) generateDelete(e Entity) (statement, error) {
 		return statement{}, err
 	}
 
-	return statement{
+	return appendScope(driver, statement{
 		Query: fmt.Sprintf(
 			"DELETE FROM `%s` WHERE id = %d",
 			e.TableStructure().Name,
 			id,
 		),
 		Parameters: map[string]any{},
-	}, nil
+	}, scope)
 }
 
-func (driver *driverMySQL) generateInsert(e Entity) (statement, error) {
+func (driver *driverMySQL) generateInsert(e Entity, withPrimaryKey bool, withReadOnly bool) (statement, error) {
 	columns := []string{}
 	values := []string{}
 	parameters := map[string]any{}
@@ -491,11 +566,11 @@ func (driver *driverMySQL) generateInsert(e Entity) (statement, error) {
 			return nil
 		}
 
-		if tag.ReadOnly {
+		if tag.ReadOnly && !withReadOnly {
 			return nil
 		}
 
-		if tag.AutoIncrement {
+		if tag.AutoIncrement && !withPrimaryKey {
 			return nil
 		}
 
@@ -532,6 +607,13 @@ func (driver *driverMySQL) generateInsert(e Entity) (statement, error) {
 	}, nil
 }
 
+func (driver *driverMySQL) generateCount(tableName string) (statement, error) {
+	return statement{
+		Query:      fmt.Sprintf("SELECT COUNT(*) AS `count` FROM `%s`", tableName),
+		Parameters: map[string]any{},
+	}, nil
+}
+
 func (driver *driverMySQL) generateSelect(query Query) (statement, error) {
 	selects := []string{}
 	for _, column := range query.Select {
@@ -552,22 +634,144 @@ func (driver *driverMySQL) generateSelect(query Query) (statement, error) {
 		}
 	}
 
+	orderBy, err := generateOrderAndLimit(driver, query)
+	if err != nil {
+		return statement{}, err
+	}
+	queryString += orderBy.Query
+	maps.Copy(parameters, orderBy.Parameters)
+
 	return statement{
 		Query:      queryString,
 		Parameters: parameters,
 	}, nil
 }
 
+// explain reads the tabular EXPLAIN output since its JSON format differs
+// between MySQL and MariaDB, an access type of ALL is a full table scan
+func (driver *driverMySQL) explain(ctx context.Context, service *Service, query string, args []any) ([]QueryPlanStep, error) {
+	rows, err := service.explainRows(ctx, "EXPLAIN "+query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := []QueryPlanStep{}
+	for _, row := range rows {
+		steps = append(steps, QueryPlanStep{
+			Table:    row["table"],
+			Index:    row["key"],
+			FullScan: row["type"] == "ALL",
+			Detail:   strings.TrimSpace(fmt.Sprintf("%s %s", row["type"], row["Extra"])),
+		})
+	}
+
+	return steps, nil
+}
+
+func (driver *driverMySQL) generateJSONPath(o jsonPathOperator) (statement, error) {
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	segments, err := parseJSONPath(o.Path)
+	if err != nil {
+		return statement{}, err
+	}
+
+	path := renderJSONPath(segments)
+	pathKey := jsonParameterKey(column.Column, "path", path)
+	valueKey := jsonParameterKey(column.Column, "value", path, o.Operator, o.Value)
+
+	// Unquoted booleans come out as the text true or false
+	value := o.Value
+	if boolean, isBool := value.(bool); isBool {
+		value = strconv.FormatBool(boolean)
+	}
+
+	return statement{
+		Query: fmt.Sprintf("JSON_UNQUOTE(JSON_EXTRACT(%s, %s)) %s %s", driver.quoteColumn(column), pathKey, o.Operator, valueKey),
+		Parameters: map[string]any{
+			pathKey:  path,
+			valueKey: value,
+		},
+	}, nil
+}
+
+func (driver *driverMySQL) generateJSONContains(o jsonContainsOperator) (statement, error) {
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	valueBytes, err := json.Marshal(o.Value)
+	if err != nil {
+		return statement{}, err
+	}
+
+	valueKey := jsonParameterKey(column.Column, "contains", string(valueBytes))
+
+	return statement{
+		Query: fmt.Sprintf("JSON_CONTAINS(%s, %s)", driver.quoteColumn(column), valueKey),
+		Parameters: map[string]any{
+			valueKey: string(valueBytes),
+		},
+	}, nil
+}
+
+func (driver *driverMySQL) generateSearchMatch(o searchMatch) (statement, error) {
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	key := o.parameterKey(column)
+
+	return statement{
+		Query: fmt.Sprintf("MATCH(`%s`) AGAINST(%s IN BOOLEAN MODE)", column.Column, key),
+		Parameters: map[string]any{
+			key: mysqlSearchQuery(o.Query),
+		},
+	}, nil
+}
+
+func (driver *driverMySQL) generateSearchRelevance(o searchMatch) (statement, error) {
+	match, err := driver.generateSearchMatch(o)
+	if err != nil {
+		return statement{}, err
+	}
+
+	match.Query += " DESC"
+
+	return match, nil
+}
+
+// mysqlSearchQuery requires every word of the query as a phrase so the
+// boolean mode operators can't be injected
+func mysqlSearchQuery(query string) string {
+	terms := []string{}
+	for _, term := range searchTerms(query) {
+		terms = append(terms, fmt.Sprintf(`+"%s"`, strings.ReplaceAll(term, `"`, "")))
+	}
+
+	return strings.Join(terms, " ")
+}
+
+func (driver *driverMySQL) generateSequenceReset(table Table) ([]statement, error) {
+	// Explicit inserts already move the auto increment counter forward
+	return nil, nil
+}
+
 func (driver *driverMySQL) generateSimpleOperatorOfEquality(o simpleOperatorOfEquality) (statement, error) {
-	columnName := driver.mapping[uintptr(reflect.ValueOf(o.Column).UnsafePointer())]
-	if columnName == "" {
+	column := driver.mapping[uintptr(reflect.ValueOf(o.Column).UnsafePointer())]
+	if column.Column == "" {
 		return statement{}, errors.New("unknown column")
 	}
 
-	key := fmt.Sprintf(":%s", columnName)
+	key := operatorParameterKey(column, o.Operator, o.Value)
 
 	return statement{
-		Query: fmt.Sprintf("`%s` %s %s", columnName, o.Operator, key),
+		Query: fmt.Sprintf("%s %s %s", driver.quoteColumn(column), o.Operator, key),
 		Parameters: map[string]any{
 			key: o.Value,
 		},
@@ -578,7 +782,20 @@ func (driver *driverMySQL) generateSimpleOperatorOfLogic(o simpleOperatorOfLogic
 	return generateSimpleOperatorOfLogic(driver, o)
 }
 
-func (driver *driverMySQL) generateUpdate(e Entity) (statement, error) {
+func (driver *driverMySQL) generateSubquery(o subqueryOperator) (statement, error) {
+	if o.Column == nil {
+		return renderSubquery(driver, driver.mapping, o, "")
+	}
+
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	return renderSubquery(driver, driver.mapping, o, driver.quoteColumn(column))
+}
+
+func (driver *driverMySQL) generateUpdate(e Entity, scope OperatorOfLogic) (statement, error) {
 	sets := []string{}
 	id := int64(0)
 	parameters := map[string]any{}
@@ -617,7 +834,7 @@ func (driver *driverMySQL) generateUpdate(e Entity) (statement, error) {
 		return statement{}, err
 	}
 
-	return statement{
+	return appendScope(driver, statement{
 		Query: fmt.Sprintf(
 			"UPDATE `%s` SET %s WHERE id = %d",
 			e.TableStructure().Name,
@@ -625,7 +842,7 @@ func (driver *driverMySQL) generateUpdate(e Entity) (statement, error) {
 			id,
 		),
 		Parameters: parameters,
-	}, nil
+	}, scope)
 }
 
 func (driver *driverMySQL) usesLastInsertId() bool {
@@ -670,9 +887,11 @@ type mysqlInfoTableColumn struct {
 }
 
 type mysqlInfoTableIndex struct {
-	Name      string `db:"INDEX_NAME"`
-	Column    string `db:"COLUMN_NAME"`
-	NonUnique string `db:"NON_UNIQUE"`
+	Name      string         `db:"INDEX_NAME"`
+	Column    sql.NullString `db:"COLUMN_NAME"`
+	NonUnique string         `db:"NON_UNIQUE"`
+	Collation *string        `db:"COLLATION"`
+	Type      string         `db:"INDEX_TYPE"`
 }
 
 type mysqlInfoTableForeignKeys struct {
@@ -704,10 +923,15 @@ func (driver *driverMySQL) renderColumn(column TableColumn) (string, error) {
 	return fmt.Sprintf("`%s` %s%s%s%s COMMENT '%s'", column.Name, column.Type, defaultStuff, nullable, extras, column.Comment), nil
 }
 
-func (driver *driverMySQL) renderIndex(index TableIndex) (string, error) {
-	columns := []string{}
-	for _, column := range index.Columns {
-		columns = append(columns, fmt.Sprintf("`%s`", column))
+// renderIndex rejects what MySQL and MariaDB can't store, descending columns
+// need MySQL 8 or MariaDB 10.8 as older versions silently ignore them
+func (driver *driverMySQL) renderIndex(table Table, index TableIndex) (string, error) {
+	if index.Where != "" {
+		return "", unsupportedIndex(table, index, "partial indexes")
+	}
+
+	if len(index.Expressions) > 0 {
+		return "", unsupportedIndex(table, index, "expression indexes")
 	}
 
 	unique := ""
@@ -715,7 +939,21 @@ func (driver *driverMySQL) renderIndex(index TableIndex) (string, error) {
 		unique = "UNIQUE "
 	}
 
-	return fmt.Sprintf("%sKEY `%s` (%s)", unique, index.Name, strings.Join(columns, ", ")), nil
+	switch strings.ToLower(index.Method) {
+	case "", "btree":
+	case "fulltext":
+		if index.Unique || len(index.Descending) > 0 {
+			return "", unsupportedIndex(table, index, "unique or descending fulltext")
+		}
+
+		unique = "FULLTEXT "
+	default:
+		return "", unsupportedIndex(table, index, fmt.Sprintf("method %s", index.Method))
+	}
+
+	return fmt.Sprintf("%sKEY `%s` (%s)", unique, index.Name, renderIndexKeyParts(index, func(column string) string {
+		return fmt.Sprintf("`%s`", column)
+	})), nil
 }
 
 func (driver *driverMySQL) renderForeignKey(table Table, column TableColumn) (string, error) {
@@ -733,3 +971,22 @@ func (driver *driverMySQL) renderForeignKey(table Table, column TableColumn) (st
 		column.ForeignKey.TargetColumn,
 	), nil
 }
+
+func (driver *driverMySQL) lockAcquire(ctx context.Context, service *Service, name string, ttl time.Duration) (heldLock, error) {
+	return acquireSessionLock(
+		ctx,
+		service.standardLibraryDB,
+		// wait_timeout is in whole seconds, rounding up keeps the lock until the ttl passed
+		fmt.Sprintf("SET SESSION wait_timeout = %d", int64(math.Ceil(max(ttl, time.Second).Seconds()))),
+		"SELECT GET_LOCK(?, 0)",
+		"DO RELEASE_LOCK(?)",
+		[]any{mysqlLockName(name)},
+		ttl,
+	)
+}
+
+// quoteColumn qualifies the column with its table so it can be told apart
+// from columns of the other tables of a subquery
+func (driver *driverMySQL) quoteColumn(column mappedColumn) string {
+	return fmt.Sprintf("`%s`.`%s`", column.Table, column.Column)
+}
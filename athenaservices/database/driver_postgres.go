@@ -6,8 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/lunagic/athena/athenaservices/database/internal/utils"
@@ -29,7 +33,7 @@ type DriverPostgresConfig struct {
 
 type driverPostgres struct {
 	config  DriverPostgresConfig
-	mapping map[uintptr]string
+	mapping map[uintptr]mappedColumn
 }
 
 func (driver *driverPostgres) Open() (*sql.DB, error) {
@@ -46,11 +50,19 @@ func (driver *driverPostgres) Open() (*sql.DB, error) {
 	)
 }
 
-func (driver *driverPostgres) setMapping(mapping map[uintptr]string) {
+func (driver *driverPostgres) setMapping(mapping map[uintptr]mappedColumn) {
 	driver.mapping = mapping
 }
 
 func (driver *driverPostgres) autoMigrateAdjustTableDefinition(table Table) Table {
+	for _, column := range table.searchColumns {
+		table.Indexes = append(table.Indexes, TableIndex{
+			Name:        searchIndexName(table, column),
+			Expressions: []string{postgresSearchVector(column)},
+			Method:      "gin",
+		})
+	}
+
 	return table
 }
 
@@ -91,10 +103,12 @@ func (driver *driverPostgres) autoMigrateColumnAlter(table Table, column TableCo
 	{ // Confirm type
 		statements = append(statements, statement{
 			Query: fmt.Sprintf(
-				`ALTER TABLE "%s" ALTER COLUMN "%s" TYPE %s;`,
+				`ALTER TABLE "%s" ALTER COLUMN "%s" TYPE %s USING "%s"::%s;`,
 				table.Name,
 				column.Name,
 				column.Type,
+				column.Name,
+				column.Type,
 			),
 		})
 	}
@@ -169,14 +183,32 @@ func (driver *driverPostgres) autoMigrateIndexCreate(table Table, index TableInd
 		unique = " UNIQUE"
 	}
 
+	method := ""
+	if index.Method != "" {
+		if !slices.Contains(postgresIndexMethods, strings.ToLower(index.Method)) {
+			return nil, unsupportedIndex(table, index, fmt.Sprintf("method %s", index.Method))
+		}
+
+		method = fmt.Sprintf(" USING %s", strings.ToLower(index.Method))
+	}
+
+	where := ""
+	if index.Where != "" {
+		where = fmt.Sprintf(" WHERE %s", index.Where)
+	}
+
 	return []statement{
 		{
 			Query: fmt.Sprintf(
-				`CREATE%s INDEX "%s" ON "%s" (%s)`,
+				`CREATE%s INDEX "%s" ON "%s"%s (%s)%s`,
 				unique,
 				index.Name,
 				table.Name,
-				strings.Join(index.Columns, ", "),
+				method,
+				renderIndexKeyParts(index, func(column string) string {
+					return column
+				}),
+				where,
 			),
 			Parameters: map[string]any{},
 		},
@@ -200,6 +232,11 @@ func (driver *driverPostgres) autoMigrateOverride(sourceTable Table, targetTable
 	return nil
 }
 
+func (driver *driverPostgres) autoMigrateSearch(ctx context.Context, service *Service, table Table, rebuild bool) ([]statement, error) {
+	// The GIN indexes added when adjusting the table are all that is needed
+	return nil, nil
+}
+
 func (driver *driverPostgres) autoMigrateTableCreate(table Table) ([]statement, error) {
 	parts := []string{}
 	for _, column := range table.columns {
@@ -262,6 +299,28 @@ func (driver *driverPostgres) autoMigrateTableDrop(table Table) ([]statement, er
 	}, nil
 }
 
+func (driver *driverPostgres) autoMigrateTableList(ctx context.Context, service *Service) ([]string, error) {
+	tableNames := []tableNameRow{}
+	if err := service.runSelect(ctx, statement{
+		Query: `
+			SELECT table_name
+			FROM information_schema.tables
+			WHERE
+				table_catalog = :database
+				AND table_schema = current_schema()
+				AND table_type = 'BASE TABLE'
+			ORDER BY table_name;
+		`,
+		Parameters: map[string]any{
+			":database": driver.config.Name,
+		},
+	}, &tableNames); err != nil {
+		return nil, err
+	}
+
+	return tableNamesFromRows(tableNames), nil
+}
+
 func (driver *driverPostgres) autoMigrateTableGet(ctx context.Context, service *Service, tableName string) (Table, error) {
 	table := Table{
 		Name: tableName,
@@ -380,32 +439,25 @@ func (driver *driverPostgres) autoMigrateTableGet(ctx context.Context, service *
 		Query: fmt.Sprintf(`
 			SELECT
 				i.relname AS index_name,
-				STRING_AGG(a.attname, ',') AS indexed_columns,
-				ix.indisunique AS is_unique
+				pg_get_indexdef(ix.indexrelid) AS definition
 			FROM
 				pg_index ix
 			JOIN
 				pg_class i ON i.oid = ix.indexrelid
 			JOIN
 				pg_class t ON t.oid = ix.indrelid
-			JOIN
-				pg_attribute a ON a.attnum = ANY(ix.indkey) AND a.attrelid = t.oid
 			WHERE
 				t.relname = '%s'
 				AND i.relname NOT LIKE '%%_pkey'
-			GROUP BY
-				i.relname, ix.indisunique;
+			ORDER BY
+				i.relname;
 		`, tableName),
 	}, &indexes); err != nil {
 		return Table{}, err
 	}
 
 	for _, index := range indexes {
-		table.Indexes = append(table.Indexes, TableIndex{
-			Name:    index.Name,
-			Columns: strings.Split(index.Columns, ","),
-			Unique:  index.Unique,
-		})
+		table.Indexes = append(table.Indexes, parseIndexDefinition(index.Name, index.Definition))
 	}
 
 	return table, nil
@@ -463,6 +515,10 @@ func (driver *driverPostgres) convertTypeFloat64() string {
 	return "double precision"
 }
 
+func (driver *driverPostgres) convertTypeLongText() string {
+	return "text"
+}
+
 func (driver *driverPostgres) convertTypeString() string {
 	return "text"
 }
@@ -472,10 +528,28 @@ func (driver *driverPostgres) convertTypeDateTime() string {
 }
 
 func (driver *driverPostgres) convertTypeJSON() string {
-	return "json"
+	// jsonb can be indexed and queried with containment
+	return "jsonb"
+}
+
+func (driver *driverPostgres) generateColumnComparison(o columnComparison) (statement, error) {
+	left, err := lookupColumn(driver.mapping, o.Left)
+	if err != nil {
+		return statement{}, err
+	}
+
+	right, err := lookupColumn(driver.mapping, o.Right)
+	if err != nil {
+		return statement{}, err
+	}
+
+	return statement{
+		Query:      fmt.Sprintf("%s %s %s", driver.quoteColumn(left), o.Operator, driver.quoteColumn(right)),
+		Parameters: map[string]any{},
+	}, nil
 }
 
-func (driver *driverPostgres) generateDelete(e Entity) (statement, error) {
+func (driver *driverPostgres) generateDelete(e Entity, scope OperatorOfLogic) (statement, error) {
 	id := int64(0)
 
 	if err := utils.LoopOverStructFields(reflect.ValueOf(e), func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
@@ -498,17 +572,17 @@ func (driver *driverPostgres) generateDelete(e Entity) (statement, error) {
 		return statement{}, err
 	}
 
-	return statement{
+	return appendScope(driver, statement{
 		Query: fmt.Sprintf(
 			`DELETE FROM "%s" WHERE id = %d`,
 			e.TableStructure().Name,
 			id,
 		),
 		Parameters: map[string]any{},
-	}, nil
+	}, scope)
 }
 
-func (driver *driverPostgres) generateInsert(e Entity) (statement, error) {
+func (driver *driverPostgres) generateInsert(e Entity, withPrimaryKey bool, withReadOnly bool) (statement, error) {
 	columns := []string{}
 	values := []string{}
 	parameters := map[string]any{}
@@ -520,7 +594,7 @@ func (driver *driverPostgres) generateInsert(e Entity) (statement, error) {
 			return nil
 		}
 
-		if tag.ReadOnly {
+		if tag.ReadOnly && !withReadOnly {
 			return nil
 		}
 
@@ -528,7 +602,7 @@ func (driver *driverPostgres) generateInsert(e Entity) (statement, error) {
 			primaryKey = tag.Column
 		}
 
-		if tag.AutoIncrement {
+		if tag.AutoIncrement && !withPrimaryKey {
 			return nil
 		}
 
@@ -554,11 +628,18 @@ func (driver *driverPostgres) generateInsert(e Entity) (statement, error) {
 		return statement{}, err
 	}
 
+	// Identity columns refuse explicit values unless told otherwise
+	overriding := ""
+	if withPrimaryKey {
+		overriding = " OVERRIDING SYSTEM VALUE"
+	}
+
 	return statement{
 		Query: fmt.Sprintf(
-			`INSERT INTO "%s" (%s) VALUES (%s) RETURNING "%s" as "id"`,
+			`INSERT INTO "%s" (%s)%s VALUES (%s) RETURNING "%s" as "id"`,
 			e.TableStructure().Name,
 			strings.Join(columns, ", "),
+			overriding,
 			strings.Join(values, ", "),
 			primaryKey,
 		),
@@ -566,6 +647,13 @@ func (driver *driverPostgres) generateInsert(e Entity) (statement, error) {
 	}, nil
 }
 
+func (driver *driverPostgres) generateCount(tableName string) (statement, error) {
+	return statement{
+		Query:      fmt.Sprintf(`SELECT COUNT(*) AS "count" FROM "%s"`, tableName),
+		Parameters: map[string]any{},
+	}, nil
+}
+
 func (driver *driverPostgres) generateSelect(query Query) (statement, error) {
 	selects := []string{}
 	for _, column := range query.Select {
@@ -586,22 +674,201 @@ func (driver *driverPostgres) generateSelect(query Query) (statement, error) {
 		}
 	}
 
+	orderBy, err := generateOrderAndLimit(driver, query)
+	if err != nil {
+		return statement{}, err
+	}
+	queryString += orderBy.Query
+	maps.Copy(parameters, orderBy.Parameters)
+
 	return statement{
 		Query:      queryString,
 		Parameters: parameters,
 	}, nil
 }
 
+func (driver *driverPostgres) explain(ctx context.Context, service *Service, query string, args []any) ([]QueryPlanStep, error) {
+	rows, err := service.explainRows(ctx, "EXPLAIN (FORMAT JSON) "+query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, ErrNoRows
+	}
+
+	type planNode struct {
+		NodeType     string     `json:"Node Type"`
+		RelationName string     `json:"Relation Name"`
+		IndexName    string     `json:"Index Name"`
+		Plans        []planNode `json:"Plans"`
+	}
+
+	plans := []struct {
+		Plan planNode `json:"Plan"`
+	}{}
+	if err := json.Unmarshal([]byte(rows[0]["QUERY PLAN"]), &plans); err != nil {
+		return nil, err
+	}
+
+	steps := []QueryPlanStep{}
+	var walk func(node planNode)
+	walk = func(node planNode) {
+		detail := node.NodeType
+		if node.RelationName != "" {
+			detail += " on " + node.RelationName
+		}
+		if node.IndexName != "" {
+			detail += " using " + node.IndexName
+		}
+
+		steps = append(steps, QueryPlanStep{
+			Table:    node.RelationName,
+			Index:    node.IndexName,
+			FullScan: node.NodeType == "Seq Scan",
+			Detail:   detail,
+		})
+
+		for _, child := range node.Plans {
+			walk(child)
+		}
+	}
+
+	for _, plan := range plans {
+		walk(plan.Plan)
+	}
+
+	return steps, nil
+}
+
+func (driver *driverPostgres) generateJSONPath(o jsonPathOperator) (statement, error) {
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	segments, err := parseJSONPath(o.Path)
+	if err != nil {
+		return statement{}, err
+	}
+
+	elements := []string{}
+	for _, segment := range segments {
+		element := strconv.Itoa(segment.Index)
+		if segment.IsKey {
+			element = segment.Key
+		}
+
+		elements = append(elements, `"`+strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(element)+`"`)
+	}
+
+	path := "{" + strings.Join(elements, ",") + "}"
+	pathKey := jsonParameterKey(column.Column, "path", path)
+	valueKey := jsonParameterKey(column.Column, "value", path, o.Operator, o.Value)
+
+	// The extracted value is text so it is cast to compare like the value does
+	extracted := fmt.Sprintf(`(%s #>> CAST(%s AS text[]))`, driver.quoteColumn(column), pathKey)
+	switch {
+	case jsonIsNumber(o.Value):
+		extracted = fmt.Sprintf("CAST(%s AS numeric)", extracted)
+	case reflect.ValueOf(o.Value).Kind() == reflect.Bool:
+		extracted = fmt.Sprintf("CAST(%s AS boolean)", extracted)
+	}
+
+	return statement{
+		Query: fmt.Sprintf("%s %s %s", extracted, o.Operator, valueKey),
+		Parameters: map[string]any{
+			pathKey:  path,
+			valueKey: o.Value,
+		},
+	}, nil
+}
+
+func (driver *driverPostgres) generateJSONContains(o jsonContainsOperator) (statement, error) {
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	valueBytes, err := json.Marshal(o.Value)
+	if err != nil {
+		return statement{}, err
+	}
+
+	valueKey := jsonParameterKey(column.Column, "contains", string(valueBytes))
+
+	return statement{
+		Query: fmt.Sprintf(`%s @> CAST(%s AS jsonb)`, driver.quoteColumn(column), valueKey),
+		Parameters: map[string]any{
+			valueKey: string(valueBytes),
+		},
+	}, nil
+}
+
+func (driver *driverPostgres) generateSearchMatch(o searchMatch) (statement, error) {
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	key := o.parameterKey(column)
+
+	return statement{
+		Query: fmt.Sprintf("%s @@ plainto_tsquery('simple', %s)", postgresSearchVector(column.Column), key),
+		Parameters: map[string]any{
+			key: o.Query,
+		},
+	}, nil
+}
+
+func (driver *driverPostgres) generateSearchRelevance(o searchMatch) (statement, error) {
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	key := o.parameterKey(column)
+
+	return statement{
+		Query: fmt.Sprintf("ts_rank(%s, plainto_tsquery('simple', %s)) DESC", postgresSearchVector(column.Column), key),
+		Parameters: map[string]any{
+			key: o.Query,
+		},
+	}, nil
+}
+
+func (driver *driverPostgres) generateSequenceReset(table Table) ([]statement, error) {
+	statements := []statement{}
+	for _, column := range table.columns {
+		if !column.AutoIncrement {
+			continue
+		}
+
+		statements = append(statements, statement{
+			Query: fmt.Sprintf(
+				`SELECT setval(pg_get_serial_sequence('"%s"', '%s'), COALESCE((SELECT MAX("%s") FROM "%s"), 0) + 1, false)`,
+				table.Name,
+				column.Name,
+				column.Name,
+				table.Name,
+			),
+			Parameters: map[string]any{},
+		})
+	}
+
+	return statements, nil
+}
+
 func (driver *driverPostgres) generateSimpleOperatorOfEquality(o simpleOperatorOfEquality) (statement, error) {
-	columnName := driver.mapping[uintptr(reflect.ValueOf(o.Column).UnsafePointer())]
-	if columnName == "" {
+	column := driver.mapping[uintptr(reflect.ValueOf(o.Column).UnsafePointer())]
+	if column.Column == "" {
 		return statement{}, errors.New("unknown column")
 	}
 
-	key := fmt.Sprintf(":%s", columnName)
+	key := operatorParameterKey(column, o.Operator, o.Value)
 
 	return statement{
-		Query: fmt.Sprintf(`"%s" %s %s`, columnName, o.Operator, key),
+		Query: fmt.Sprintf("%s %s %s", driver.quoteColumn(column), o.Operator, key),
 		Parameters: map[string]any{
 			key: o.Value,
 		},
@@ -612,7 +879,20 @@ func (driver *driverPostgres) generateSimpleOperatorOfLogic(o simpleOperatorOfLo
 	return generateSimpleOperatorOfLogic(driver, o)
 }
 
-func (driver *driverPostgres) generateUpdate(e Entity) (statement, error) {
+func (driver *driverPostgres) generateSubquery(o subqueryOperator) (statement, error) {
+	if o.Column == nil {
+		return renderSubquery(driver, driver.mapping, o, "")
+	}
+
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	return renderSubquery(driver, driver.mapping, o, driver.quoteColumn(column))
+}
+
+func (driver *driverPostgres) generateUpdate(e Entity, scope OperatorOfLogic) (statement, error) {
 	sets := []string{}
 	id := int64(0)
 	parameters := map[string]any{}
@@ -651,7 +931,7 @@ func (driver *driverPostgres) generateUpdate(e Entity) (statement, error) {
 		return statement{}, err
 	}
 
-	return statement{
+	return appendScope(driver, statement{
 		Query: fmt.Sprintf(
 			`UPDATE "%s" SET %s WHERE id = %d`,
 			e.TableStructure().Name,
@@ -659,7 +939,7 @@ func (driver *driverPostgres) generateUpdate(e Entity) (statement, error) {
 			id,
 		),
 		Parameters: parameters,
-	}, nil
+	}, scope)
 }
 
 func (driver *driverPostgres) usesLastInsertId() bool {
@@ -681,11 +961,18 @@ type postgresColumn struct {
 }
 
 type postgresIndex struct {
-	Name    string `db:"index_name"`
-	Columns string `db:"indexed_columns"`
-	Unique  bool   `db:"is_unique"`
+	Name       string `db:"index_name"`
+	Definition string `db:"definition"`
 }
 
+// postgresSearchVector has to be written the same in the index and the
+// queries for the planner to use the index
+func postgresSearchVector(column string) string {
+	return fmt.Sprintf(`to_tsvector('simple', "%s")`, column)
+}
+
+var postgresIndexMethods = []string{"btree", "hash", "gin", "gist", "spgist", "brin"}
+
 type postgresForeignKey struct {
 	Name             string `db:"constraint_name"`
 	Column           string `db:"column_name"`
@@ -737,3 +1024,22 @@ func (driver *driverPostgres) renderForeignKey(table Table, column TableColumn)
 		column.ForeignKey.TargetColumn,
 	)
 }
+
+// lockAcquire uses a session level advisory lock keyed by a hash of the name
+func (driver *driverPostgres) lockAcquire(ctx context.Context, service *Service, name string, ttl time.Duration) (heldLock, error) {
+	return acquireSessionLock(
+		ctx,
+		service.standardLibraryDB,
+		fmt.Sprintf("SET idle_session_timeout = %d", max(ttl, time.Millisecond).Milliseconds()),
+		"SELECT pg_try_advisory_lock($1)",
+		"SELECT pg_advisory_unlock($1)",
+		[]any{lockKey(name)},
+		ttl,
+	)
+}
+
+// quoteColumn qualifies the column with its table so it can be told apart
+// from columns of the other tables of a subquery
+func (driver *driverPostgres) quoteColumn(column mappedColumn) string {
+	return fmt.Sprintf(`"%s"."%s"`, column.Table, column.Column)
+}
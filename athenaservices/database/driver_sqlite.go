@@ -6,8 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"reflect"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/lunagic/athena/athenaservices/database/internal/utils"
@@ -21,8 +25,10 @@ func NewDriverSQLite(path string) Driver {
 }
 
 type driverSQLite struct {
-	Path    string
-	mapping map[uintptr]string
+	Path              string
+	mapping           map[uintptr]mappedColumn
+	lockTableMigrated bool
+	lockTableMutex    sync.Mutex
 }
 
 func (driver *driverSQLite) Open() (*sql.DB, error) {
@@ -32,7 +38,7 @@ func (driver *driverSQLite) Open() (*sql.DB, error) {
 	)
 }
 
-func (driver *driverSQLite) setMapping(mapping map[uintptr]string) {
+func (driver *driverSQLite) setMapping(mapping map[uintptr]mappedColumn) {
 	driver.mapping = mapping
 }
 
@@ -90,10 +96,19 @@ func (driver *driverSQLite) autoMigrateIndexAlter(table Table, index TableIndex)
 }
 
 func (driver *driverSQLite) autoMigrateIndexCreate(table Table, index TableIndex) ([]statement, error) {
+	if index.Method != "" && !strings.EqualFold(index.Method, "btree") {
+		return nil, unsupportedIndex(table, index, fmt.Sprintf("method %s", index.Method))
+	}
+
+	where := ""
+	if index.Where != "" {
+		where = fmt.Sprintf(" WHERE %s", index.Where)
+	}
+
 	return []statement{
 		{
 			Query: fmt.Sprintf(
-				`CREATE%s INDEX "%s" ON "%s" (%s)`,
+				`CREATE%s INDEX "%s" ON "%s" (%s)%s`,
 				func() string {
 					if index.Unique {
 						return " UNIQUE"
@@ -103,7 +118,10 @@ func (driver *driverSQLite) autoMigrateIndexCreate(table Table, index TableIndex
 				}(),
 				index.Name,
 				table.Name,
-				strings.Join(index.Columns, ", "),
+				renderIndexKeyParts(index, func(column string) string {
+					return column
+				}),
+				where,
 			),
 		},
 	}, nil
@@ -190,6 +208,96 @@ func (driver *driverSQLite) autoMigrateOverride(sourceTable Table, targetTable T
 	return statements
 }
 
+// autoMigrateSearch keeps an external content FTS5 table with the searchable
+// columns in sync through triggers, it is recreated and rebuilt whenever its
+// definition changes
+func (driver *driverSQLite) autoMigrateSearch(ctx context.Context, service *Service, table Table, rebuild bool) ([]statement, error) {
+	searchTable := sqliteSearchTable(table.Name)
+
+	existing := []sqliteSchemaObject{}
+	if err := service.runSelect(ctx, statement{
+		Query: `
+			SELECT name, sql
+			FROM sqlite_master
+			WHERE name IN (:names);
+		`,
+		Parameters: map[string]any{
+			":names": []string{searchTable, searchTable + "_ai", searchTable + "_ad", searchTable + "_au"},
+		},
+	}, &existing); err != nil {
+		return nil, err
+	}
+
+	if len(table.searchColumns) == 0 && len(existing) == 0 {
+		return nil, nil
+	}
+
+	columns := []string{}
+	newValues := []string{}
+	oldValues := []string{}
+	for _, column := range table.searchColumns {
+		columns = append(columns, fmt.Sprintf(`"%s"`, column))
+		newValues = append(newValues, fmt.Sprintf(`new."%s"`, column))
+		oldValues = append(oldValues, fmt.Sprintf(`old."%s"`, column))
+	}
+
+	insertNew := fmt.Sprintf(`INSERT INTO "%s"(rowid, %s) VALUES (new.rowid, %s);`, searchTable, strings.Join(columns, ", "), strings.Join(newValues, ", "))
+	deleteOld := fmt.Sprintf(`INSERT INTO "%s"("%s", rowid, %s) VALUES ('delete', old.rowid, %s);`, searchTable, searchTable, strings.Join(columns, ", "), strings.Join(oldValues, ", "))
+
+	desired := map[string]string{
+		searchTable:         fmt.Sprintf(`CREATE VIRTUAL TABLE "%s" USING fts5(%s, content='%s')`, searchTable, strings.Join(columns, ", "), table.Name),
+		searchTable + "_ai": fmt.Sprintf(`CREATE TRIGGER "%s_ai" AFTER INSERT ON "%s" BEGIN %s END`, searchTable, table.Name, insertNew),
+		searchTable + "_ad": fmt.Sprintf(`CREATE TRIGGER "%s_ad" AFTER DELETE ON "%s" BEGIN %s END`, searchTable, table.Name, deleteOld),
+		searchTable + "_au": fmt.Sprintf(`CREATE TRIGGER "%s_au" AFTER UPDATE ON "%s" BEGIN %s %s END`, searchTable, table.Name, deleteOld, insertNew),
+	}
+
+	if !rebuild && len(existing) == len(desired) && len(table.searchColumns) > 0 {
+		upToDate := true
+		for _, object := range existing {
+			if desired[object.Name] != object.SQL {
+				upToDate = false
+			}
+		}
+
+		if upToDate {
+			return nil, nil
+		}
+	}
+
+	statements := []statement{
+		{Query: fmt.Sprintf(`DROP TRIGGER IF EXISTS "%s_ai"`, searchTable)},
+		{Query: fmt.Sprintf(`DROP TRIGGER IF EXISTS "%s_ad"`, searchTable)},
+		{Query: fmt.Sprintf(`DROP TRIGGER IF EXISTS "%s_au"`, searchTable)},
+		{Query: fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, searchTable)},
+	}
+
+	if len(table.searchColumns) == 0 {
+		return statements, nil
+	}
+
+	enabled := []struct {
+		Enabled bool `db:"enabled"`
+	}{}
+	if err := service.runSelect(ctx, statement{
+		Query: `SELECT sqlite_compileoption_used('ENABLE_FTS5') AS enabled;`,
+	}, &enabled); err != nil {
+		return nil, err
+	}
+
+	if len(enabled) == 0 || !enabled[0].Enabled {
+		return nil, fmt.Errorf("%w: SQLite needs to be built with the sqlite_fts5 tag", ErrSearchUnavailable)
+	}
+
+	return append(
+		statements,
+		statement{Query: desired[searchTable]},
+		statement{Query: desired[searchTable+"_ai"]},
+		statement{Query: desired[searchTable+"_ad"]},
+		statement{Query: desired[searchTable+"_au"]},
+		statement{Query: fmt.Sprintf(`INSERT INTO "%s"("%s") VALUES ('rebuild')`, searchTable, searchTable)},
+	), nil
+}
+
 func (driver *driverSQLite) autoMigrateTableCreate(table Table) ([]statement, error) {
 	parts := []string{}
 	for _, column := range table.columns {
@@ -220,6 +328,31 @@ func (driver *driverSQLite) autoMigrateTableDrop(table Table) ([]statement, erro
 	return nil, nil
 }
 
+// autoMigrateTableList skips virtual tables along with their shadow tables
+func (driver *driverSQLite) autoMigrateTableList(ctx context.Context, service *Service) ([]string, error) {
+	tableNames := []tableNameRow{}
+	if err := service.runSelect(ctx, statement{
+		Query: `
+			SELECT name AS table_name
+			FROM sqlite_master AS m
+			WHERE
+				type = 'table'
+				AND name NOT LIKE 'sqlite_%'
+				AND sql NOT LIKE 'CREATE VIRTUAL TABLE%'
+				AND NOT EXISTS (
+					SELECT 1
+					FROM sqlite_master AS v
+					WHERE v.sql LIKE 'CREATE VIRTUAL TABLE%' AND m.name LIKE v.name || '\_%' ESCAPE '\'
+				)
+			ORDER BY name;
+		`,
+	}, &tableNames); err != nil {
+		return nil, err
+	}
+
+	return tableNamesFromRows(tableNames), nil
+}
+
 func (driver *driverSQLite) autoMigrateTableGet(ctx context.Context, service *Service, tableName string) (Table, error) {
 	table := Table{
 		Name: tableName,
@@ -302,15 +435,15 @@ func (driver *driverSQLite) autoMigrateTableGet(ctx context.Context, service *Se
 	if err := service.runSelect(ctx, statement{
 		Query: `
 			SELECT
-				il.name AS index_name,
-				il."unique",
-				ii.name AS 'column_name'
+				name AS index_name,
+				sql AS definition
 			FROM
-				sqlite_master,
-				pragma_index_list (sqlite_master.name) AS il,
-				pragma_index_info (il.name) AS ii
+				sqlite_master
 			WHERE
-				sqlite_master.name = :tableName;
+				type = 'index'
+				AND tbl_name = :tableName
+				AND sql IS NOT NULL
+			ORDER BY name;
 		`,
 		Parameters: map[string]any{
 			":tableName": tableName,
@@ -319,26 +452,10 @@ func (driver *driverSQLite) autoMigrateTableGet(ctx context.Context, service *Se
 		return Table{}, err
 	}
 
-	lookupForDuplicates := map[string]int{}
-
+	// Auto-indexes for the primary key have no definition so they are skipped,
+	// we didn't create them and we don't want to try and remove them
 	for _, index := range indexes {
-		existingIndexIndex, alreadySeenThisKey := lookupForDuplicates[index.Name]
-		if alreadySeenThisKey {
-			table.Indexes[existingIndexIndex].Columns = append(table.Indexes[existingIndexIndex].Columns, index.Column)
-			continue
-		}
-
-		// Skip these auto-indexes for the primary key since we didn't create them and we don't want to try and remove them
-		if strings.HasPrefix(index.Name, "sqlite_autoindex") {
-			continue
-		}
-
-		table.Indexes = append(table.Indexes, TableIndex{
-			Name:    index.Name,
-			Columns: []string{index.Column},
-			Unique:  index.Unique,
-		})
-		lookupForDuplicates[index.Name] = len(table.Indexes) - 1
+		table.Indexes = append(table.Indexes, parseIndexDefinition(index.Name, index.Definition))
 	}
 
 	return table, nil
@@ -396,6 +513,10 @@ func (driver *driverSQLite) convertTypeFloat64() string {
 	return "INTEGER"
 }
 
+func (driver *driverSQLite) convertTypeLongText() string {
+	return "TEXT"
+}
+
 func (driver *driverSQLite) convertTypeString() string {
 	return "TEXT"
 }
@@ -408,7 +529,24 @@ func (driver *driverSQLite) convertTypeJSON() string {
 	return "TEXT"
 }
 
-func (driver *driverSQLite) generateDelete(e Entity) (statement, error) {
+func (driver *driverSQLite) generateColumnComparison(o columnComparison) (statement, error) {
+	left, err := lookupColumn(driver.mapping, o.Left)
+	if err != nil {
+		return statement{}, err
+	}
+
+	right, err := lookupColumn(driver.mapping, o.Right)
+	if err != nil {
+		return statement{}, err
+	}
+
+	return statement{
+		Query:      fmt.Sprintf("%s %s %s", driver.quoteColumn(left), o.Operator, driver.quoteColumn(right)),
+		Parameters: map[string]any{},
+	}, nil
+}
+
+func (driver *driverSQLite) generateDelete(e Entity, scope OperatorOfLogic) (statement, error) {
 	id := int64(0)
 
 	if err := utils.LoopOverStructFields(reflect.ValueOf(e), func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
@@ -431,17 +569,17 @@ func (driver *driverSQLite) generateDelete(e Entity) (statement, error) {
 		return statement{}, err
 	}
 
-	return statement{
+	return appendScope(driver, statement{
 		Query: fmt.Sprintf(
 			"DELETE FROM `%s` WHERE id = %d",
 			e.TableStructure().Name,
 			id,
 		),
 		Parameters: map[string]any{},
-	}, nil
+	}, scope)
 }
 
-func (driver *driverSQLite) generateInsert(e Entity) (statement, error) {
+func (driver *driverSQLite) generateInsert(e Entity, withPrimaryKey bool, withReadOnly bool) (statement, error) {
 	columns := []string{}
 	values := []string{}
 	parameters := map[string]any{}
@@ -452,11 +590,11 @@ func (driver *driverSQLite) generateInsert(e Entity) (statement, error) {
 			return nil
 		}
 
-		if tag.ReadOnly {
+		if tag.ReadOnly && !withReadOnly {
 			return nil
 		}
 
-		if tag.AutoIncrement {
+		if tag.AutoIncrement && !withPrimaryKey {
 			return nil
 		}
 
@@ -493,6 +631,13 @@ func (driver *driverSQLite) generateInsert(e Entity) (statement, error) {
 	}, nil
 }
 
+func (driver *driverSQLite) generateCount(tableName string) (statement, error) {
+	return statement{
+		Query:      fmt.Sprintf("SELECT COUNT(*) AS `count` FROM `%s`", tableName),
+		Parameters: map[string]any{},
+	}, nil
+}
+
 func (driver *driverSQLite) generateSelect(query Query) (statement, error) {
 	selects := []string{}
 	for _, column := range query.Select {
@@ -513,22 +658,231 @@ func (driver *driverSQLite) generateSelect(query Query) (statement, error) {
 		}
 	}
 
+	orderBy, err := generateOrderAndLimit(driver, query)
+	if err != nil {
+		return statement{}, err
+	}
+	queryString += orderBy.Query
+	maps.Copy(parameters, orderBy.Parameters)
+
 	return statement{
 		Query:      queryString,
 		Parameters: parameters,
 	}, nil
 }
 
+// explain reads EXPLAIN QUERY PLAN, where details look like "SCAN user" or
+// "SEARCH user USING INDEX ix_user_company_id (company_id=?)"
+func (driver *driverSQLite) explain(ctx context.Context, service *Service, query string, args []any) ([]QueryPlanStep, error) {
+	rows, err := service.explainRows(ctx, "EXPLAIN QUERY PLAN "+query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := []QueryPlanStep{}
+	for _, row := range rows {
+		step := QueryPlanStep{
+			Detail: row["detail"],
+		}
+
+		fields := strings.Fields(step.Detail)
+		if len(fields) > 1 && (fields[0] == "SCAN" || fields[0] == "SEARCH") {
+			// Older versions say "SCAN TABLE user"
+			tableField := 1
+			if fields[1] == "TABLE" && len(fields) > 2 {
+				tableField = 2
+			}
+
+			step.Table = fields[tableField]
+			step.FullScan = fields[0] == "SCAN"
+
+			if indexField := slices.Index(fields, "INDEX"); indexField >= 0 && indexField+1 < len(fields) {
+				step.Index = fields[indexField+1]
+			}
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+func (driver *driverSQLite) generateJSONPath(o jsonPathOperator) (statement, error) {
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	segments, err := parseJSONPath(o.Path)
+	if err != nil {
+		return statement{}, err
+	}
+
+	path := renderJSONPath(segments)
+	pathKey := jsonParameterKey(column.Column, "path", path)
+	valueKey := jsonParameterKey(column.Column, "value", path, o.Operator, o.Value)
+
+	return statement{
+		Query: fmt.Sprintf("json_extract(%s, %s) %s %s", driver.quoteColumn(column), pathKey, o.Operator, valueKey),
+		Parameters: map[string]any{
+			pathKey:  path,
+			valueKey: o.Value,
+		},
+	}, nil
+}
+
+// generateJSONContains breaks the value down into a condition per scalar since
+// SQLite has no containment operator, arrays can only hold scalars
+func (driver *driverSQLite) generateJSONContains(o jsonContainsOperator) (statement, error) {
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	value, err := jsonNormalize(o.Value)
+	if err != nil {
+		return statement{}, err
+	}
+
+	conditions := []string{}
+	parameters := map[string]any{}
+
+	var walk func(segments []jsonPathSegment, value any) error
+	walk = func(segments []jsonPathSegment, value any) error {
+		path := renderJSONPath(segments)
+		pathKey := jsonParameterKey(column.Column, "path", path)
+		parameters[pathKey] = path
+
+		switch typed := value.(type) {
+		case map[string]any:
+			if len(typed) == 0 {
+				conditions = append(conditions, fmt.Sprintf("json_type(%s, %s) = 'object'", driver.quoteColumn(column), pathKey))
+			}
+
+			keys := slices.Sorted(maps.Keys(typed))
+			for _, key := range keys {
+				if err := walk(append(slices.Clone(segments), jsonPathSegment{Key: key, IsKey: true}), typed[key]); err != nil {
+					return err
+				}
+			}
+		case []any:
+			conditions = append(conditions, fmt.Sprintf("json_type(%s, %s) = 'array'", driver.quoteColumn(column), pathKey))
+			for _, element := range typed {
+				switch element.(type) {
+				case map[string]any, []any:
+					return fmt.Errorf("%w: SQLite can only check arrays for scalars", ErrUnsupportedJSON)
+				}
+
+				valueKey := jsonParameterKey(column.Column, "element", path, element)
+				parameters[valueKey] = element
+				conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s, %s) WHERE value = %s)", driver.quoteColumn(column), pathKey, valueKey))
+			}
+		case nil:
+			conditions = append(conditions, fmt.Sprintf("json_type(%s, %s) = 'null'", driver.quoteColumn(column), pathKey))
+		default:
+			valueKey := jsonParameterKey(column.Column, "value", path, typed)
+			parameters[valueKey] = typed
+			conditions = append(conditions, fmt.Sprintf("json_extract(%s, %s) = %s", driver.quoteColumn(column), pathKey, valueKey))
+		}
+
+		return nil
+	}
+
+	if err := walk(nil, value); err != nil {
+		return statement{}, err
+	}
+
+	return statement{
+		Query:      fmt.Sprintf("(%s)", strings.Join(conditions, " AND ")),
+		Parameters: parameters,
+	}, nil
+}
+
+func (driver *driverSQLite) generateSearchMatch(o searchMatch) (statement, error) {
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	// An empty FTS5 query is a syntax error rather than matching nothing
+	if len(searchTerms(o.Query)) == 0 {
+		return statement{Query: "1 = 0", Parameters: map[string]any{}}, nil
+	}
+
+	key := o.parameterKey(column)
+
+	return statement{
+		Query: fmt.Sprintf(
+			`"%s".rowid IN (SELECT rowid FROM "%s" WHERE "%s" MATCH %s)`,
+			column.Table,
+			sqliteSearchTable(column.Table),
+			column.Column,
+			key,
+		),
+		Parameters: map[string]any{
+			key: sqliteSearchQuery(o.Query),
+		},
+	}, nil
+}
+
+func (driver *driverSQLite) generateSearchRelevance(o searchMatch) (statement, error) {
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	if len(searchTerms(o.Query)) == 0 {
+		return statement{Query: "1", Parameters: map[string]any{}}, nil
+	}
+
+	key := o.parameterKey(column)
+
+	// bm25 ranks are negative with the best match lowest, rows that don't
+	// match get 0 so they sort last
+	return statement{
+		Query: fmt.Sprintf(
+			`COALESCE((SELECT rank FROM "%s" WHERE "%s" MATCH %s AND rowid = "%s".rowid), 0)`,
+			sqliteSearchTable(column.Table),
+			column.Column,
+			key,
+			column.Table,
+		),
+		Parameters: map[string]any{
+			key: sqliteSearchQuery(o.Query),
+		},
+	}, nil
+}
+
+func sqliteSearchTable(tableName string) string {
+	return tableName + "_fts"
+}
+
+// sqliteSearchQuery quotes every word of the query so FTS5 syntax can't be
+// injected, the words are implicitly joined with AND
+func sqliteSearchQuery(query string) string {
+	terms := []string{}
+	for _, term := range searchTerms(query) {
+		terms = append(terms, fmt.Sprintf(`"%s"`, strings.ReplaceAll(term, `"`, `""`)))
+	}
+
+	return strings.Join(terms, " ")
+}
+
+func (driver *driverSQLite) generateSequenceReset(table Table) ([]statement, error) {
+	// Explicit inserts already move the auto increment counter forward
+	return nil, nil
+}
+
 func (driver *driverSQLite) generateSimpleOperatorOfEquality(o simpleOperatorOfEquality) (statement, error) {
-	columnName := driver.mapping[uintptr(reflect.ValueOf(o.Column).UnsafePointer())]
-	if columnName == "" {
+	column := driver.mapping[uintptr(reflect.ValueOf(o.Column).UnsafePointer())]
+	if column.Column == "" {
 		return statement{}, errors.New("unknown column")
 	}
 
-	key := fmt.Sprintf(":%s", columnName)
+	key := operatorParameterKey(column, o.Operator, o.Value)
 
 	return statement{
-		Query: fmt.Sprintf("`%s` %s %s", columnName, o.Operator, key),
+		Query: fmt.Sprintf("%s %s %s", driver.quoteColumn(column), o.Operator, key),
 		Parameters: map[string]any{
 			key: o.Value,
 		},
@@ -539,7 +893,20 @@ func (driver *driverSQLite) generateSimpleOperatorOfLogic(o simpleOperatorOfLogi
 	return generateSimpleOperatorOfLogic(driver, o)
 }
 
-func (driver *driverSQLite) generateUpdate(e Entity) (statement, error) {
+func (driver *driverSQLite) generateSubquery(o subqueryOperator) (statement, error) {
+	if o.Column == nil {
+		return renderSubquery(driver, driver.mapping, o, "")
+	}
+
+	column, err := lookupColumn(driver.mapping, o.Column)
+	if err != nil {
+		return statement{}, err
+	}
+
+	return renderSubquery(driver, driver.mapping, o, driver.quoteColumn(column))
+}
+
+func (driver *driverSQLite) generateUpdate(e Entity, scope OperatorOfLogic) (statement, error) {
 	sets := []string{}
 	id := int64(0)
 	parameters := map[string]any{}
@@ -578,7 +945,7 @@ func (driver *driverSQLite) generateUpdate(e Entity) (statement, error) {
 		return statement{}, err
 	}
 
-	return statement{
+	return appendScope(driver, statement{
 		Query: fmt.Sprintf(
 			"UPDATE `%s` SET %s WHERE id = %d",
 			e.TableStructure().Name,
@@ -586,7 +953,7 @@ func (driver *driverSQLite) generateUpdate(e Entity) (statement, error) {
 			id,
 		),
 		Parameters: parameters,
-	}, nil
+	}, scope)
 }
 
 func (driver *driverSQLite) usesLastInsertId() bool {
@@ -597,6 +964,11 @@ func (driver *driverSQLite) usesNumberedParameters() bool {
 	return false
 }
 
+type sqliteSchemaObject struct {
+	Name string `db:"name"`
+	SQL  string `db:"sql"`
+}
+
 type sqliteTableStruct struct {
 	SQL string `db:"sql"`
 }
@@ -610,9 +982,8 @@ type sqliteTableInfo struct {
 }
 
 type sqliteIndex struct {
-	Name   string `db:"index_name"`
-	Unique bool   `db:"unique"`
-	Column string `db:"column_name"`
+	Name       string `db:"index_name"`
+	Definition string `db:"definition"`
 }
 
 type sqliteForeignKey struct {
@@ -660,3 +1031,90 @@ func (driver *driverSQLite) renderColumn(column TableColumn) string {
 
 	return fmt.Sprintf(`"%s" %s%s%s%s%s`, column.Name, columnType, primaryKey, defaultValue, nullable, foreignKey)
 }
+
+func (driver *driverSQLite) lockAcquire(ctx context.Context, service *Service, name string, ttl time.Duration) (heldLock, error) {
+	if err := driver.lockTableMigrate(ctx, service); err != nil {
+		return nil, err
+	}
+
+	lock, err := driver.lockInsert(ctx, service, name, ttl)
+	if err == nil || !strings.Contains(err.Error(), "no such table") {
+		return lock, err
+	}
+
+	// The table was dropped since it was created, for example by restoring a
+	// backup, so it is created again
+	driver.lockTableMutex.Lock()
+	driver.lockTableMigrated = false
+	driver.lockTableMutex.Unlock()
+
+	if err := driver.lockTableMigrate(ctx, service); err != nil {
+		return nil, err
+	}
+
+	return driver.lockInsert(ctx, service, name, ttl)
+}
+
+func (driver *driverSQLite) lockInsert(ctx context.Context, service *Service, name string, ttl time.Duration) (heldLock, error) {
+	if _, err := service.runExecute(ctx, statement{
+		Query: "DELETE FROM `athena_lock` WHERE `name` = :name AND `expires_at` <= :now",
+		Parameters: map[string]any{
+			":name": name,
+			":now":  time.Now().UnixNano(),
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	lock := &tableLock{
+		service: service,
+		name:    name,
+		token:   uuid.NewString(),
+	}
+
+	result, err := service.runExecute(ctx, statement{
+		Query: "INSERT OR IGNORE INTO `athena_lock` (`name`, `token`, `expires_at`) VALUES (:name, :token, :expires_at)",
+		Parameters: map[string]any{
+			":name":       lock.name,
+			":token":      lock.token,
+			":expires_at": time.Now().Add(ttl).UnixNano(),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tableLockAffected(result); err != nil {
+		if errors.Is(err, ErrLockLost) {
+			return nil, ErrLockNotAcquired
+		}
+
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// lockTableMigrate creates the lock table the first time a lock is taken
+func (driver *driverSQLite) lockTableMigrate(ctx context.Context, service *Service) error {
+	driver.lockTableMutex.Lock()
+	defer driver.lockTableMutex.Unlock()
+
+	if driver.lockTableMigrated {
+		return nil
+	}
+
+	if _, err := service.AutoMigrate(ctx, []Entity{lockRecord{}}); err != nil {
+		return err
+	}
+
+	driver.lockTableMigrated = true
+
+	return nil
+}
+
+// quoteColumn qualifies the column with its table so it can be told apart
+// from columns of the other tables of a subquery
+func (driver *driverSQLite) quoteColumn(column mappedColumn) string {
+	return fmt.Sprintf("`%s`.`%s`", column.Table, column.Column)
+}
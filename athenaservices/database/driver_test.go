@@ -279,4 +279,20 @@ func testSuite(t *testing.T, driver database.Driver, configFuncs ...database.Ser
 		))
 		assert.ErrorIs(t, errShouldBeNoRows, database.ErrNoRows)
 	}
+
+	{ // Assert that locks are exclusive until unlocked
+		lockName := uuid.NewString()
+		lock, err := service.Lock(t.Context(), lockName, time.Minute)
+		assert.NilError(t, err)
+
+		_, err = service.Lock(t.Context(), lockName, time.Minute)
+		assert.ErrorIs(t, err, database.ErrLockNotAcquired)
+
+		assert.NilError(t, lock.Refresh(t.Context()))
+		assert.NilError(t, lock.Unlock(t.Context()))
+
+		lock, err = service.Lock(t.Context(), lockName, time.Minute)
+		assert.NilError(t, err)
+		assert.NilError(t, lock.Unlock(t.Context()))
+	}
 }
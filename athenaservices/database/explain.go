@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	"github.com/lunagic/athena/athenaservices/database/internal/utils"
+)
+
+// QueryPlan is how the database intends to run a select
+type QueryPlan struct {
+	Statement string
+	Arguments []any
+	Steps     []QueryPlanStep
+}
+
+type QueryPlanStep struct {
+	Table    string
+	Index    string
+	FullScan bool
+	// Detail is the step as the database described it
+	Detail string
+}
+
+// FullTableScan reports whether any step reads every row of the table
+func (plan QueryPlan) FullTableScan(tableName string) bool {
+	return slices.ContainsFunc(plan.Steps, func(step QueryPlanStep) bool {
+		return step.FullScan && step.Table == tableName
+	})
+}
+
+// Explain renders the select and asks the database for its plan without
+// running it
+func (selector *Selector[T]) Explain(ctx context.Context, mods ...QueryModifier) (QueryPlan, error) {
+	query := selector.baseQuery
+	for _, mod := range mods {
+		query = mod(query)
+	}
+
+	statement, err := selector.service.driver.generateSelect(query)
+	if err != nil {
+		return QueryPlan{}, err
+	}
+
+	preparedQuery, preparedArgs, err := utils.Prepare(statement.Query, statement.Parameters, selector.service.driver.usesNumberedParameters())
+	if err != nil {
+		return QueryPlan{}, err
+	}
+
+	steps, err := selector.service.driver.explain(ctx, selector.service, preparedQuery, preparedArgs)
+	if err != nil {
+		return QueryPlan{}, err
+	}
+
+	return QueryPlan{
+		Statement: preparedQuery,
+		Arguments: preparedArgs,
+		Steps:     steps,
+	}, nil
+}
+
+// Explain is like Selector.Explain with the base modifiers and tenant scope of
+// the repository applied
+func (repository *Repository[ID, T]) Explain(ctx context.Context, mods ...QueryModifier) (QueryPlan, error) {
+	mods, err := repository.prependBaseModifiers(ctx, mods)
+	if err != nil {
+		return QueryPlan{}, err
+	}
+
+	return repository.selector.Explain(ctx, mods...)
+}
+
+// explainRows runs the explain statement through the pre and post run funcs
+// like any other statement and returns its rows keyed by column name since
+// the columns differ between databases and their versions
+func (service *Service) explainRows(ctx context.Context, query string, args []any) ([]map[string]string, error) {
+	for _, preRunFunc := range service.preRunFuncs {
+		if err := preRunFunc(ctx, query, args); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := service.executor(ctx).Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []map[string]string{}
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		scanFields := []any{}
+		for i := range values {
+			scanFields = append(scanFields, &values[i])
+		}
+
+		if err := rows.Scan(scanFields...); err != nil {
+			return nil, err
+		}
+
+		result := map[string]string{}
+		for i, column := range columns {
+			result[column] = values[i].String
+		}
+
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, postRunFunc := range service.postRunFuncs {
+		if err := postRunFunc(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,74 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"github.com/lunagic/athena/athenatest"
+	"gotest.tools/v3/assert"
+)
+
+func TestExplain(t *testing.T) {
+	service := newSQLiteTestService(t, []database.Entity{Company{}, UserV2{}})
+	userRepo := database.NewRepository[UserID, UserV2](service)
+
+	{ // Lookups by an indexed column use the index
+		plan, err := userRepo.Explain(t.Context(), database.WithAdditionalWhere(
+			database.And(database.Equal(&userRepo.T.CompanyID, 7)),
+		))
+		assert.NilError(t, err)
+		assert.Assert(t, len(plan.Steps) > 0)
+		assert.DeepEqual(t, plan.Arguments, []any{CompanyID(7)})
+		assert.Equal(t, plan.Steps[0].Table, "user")
+		assert.Equal(t, plan.Steps[0].FullScan, false)
+		assert.Assert(t, plan.Steps[0].Index != "")
+		athenatest.AssertNoFullTableScan(t, plan, UserV2{})
+	}
+
+	{ // Lookups by a column without an index read the whole table
+		plan, err := userRepo.Explain(t.Context(), database.WithAdditionalWhere(
+			database.And(database.Equal(&userRepo.T.NewForV2, "value")),
+		))
+		assert.NilError(t, err)
+		assert.Assert(t, plan.FullTableScan("user"))
+		assert.Assert(t, !plan.FullTableScan("company"))
+	}
+}
+
+func TestExplainPreRunFuncs(t *testing.T) {
+	statements := []string{}
+	readOnly := false
+	service, err := database.New(
+		database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())),
+		database.WithPreRunFunc(func(ctx context.Context, statement string, args []any) error {
+			if readOnly {
+				return errors.New("database is read only")
+			}
+
+			statements = append(statements, statement)
+			return nil
+		}),
+	)
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{Company{}, UserV2{}})
+	assert.NilError(t, err)
+
+	userRepo := database.NewRepository[UserID, UserV2](service)
+
+	{ // The statement that runs is passed on
+		statements = []string{}
+		plan, err := userRepo.Explain(t.Context())
+		assert.NilError(t, err)
+		assert.DeepEqual(t, statements, []string{"EXPLAIN QUERY PLAN " + plan.Statement})
+	}
+
+	{ // Statements the funcs reject are not explained either
+		readOnly = true
+		_, err := userRepo.Explain(t.Context())
+		assert.ErrorContains(t, err, "database is read only")
+	}
+}
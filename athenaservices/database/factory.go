@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/lunagic/athena/athenaservices/database/internal/utils"
+)
+
+// NewFactory creates a factory that builds entities from the definition. The
+// definition receives a sequence number (starting at 1) that is unique per
+// factory so generated values like email addresses don't collide.
+func NewFactory[T Entity](definition func(sequence int) T) *Factory[T] {
+	return &Factory[T]{
+		mutex:      &sync.Mutex{},
+		definition: definition,
+	}
+}
+
+type Factory[T Entity] struct {
+	mutex      *sync.Mutex
+	sequence   int
+	definition func(sequence int) T
+}
+
+func (factory *Factory[T]) Build(overrides ...func(entity *T)) T {
+	factory.mutex.Lock()
+	factory.sequence++
+	sequence := factory.sequence
+	factory.mutex.Unlock()
+
+	entity := factory.definition(sequence)
+	for _, override := range overrides {
+		override(&entity)
+	}
+
+	return entity
+}
+
+func (factory *Factory[T]) BuildMany(count int, overrides ...func(entity *T)) []T {
+	entities := []T{}
+	for range count {
+		entities = append(entities, factory.Build(overrides...))
+	}
+
+	return entities
+}
+
+// Create builds an entity and inserts it, the returned entity has its primary key set
+func (factory *Factory[T]) Create(ctx context.Context, service *Service, overrides ...func(entity *T)) (T, error) {
+	entity := factory.Build(overrides...)
+
+	id, err := service.insert(ctx, entity, false, false)
+	if err != nil {
+		return *new(T), err
+	}
+
+	if err := utils.LoopOverStructFields(reflect.ValueOf(&entity), func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
+		tag := utils.ParseTag(fieldDefinition.Tag)
+		if !tag.PrimaryKey {
+			return nil
+		}
+
+		// Keys of other types come from the definition rather than the database
+		switch {
+		case fieldValue.CanInt():
+			fieldValue.SetInt(id)
+		case fieldValue.CanUint():
+			fieldValue.SetUint(uint64(id))
+		}
+
+		return nil
+	}); err != nil {
+		return *new(T), err
+	}
+
+	return entity, nil
+}
+
+func (factory *Factory[T]) CreateMany(ctx context.Context, service *Service, count int, overrides ...func(entity *T)) ([]T, error) {
+	entities := []T{}
+	for range count {
+		entity, err := factory.Create(ctx, service, overrides...)
+		if err != nil {
+			return nil, err
+		}
+
+		entities = append(entities, entity)
+	}
+
+	return entities, nil
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+
+	"github.com/lunagic/athena/athenaservices/database/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFixtures inserts the rows found in the fixture files into the tables of
+// the entities. Fixture files (.yaml, .yml or .json) map table names to a list
+// of rows keyed by column name:
+//
+//	company:
+//	  - id: 1
+//	    name: Acme
+//	user:
+//	  - email_address: someone@example.com
+//	    company_id: 1
+//
+// Tables are filled in foreign key order regardless of the order they appear
+// in the files. Rows that set the primary key keep it, even for auto
+// incrementing columns, and the sequences are moved past them afterwards.
+func (service *Service) LoadFixtures(ctx context.Context, entities []Entity, fsys fs.FS, paths ...string) error {
+	rowsByTable := map[string][]map[string]any{}
+	for _, filePath := range paths {
+		fileBytes, err := fs.ReadFile(fsys, filePath)
+		if err != nil {
+			return err
+		}
+
+		fileRows := map[string][]map[string]any{}
+		switch path.Ext(filePath) {
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(fileBytes, &fileRows)
+		case ".json":
+			err = json.Unmarshal(fileBytes, &fileRows)
+		default:
+			err = fmt.Errorf("unsupported fixture file type: %s", filePath)
+		}
+		if err != nil {
+			return err
+		}
+
+		for tableName, rows := range fileRows {
+			rowsByTable[tableName] = append(rowsByTable[tableName], rows...)
+		}
+	}
+
+	schema, err := resolveSchema(service.driver, entities)
+	if err != nil {
+		return err
+	}
+
+	for tableName := range rowsByTable {
+		found := false
+		for _, entry := range schema {
+			if entry.table.Name == tableName {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("%w: %s", ErrTableNotFound, tableName)
+		}
+	}
+
+	for _, entry := range schema {
+		rows := rowsByTable[entry.table.Name]
+		if len(rows) == 0 {
+			continue
+		}
+
+		for _, row := range rows {
+			entity, withPrimaryKey, err := entityFromRow(entry.entity, row)
+			if err != nil {
+				return fmt.Errorf("fixture for %s: %w", entry.table.Name, err)
+			}
+
+			if _, err := service.insert(ctx, entity, withPrimaryKey, false); err != nil {
+				return fmt.Errorf("fixture for %s: %w", entry.table.Name, err)
+			}
+		}
+
+		statements, err := service.driver.generateSequenceReset(entry.table)
+		if err != nil {
+			return err
+		}
+
+		for _, statement := range statements {
+			if _, err := service.runExecute(ctx, statement); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// entityFromRow builds a new entity of the same type as the example with the
+// fields set from the column keyed row
+func entityFromRow(example Entity, row map[string]any) (Entity, bool, error) {
+	value := reflect.New(reflect.TypeOf(example)).Elem()
+	withPrimaryKey := false
+	remaining := len(row)
+
+	if err := utils.LoopOverStructFields(value, func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
+		tag := utils.ParseTag(fieldDefinition.Tag)
+		if tag.Column == "" {
+			return nil
+		}
+
+		columnValue, found := row[tag.Column]
+		if !found {
+			return nil
+		}
+		remaining--
+
+		if tag.PrimaryKey {
+			withPrimaryKey = true
+		}
+
+		// Round trip through JSON so nested structs, slices and times convert to the field type
+		jsonBytes, err := json.Marshal(columnValue)
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(jsonBytes, fieldValue.Addr().Interface()); err != nil {
+			return fmt.Errorf("column %s: %w", tag.Column, err)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, false, err
+	}
+
+	if remaining > 0 {
+		for column := range row {
+			if !hasColumn(value.Type(), column) {
+				return nil, false, fmt.Errorf("column %s not found in target", column)
+			}
+		}
+	}
+
+	return value.Interface().(Entity), withPrimaryKey, nil
+}
+
+func hasColumn(entityType reflect.Type, column string) bool {
+	for i := range entityType.NumField() {
+		if utils.ParseTag(entityType.Field(i).Tag).Column == column {
+			return true
+		}
+	}
+
+	return false
+}
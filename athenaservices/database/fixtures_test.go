@@ -0,0 +1,162 @@
+package database_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func newSQLiteTestService(t *testing.T, entities []database.Entity) *database.Service {
+	service, err := database.New(database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())))
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), entities)
+	assert.NilError(t, err)
+
+	return service
+}
+
+func TestFactory(t *testing.T) {
+	service := newSQLiteTestService(t, []database.Entity{Company{}, UserV2{}})
+
+	companyFactory := database.NewFactory(func(sequence int) Company {
+		return Company{
+			String:   fmt.Sprintf("company-%d", sequence),
+			TimeTime: time.Now(),
+		}
+	})
+
+	{ // Build does not touch the database and increments the sequence
+		companies := companyFactory.BuildMany(2)
+		assert.Equal(t, companies[0].String, "company-1")
+		assert.Equal(t, companies[1].String, "company-2")
+	}
+
+	{ // Create inserts and sets the primary key, with overrides applied last
+		company, err := companyFactory.Create(t.Context(), service, func(company *Company) {
+			company.Bool = true
+		})
+		assert.NilError(t, err)
+		assert.Equal(t, company.ID, CompanyID(1))
+		assert.Equal(t, company.String, "company-3")
+		assert.Equal(t, company.Bool, true)
+
+		companyRepo := database.NewRepository[CompanyID, Company](service)
+		fromDatabase, err := companyRepo.SelectSingle(t.Context(), database.WithAdditionalWhere(
+			database.And(database.Equal(&companyRepo.T.ID, company.ID)),
+		))
+		assert.NilError(t, err)
+		assert.Equal(t, fromDatabase.String, "company-3")
+	}
+}
+
+type namedEntity struct {
+	Name  string `db:"name,primaryKey"`
+	Value string `db:"value"`
+}
+
+func (e namedEntity) TableStructure() database.Table {
+	return database.Table{Name: "named"}
+}
+
+func TestFactoryPrimaryKeyTypes(t *testing.T) {
+	service := newSQLiteTestService(t, []database.Entity{unsignedEntity{}, namedEntity{}})
+
+	{ // Unsigned keys are set from the database
+		entity, err := database.NewFactory(func(sequence int) unsignedEntity {
+			return unsignedEntity{Name: fmt.Sprintf("entity-%d", sequence)}
+		}).Create(t.Context(), service)
+		assert.NilError(t, err)
+		assert.Equal(t, entity.ID, uint64(1))
+	}
+
+	{ // Keys of other types keep the value of the definition
+		entity, err := database.NewFactory(func(sequence int) namedEntity {
+			return namedEntity{Name: fmt.Sprintf("name-%d", sequence), Value: "value"}
+		}).Create(t.Context(), service)
+		assert.NilError(t, err)
+		assert.Equal(t, entity.Name, "name-1")
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	entities := []database.Entity{Company{}, UserV2{}}
+	service := newSQLiteTestService(t, entities)
+
+	fixtures := fstest.MapFS{
+		// The users are listed before the companies they depend on
+		"users.yaml": &fstest.MapFile{Data: []byte(`
+user:
+  - id: 10
+    email_address: first@example.com
+    company_id: 5
+    settings:
+      FavoriteColor: red
+  - email_address: second@example.com
+    company_id: 5
+`)},
+		"companies.json": &fstest.MapFile{Data: []byte(`{
+	"company": [
+		{"id": 5, "name": "Acme", "timeTime": "2024-01-02T03:04:05Z", "slice": ["a", "b"]}
+	]
+}`)},
+	}
+
+	assert.NilError(t, service.LoadFixtures(t.Context(), entities, fixtures, "users.yaml", "companies.json"))
+
+	userRepo := database.NewRepository[UserID, UserV2](service)
+	users, err := userRepo.SelectMultiple(t.Context())
+	assert.NilError(t, err)
+	assert.Equal(t, len(users), 2)
+	assert.Equal(t, users[0].ID, UserID(10))
+	assert.Equal(t, users[0].Settings.FavoriteColor, "red")
+	assert.Equal(t, users[1].ID, UserID(11))
+
+	companyRepo := database.NewRepository[CompanyID, Company](service)
+	company, err := companyRepo.SelectSingle(t.Context())
+	assert.NilError(t, err)
+	assert.Equal(t, company.ID, CompanyID(5))
+	assert.DeepEqual(t, company.Slice, []string{"a", "b"})
+
+	{ // Unknown tables and columns are rejected
+		err := service.LoadFixtures(t.Context(), entities, fstest.MapFS{
+			"bad.yaml": &fstest.MapFile{Data: []byte("unknown_table: [{id: 1}]")},
+		}, "bad.yaml")
+		assert.ErrorIs(t, err, database.ErrTableNotFound)
+
+		err = service.LoadFixtures(t.Context(), entities, fstest.MapFS{
+			"bad.yaml": &fstest.MapFile{Data: []byte("company: [{unknown_column: 1}]")},
+		}, "bad.yaml")
+		assert.ErrorContains(t, err, "unknown_column")
+	}
+}
+
+func TestSeederRegistry(t *testing.T) {
+	service := newSQLiteTestService(t, []database.Entity{Company{}})
+	companyRepo := database.NewRepository[CompanyID, Company](service)
+
+	registry := database.NewSeederRegistry()
+	assert.NilError(t, registry.Register("companies", func(ctx context.Context, service *database.Service) error {
+		_, err := companyRepo.Insert(ctx, Company{String: "seeded"})
+		return err
+	}))
+	assert.ErrorContains(t, registry.Register("companies", nil), "duplicate seeder")
+
+	seedersRan, err := registry.Run(t.Context(), service)
+	assert.NilError(t, err)
+	assert.Equal(t, seedersRan, 1)
+
+	// Running again does not seed twice
+	seedersRan, err = registry.Run(t.Context(), service)
+	assert.NilError(t, err)
+	assert.Equal(t, seedersRan, 0)
+
+	companies, err := companyRepo.SelectMultiple(t.Context())
+	assert.NilError(t, err)
+	assert.Equal(t, len(companies), 1)
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// renderIndexKeyParts renders the columns followed by the expressions
+func renderIndexKeyParts(index TableIndex, quote func(column string) string) string {
+	parts := []string{}
+	for _, column := range index.Columns {
+		part := quote(column)
+		if slices.Contains(index.Descending, column) {
+			part += " DESC"
+		}
+
+		parts = append(parts, part)
+	}
+
+	parts = append(parts, index.Expressions...)
+
+	return strings.Join(parts, ", ")
+}
+
+var (
+	indexMethodRegex     = regexp.MustCompile(`(?i)\sUSING\s+(\w+)\s*\(`)
+	indexIdentifierRegex = regexp.MustCompile("^[\"`]?[A-Za-z_][A-Za-z0-9_]*[\"`]?$")
+	indexDirectionRegex  = regexp.MustCompile(`(?i)\s+(ASC|DESC)(\s+NULLS\s+(FIRST|LAST))?$`)
+)
+
+// parseIndexDefinition reads an index back from its CREATE INDEX statement,
+// as stored by SQLite or returned by pg_get_indexdef
+func parseIndexDefinition(name string, definition string) TableIndex {
+	index := TableIndex{
+		Name:   name,
+		Unique: strings.HasPrefix(strings.ToUpper(strings.TrimSpace(definition)), "CREATE UNIQUE"),
+	}
+
+	onPosition := strings.Index(strings.ToUpper(definition), " ON ")
+	if onPosition < 0 {
+		return index
+	}
+	definition = definition[onPosition:]
+
+	if match := indexMethodRegex.FindStringSubmatch(definition); match != nil {
+		index.Method = strings.ToLower(match[1])
+	}
+
+	start := strings.Index(definition, "(")
+	if start < 0 {
+		return index
+	}
+
+	end := matchingParenthesis(definition, start)
+	if end < 0 {
+		return index
+	}
+
+	for _, part := range splitTopLevel(definition[start+1 : end]) {
+		part = strings.TrimSpace(part)
+		direction := indexDirectionRegex.FindStringSubmatch(part)
+		expression := indexDirectionRegex.ReplaceAllString(part, "")
+
+		if !indexIdentifierRegex.MatchString(expression) {
+			index.Expressions = append(index.Expressions, part)
+			continue
+		}
+
+		column := strings.Trim(expression, "\"`")
+		index.Columns = append(index.Columns, column)
+		if direction != nil && strings.EqualFold(direction[1], "DESC") {
+			index.Descending = append(index.Descending, column)
+		}
+	}
+
+	rest := strings.TrimSpace(definition[end+1:])
+	if strings.HasPrefix(strings.ToUpper(rest), "WHERE ") {
+		index.Where = unwrapParentheses(strings.TrimSpace(rest[len("WHERE "):]))
+	}
+
+	return index
+}
+
+func matchingParenthesis(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func splitTopLevel(s string) []string {
+	parts := []string{}
+	depth := 0
+	last := 0
+	for i := range len(s) {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[last:])
+}
+
+func unwrapParentheses(s string) string {
+	for strings.HasPrefix(s, "(") && matchingParenthesis(s, 0) == len(s)-1 {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+
+	return s
+}
+
+var indexCastRegex = regexp.MustCompile(`::[a-z_]+( [a-z_]+)*(\[\])?`)
+
+// normalizeIndexExpression strips what databases add when they store an
+// expression (casts, parentheses, quoting and spacing) so expressions can be
+// compared with what was asked for
+func normalizeIndexExpression(expression string) string {
+	expression = strings.ToLower(expression)
+	expression = indexCastRegex.ReplaceAllString(expression, "")
+
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '(', ')', '"', '`', ' ', '\t', '\n':
+			return -1
+		}
+
+		return r
+	}, expression)
+}
+
+// indexesEqual compares the key parts in order as an index on (a, b) can't
+// serve the queries of one on (b, a)
+func indexesEqual(a TableIndex, b TableIndex) bool {
+	normalizeList := func(list []string, normalize func(string) string) []string {
+		normalized := []string{}
+		for _, value := range list {
+			normalized = append(normalized, normalize(value))
+		}
+
+		return normalized
+	}
+
+	sorted := func(list []string) []string {
+		return slices.Sorted(slices.Values(list))
+	}
+
+	normalizeMethod := func(method string) string {
+		if method == "" {
+			return "btree"
+		}
+
+		return strings.ToLower(method)
+	}
+
+	return a.Name == b.Name &&
+		a.Unique == b.Unique &&
+		slices.Equal(a.Columns, b.Columns) &&
+		slices.Equal(sorted(a.Descending), sorted(b.Descending)) &&
+		slices.Equal(normalizeList(a.Expressions, normalizeIndexExpression), normalizeList(b.Expressions, normalizeIndexExpression)) &&
+		normalizeIndexExpression(a.Where) == normalizeIndexExpression(b.Where) &&
+		normalizeMethod(a.Method) == normalizeMethod(b.Method)
+}
+
+func unsupportedIndex(table Table, index TableIndex, feature string) error {
+	return fmt.Errorf("%w: %s on index %s of table %s", ErrUnsupportedIndex, feature, index.Name, table.Name)
+}
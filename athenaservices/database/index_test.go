@@ -0,0 +1,127 @@
+package database_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+type Account struct {
+	ID        int64   `db:"id,primaryKey,autoIncrement"`
+	Email     string  `db:"email"`
+	Score     int64   `db:"score"`
+	DeletedAt *string `db:"deleted_at"`
+}
+
+func (e Account) TableStructure() database.Table {
+	return database.Table{
+		Name: "account",
+		Indexes: []database.TableIndex{
+			{
+				Name:        "ux_account_email_active",
+				Expressions: []string{"lower(email)"},
+				Unique:      true,
+				Where:       "deleted_at IS NULL",
+			},
+			{
+				Name:       "ix_account_score",
+				Columns:    []string{"score", "id"},
+				Descending: []string{"score"},
+			},
+		},
+	}
+}
+
+type accountWithMethod Account
+
+func (e accountWithMethod) TableStructure() database.Table {
+	table := Account(e).TableStructure()
+	table.Indexes = []database.TableIndex{
+		{
+			Name:    "ix_account_email",
+			Columns: []string{"email"},
+			Method:  "gin",
+		},
+	}
+
+	return table
+}
+
+type accountWithReorderedIndex Account
+
+func (e accountWithReorderedIndex) TableStructure() database.Table {
+	table := Account(e).TableStructure()
+	table.Indexes[1].Columns = []string{"id", "score"}
+
+	return table
+}
+
+func TestAdvancedIndexes(t *testing.T) {
+	service, err := database.New(database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())))
+	assert.NilError(t, err)
+
+	changes, err := service.AutoMigrate(t.Context(), []database.Entity{Account{}})
+	assert.NilError(t, err)
+	assert.Assert(t, changes > 0)
+
+	{ // Introspected indexes match so nothing is re-applied
+		changes, err := service.AutoMigrate(t.Context(), []database.Entity{Account{}})
+		assert.NilError(t, err)
+		assert.Equal(t, changes, 0)
+	}
+
+	{ // Reordering the columns rebuilds the index
+		changes, err := service.AutoMigrate(t.Context(), []database.Entity{accountWithReorderedIndex{}})
+		assert.NilError(t, err)
+		assert.Assert(t, changes > 0)
+
+		changes, err = service.AutoMigrate(t.Context(), []database.Entity{Account{}})
+		assert.NilError(t, err)
+		assert.Assert(t, changes > 0)
+	}
+
+	{ // The partial expression index is enforced
+		repo := database.NewRepository[int64, Account](service)
+		_, err := repo.Insert(t.Context(), Account{Email: "someone@example.com"})
+		assert.NilError(t, err)
+		_, err = repo.Insert(t.Context(), Account{Email: "SOMEONE@example.com"})
+		assert.ErrorContains(t, err, "UNIQUE")
+
+		deleted := "yesterday"
+		_, err = repo.Insert(t.Context(), Account{Email: "SOMEONE@example.com", DeletedAt: &deleted})
+		assert.NilError(t, err)
+	}
+
+	tables, err := service.Inspect(t.Context())
+	assert.NilError(t, err)
+	assert.DeepEqual(t, tables[0].Indexes, []database.TableIndex{
+		{
+			Name:       "ix_account_score",
+			Columns:    []string{"score", "id"},
+			Descending: []string{"score"},
+		},
+		{
+			Name:        "ux_account_email_active",
+			Expressions: []string{"lower(email)"},
+			Unique:      true,
+			Where:       "deleted_at IS NULL",
+		},
+	})
+
+	{ // Unsupported features are rejected
+		_, err := service.AutoMigrate(t.Context(), []database.Entity{accountWithMethod{}})
+		assert.ErrorIs(t, err, database.ErrUnsupportedIndex)
+
+		_, err = database.SchemaDDL(database.NewDriverMySQL(database.DriverMySQLConfig{}), []database.Entity{Account{}})
+		assert.ErrorIs(t, err, database.ErrUnsupportedIndex)
+	}
+
+	{ // Postgres renders the method
+		ddl, err := database.SchemaDDL(database.NewDriverPostgres(database.DriverPostgresConfig{}), []database.Entity{accountWithMethod{}})
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(ddl, `CREATE INDEX "ix_account_email" ON "account" USING gin (email)`), ddl)
+	}
+}
@@ -0,0 +1,265 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/format"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+type tableNameRow struct {
+	Name string `db:"table_name"`
+}
+
+func tableNamesFromRows(rows []tableNameRow) []string {
+	tableNames := []string{}
+	for _, row := range rows {
+		tableNames = append(tableNames, row.Name)
+	}
+
+	return tableNames
+}
+
+// Inspect reads the structure of every table in the database
+func (service *Service) Inspect(ctx context.Context) ([]Table, error) {
+	tableNames, err := service.driver.autoMigrateTableList(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := []Table{}
+	for _, tableName := range tableNames {
+		table, err := service.driver.autoMigrateTableGet(ctx, service, tableName)
+		if err != nil {
+			return nil, err
+		}
+
+		tables = append(tables, table)
+	}
+
+	return tables, nil
+}
+
+func (table Table) Columns() []TableColumn {
+	return slices.Clone(table.columns)
+}
+
+// GenerateEntities writes a Go file with an entity for each table. Go types
+// are guessed from the column types so the output is a starting point to be
+// reviewed rather than something to regenerate on every change.
+func GenerateEntities(w io.Writer, packageName string, tables []Table) error {
+	body := &bytes.Buffer{}
+	imports := map[string]bool{
+		"github.com/lunagic/athena/athenaservices/database": true,
+	}
+
+	for _, table := range tables {
+		structName := goIdentifier(table.Name)
+
+		fmt.Fprintf(body, "type %s struct {\n", structName)
+		fieldNames := map[string]int{}
+		for _, column := range table.columns {
+			fieldName := goIdentifier(column.Name)
+			fieldNames[fieldName]++
+			if fieldNames[fieldName] > 1 {
+				fieldName = fmt.Sprintf("%s%d", fieldName, fieldNames[fieldName])
+			}
+
+			goType, importPath := goTypeFromColumn(column)
+			if importPath != "" {
+				imports[importPath] = true
+			}
+
+			fmt.Fprintf(body, "\t%s %s `db:%q`\n", fieldName, goType, goTag(column))
+		}
+		fmt.Fprintf(body, "}\n\n")
+
+		fmt.Fprintf(body, "func (e %s) TableStructure() database.Table {\n", structName)
+		fmt.Fprintf(body, "\treturn database.Table{\n")
+		fmt.Fprintf(body, "\t\tName: %q,\n", table.Name)
+		if table.Comment != "" {
+			fmt.Fprintf(body, "\t\tComment: %q,\n", table.Comment)
+		}
+		if len(table.Indexes) > 0 {
+			fmt.Fprintf(body, "\t\tIndexes: []database.TableIndex{\n")
+			for _, index := range table.Indexes {
+				fmt.Fprintf(body, "\t\t\t{\n")
+				fmt.Fprintf(body, "\t\t\t\tName: %q,\n", index.Name)
+				fmt.Fprintf(body, "\t\t\t\tColumns: %#v,\n", index.Columns)
+				if index.Unique {
+					fmt.Fprintf(body, "\t\t\t\tUnique: true,\n")
+				}
+				if len(index.Descending) > 0 {
+					fmt.Fprintf(body, "\t\t\t\tDescending: %#v,\n", index.Descending)
+				}
+				if len(index.Expressions) > 0 {
+					fmt.Fprintf(body, "\t\t\t\tExpressions: %#v,\n", index.Expressions)
+				}
+				if index.Where != "" {
+					fmt.Fprintf(body, "\t\t\t\tWhere: %q,\n", index.Where)
+				}
+				if index.Method != "" {
+					fmt.Fprintf(body, "\t\t\t\tMethod: %q,\n", index.Method)
+				}
+				fmt.Fprintf(body, "\t\t\t},\n")
+			}
+			fmt.Fprintf(body, "\t\t},\n")
+		}
+		fmt.Fprintf(body, "\t}\n")
+		fmt.Fprintf(body, "}\n\n")
+	}
+
+	importPaths := []string{}
+	for importPath := range imports {
+		importPaths = append(importPaths, importPath)
+	}
+	slices.Sort(importPaths)
+
+	source := &bytes.Buffer{}
+	fmt.Fprintf(source, "package %s\n\n", packageName)
+	fmt.Fprintf(source, "import (\n")
+	for _, importPath := range importPaths {
+		fmt.Fprintf(source, "\t%q\n", importPath)
+	}
+	fmt.Fprintf(source, ")\n\n")
+	source.Write(body.Bytes())
+
+	formatted, err := format.Source(source.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(formatted)
+
+	return err
+}
+
+func goTypeFromColumn(column TableColumn) (goType string, importPath string) {
+	columnType := strings.ToLower(column.Type)
+	unsigned := strings.Contains(columnType, "unsigned")
+
+	integer := func(size string) string {
+		if unsigned {
+			return "uint" + size
+		}
+
+		return "int" + size
+	}
+
+	switch {
+	case strings.Contains(columnType, "json"):
+		// json.RawMessage is a slice so it is already nullable
+		return "json.RawMessage", "encoding/json"
+	case strings.HasPrefix(columnType, "bool"):
+		goType = "bool"
+	// Postgres intervals look like integers by name but scan as text
+	case strings.HasPrefix(columnType, "interval"):
+		goType = "string"
+	case strings.HasPrefix(columnType, "tinyint"):
+		goType = integer("8")
+	case strings.HasPrefix(columnType, "smallint"), strings.HasPrefix(columnType, "int2"):
+		goType = integer("16")
+	case strings.HasPrefix(columnType, "bigint"), strings.HasPrefix(columnType, "int8"), strings.HasPrefix(columnType, "bigserial"):
+		goType = integer("64")
+	// SQLite integers are always 64 bit
+	case strings.HasPrefix(columnType, "integer"):
+		goType = integer("64")
+	case strings.HasPrefix(columnType, "int"), strings.HasPrefix(columnType, "mediumint"), strings.HasPrefix(columnType, "serial"):
+		goType = integer("32")
+	case strings.HasPrefix(columnType, "float"):
+		goType = "float32"
+	case strings.HasPrefix(columnType, "double"),
+		strings.HasPrefix(columnType, "real"),
+		strings.HasPrefix(columnType, "numeric"),
+		strings.HasPrefix(columnType, "decimal"):
+		goType = "float64"
+	case strings.HasPrefix(columnType, "date"), strings.HasPrefix(columnType, "time"):
+		goType = "time.Time"
+		importPath = "time"
+	default:
+		goType = "string"
+	}
+
+	if column.Nullable {
+		goType = "*" + goType
+	}
+
+	return goType, importPath
+}
+
+func goTag(column TableColumn) string {
+	parts := []string{column.Name}
+
+	if column.PrimaryKey {
+		parts = append(parts, "primaryKey")
+	}
+
+	if column.AutoIncrement {
+		parts = append(parts, "autoIncrement")
+	}
+
+	// Columns filled in by the database shouldn't be overwritten with zero values
+	if column.Default != nil && (strings.Contains(strings.ToLower(*column.Default), "current_timestamp") || strings.Contains(strings.ToLower(*column.Default), "now()")) {
+		parts = append(parts, "readOnly")
+	}
+
+	// Tags are comma separated and quoted, so values that would break them are
+	// left out rather than escaped
+	safe := func(s string) bool {
+		return !strings.ContainsAny(s, ",\"`")
+	}
+
+	if column.Default != nil && *column.Default != "NULL" && !strings.HasPrefix(*column.Default, "nextval(") && safe(*column.Default) {
+		parts = append(parts, "default="+*column.Default)
+	}
+
+	if column.Comment != "" && safe(column.Comment) {
+		parts = append(parts, "comment="+column.Comment)
+	}
+
+	if column.ForeignKey.TargetTable != "" {
+		parts = append(parts, fmt.Sprintf("foreignKey=%s.%s", column.ForeignKey.TargetTable, column.ForeignKey.TargetColumn))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+var goInitialisms = map[string]string{
+	"api":  "API",
+	"html": "HTML",
+	"http": "HTTP",
+	"id":   "ID",
+	"ip":   "IP",
+	"json": "JSON",
+	"sql":  "SQL",
+	"url":  "URL",
+	"uuid": "UUID",
+}
+
+func goIdentifier(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	identifier := ""
+	for _, word := range words {
+		if initialism, found := goInitialisms[strings.ToLower(word)]; found {
+			identifier += initialism
+			continue
+		}
+
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		identifier += string(runes)
+	}
+
+	if identifier == "" || unicode.IsDigit([]rune(identifier)[0]) {
+		identifier = "X" + identifier
+	}
+
+	return identifier
+}
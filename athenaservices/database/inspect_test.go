@@ -0,0 +1,48 @@
+package database_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func TestInspect(t *testing.T) {
+	service, err := database.New(database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())))
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{Company{}, UserV2{}})
+	assert.NilError(t, err)
+
+	tables, err := service.Inspect(t.Context())
+	assert.NilError(t, err)
+	assert.Equal(t, len(tables), 2)
+	assert.Equal(t, tables[0].Name, "company")
+	assert.Equal(t, tables[1].Name, "user")
+
+	columns := map[string]database.TableColumn{}
+	for _, column := range tables[1].Columns() {
+		columns[column.Name] = column
+	}
+	assert.Assert(t, columns["id"].PrimaryKey)
+	assert.Assert(t, columns["id"].AutoIncrement)
+	assert.Equal(t, columns["company_id"].ForeignKey.TargetTable, "company")
+
+	output := &bytes.Buffer{}
+	assert.NilError(t, database.GenerateEntities(output, "models", tables))
+
+	generated := output.String()
+	for _, expected := range []string{
+		"package models",
+		"type User struct {",
+		"ID                int64     `db:\"id,primaryKey,autoIncrement\"`",
+		"CreatedAt         time.Time `db:\"created_at,readOnly,default=CURRENT_TIMESTAMP\"`",
+		"CompanyID         int64     `db:\"company_id,foreignKey=company.id\"`",
+		"WillBeChangedInV2 *string   `db:\"WillBeChangedInV2\"`",
+		"func (e User) TableStructure() database.Table {",
+	} {
+		assert.Assert(t, bytes.Contains(output.Bytes(), []byte(expected)), "missing %q in:\n%s", expected, generated)
+	}
+}
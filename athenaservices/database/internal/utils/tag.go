@@ -16,6 +16,8 @@ type DBTag struct {
 	Default                string
 	Comment                string
 	HasDefault             bool
+	Searchable             bool
+	LongText               bool
 }
 
 func ParseTag(tagString reflect.StructTag) DBTag {
@@ -46,6 +48,18 @@ func ParseTag(tagString reflect.StructTag) DBTag {
 			continue
 		}
 
+		if part == "searchable" {
+			tag.Searchable = true
+
+			continue
+		}
+
+		if part == "longText" {
+			tag.LongText = true
+
+			continue
+		}
+
 		if strings.HasPrefix(part, "default=") {
 			tag.Default = strings.TrimPrefix(part, "default=")
 			tag.HasDefault = true
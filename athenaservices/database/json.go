@@ -0,0 +1,197 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidJSONPath = errors.New("invalid json path")
+	ErrUnsupportedJSON = errors.New("json query not supported by driver")
+)
+
+type JSONPathExpression struct {
+	column any
+	path   string
+}
+
+// JSONPath points inside a column stored as JSON using paths like
+// $.Settings.Colors[0]
+func JSONPath[T any](column *T, path string) JSONPathExpression {
+	return JSONPathExpression{
+		column: column,
+		path:   path,
+	}
+}
+
+func (expression JSONPathExpression) Equal(value any) OperatorOfEvaluation {
+	return expression.compare("=", value)
+}
+
+func (expression JSONPathExpression) NotEqual(value any) OperatorOfEvaluation {
+	return expression.compare("!=", value)
+}
+
+func (expression JSONPathExpression) GreaterThan(value any) OperatorOfEvaluation {
+	return expression.compare(">", value)
+}
+
+func (expression JSONPathExpression) GreaterThanOrEqual(value any) OperatorOfEvaluation {
+	return expression.compare(">=", value)
+}
+
+func (expression JSONPathExpression) LessThan(value any) OperatorOfEvaluation {
+	return expression.compare("<", value)
+}
+
+func (expression JSONPathExpression) LessThanOrEqual(value any) OperatorOfEvaluation {
+	return expression.compare("<=", value)
+}
+
+func (expression JSONPathExpression) compare(operator string, value any) OperatorOfEvaluation {
+	return jsonPathOperator{
+		Column:   expression.column,
+		Path:     expression.path,
+		Operator: operator,
+		Value:    value,
+	}
+}
+
+// JSONContains finds the rows where the JSON column contains the value, so
+// objects having at least the given keys and arrays having at least the given
+// elements
+func JSONContains[T any](column *T, value any) OperatorOfEvaluation {
+	return jsonContainsOperator{
+		Column: column,
+		Value:  value,
+	}
+}
+
+type jsonPathOperator struct {
+	Column   any
+	Path     string
+	Operator string
+	Value    any
+}
+
+func (o jsonPathOperator) haveDriverRender(driver Driver) (statement, error) {
+	return driver.generateJSONPath(o)
+}
+
+type jsonContainsOperator struct {
+	Column any
+	Value  any
+}
+
+func (o jsonContainsOperator) haveDriverRender(driver Driver) (statement, error) {
+	return driver.generateJSONContains(o)
+}
+
+// jsonPathSegment is either a key or an array index
+type jsonPathSegment struct {
+	Key   string
+	Index int
+	IsKey bool
+}
+
+var (
+	jsonPathSegmentRegex = regexp.MustCompile(`^(?:\.(\w+)|\."((?:[^"\\]|\\.)*)"|\[(\d+)\])`)
+	jsonPathKeyRegex     = regexp.MustCompile(`^\w+$`)
+)
+
+func parseJSONPath(path string) ([]jsonPathSegment, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("%w: %s must start with $", ErrInvalidJSONPath, path)
+	}
+
+	segments := []jsonPathSegment{}
+	rest := path[1:]
+	for rest != "" {
+		match := jsonPathSegmentRegex.FindStringSubmatch(rest)
+		if match == nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidJSONPath, path)
+		}
+
+		switch {
+		case match[1] != "":
+			segments = append(segments, jsonPathSegment{Key: match[1], IsKey: true})
+		case match[3] != "":
+			index, err := strconv.Atoi(match[3])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidJSONPath, path)
+			}
+			segments = append(segments, jsonPathSegment{Index: index})
+		default:
+			key, err := strconv.Unquote(`"` + match[2] + `"`)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidJSONPath, path)
+			}
+			segments = append(segments, jsonPathSegment{Key: key, IsKey: true})
+		}
+
+		rest = rest[len(match[0]):]
+	}
+
+	return segments, nil
+}
+
+// renderJSONPath renders the path the way SQLite and MySQL expect it
+func renderJSONPath(segments []jsonPathSegment) string {
+	path := "$"
+	for _, segment := range segments {
+		if !segment.IsKey {
+			path += fmt.Sprintf("[%d]", segment.Index)
+			continue
+		}
+
+		if jsonPathKeyRegex.MatchString(segment.Key) {
+			path += "." + segment.Key
+		} else {
+			path += "." + strconv.Quote(segment.Key)
+		}
+	}
+
+	return path
+}
+
+// jsonParameterKey derives the key from everything that goes into the
+// condition so several conditions on the same column don't overwrite each
+// other's parameters
+func jsonParameterKey(column string, parts ...any) string {
+	hash := sha256.Sum256(fmt.Appendf(nil, "%#v", parts))
+
+	return fmt.Sprintf(":%s_json_%s", parameterKeyCleaner.ReplaceAllString(column, "_"), hex.EncodeToString(hash[:6]))
+}
+
+// jsonNormalize turns the value into what it looks like once stored as JSON
+func jsonNormalize(value any) (any, error) {
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized any
+	if err := json.Unmarshal(valueBytes, &normalized); err != nil {
+		return nil, err
+	}
+
+	return normalized, nil
+}
+
+func jsonIsNumber(value any) bool {
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
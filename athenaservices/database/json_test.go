@@ -0,0 +1,121 @@
+package database_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+type ProfileData struct {
+	Color   string
+	Age     int
+	Admin   bool
+	Tags    []string
+	Address struct {
+		City string
+	}
+}
+
+type Profile struct {
+	ID   int64       `db:"id,primaryKey,autoIncrement"`
+	Data ProfileData `db:"data"`
+}
+
+func (e Profile) TableStructure() database.Table {
+	return database.Table{
+		Name: "profile",
+	}
+}
+
+// ProfileNote has a column of the same name as Profile
+type ProfileNote struct {
+	ID   int64       `db:"id,primaryKey,autoIncrement"`
+	Data ProfileData `db:"data"`
+}
+
+func (e ProfileNote) TableStructure() database.Table {
+	return database.Table{
+		Name: "profile_note",
+	}
+}
+
+func TestJSONQueries(t *testing.T) {
+	service, err := database.New(database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())))
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{Profile{}, ProfileNote{}})
+	assert.NilError(t, err)
+
+	repo := database.NewRepository[int64, Profile](service)
+
+	alice := ProfileData{Color: "red", Age: 30, Admin: true, Tags: []string{"go", "sql"}}
+	alice.Address.City = "Paris"
+	aliceID, err := repo.Insert(t.Context(), Profile{Data: alice})
+	assert.NilError(t, err)
+
+	bob := ProfileData{Color: "blue", Age: 9, Tags: []string{"go"}}
+	bob.Address.City = "Rome"
+	bobID, err := repo.Insert(t.Context(), Profile{Data: bob})
+	assert.NilError(t, err)
+
+	find := func(operators ...database.OperatorOfEvaluation) []int64 {
+		profiles, err := repo.SelectMultiple(t.Context(), database.WithAdditionalWhere(database.And(operators...)))
+		assert.NilError(t, err)
+
+		ids := []int64{}
+		for _, profile := range profiles {
+			ids = append(ids, profile.ID)
+		}
+
+		return ids
+	}
+
+	{ // Paths
+		assert.DeepEqual(t, find(database.JSONPath(&repo.T.Data, "$.Color").Equal("red")), []int64{aliceID})
+		assert.DeepEqual(t, find(database.JSONPath(&repo.T.Data, "$.Address.City").Equal("Rome")), []int64{bobID})
+		assert.DeepEqual(t, find(database.JSONPath(&repo.T.Data, "$.Tags[1]").Equal("sql")), []int64{aliceID})
+		assert.DeepEqual(t, find(database.JSONPath(&repo.T.Data, "$.Admin").Equal(true)), []int64{aliceID})
+	}
+
+	{ // Numbers compare as numbers and conditions on one column don't collide
+		assert.DeepEqual(t, find(
+			database.JSONPath(&repo.T.Data, "$.Age").GreaterThan(10),
+			database.JSONPath(&repo.T.Data, "$.Age").LessThan(100),
+		), []int64{aliceID})
+	}
+
+	{ // Containment
+		assert.DeepEqual(t, find(database.JSONContains(&repo.T.Data, map[string]any{"Tags": []string{"sql"}})), []int64{aliceID})
+		assert.DeepEqual(t, find(database.JSONContains(&repo.T.Data, map[string]any{"Tags": []string{"go"}})), []int64{aliceID, bobID})
+		assert.DeepEqual(t, find(database.JSONContains(&repo.T.Data, map[string]any{"Color": "blue", "Address": map[string]any{"City": "Rome"}})), []int64{bobID})
+		assert.DeepEqual(t, find(database.JSONContains(&repo.T.Data, map[string]any{"Color": "green"})), []int64{})
+	}
+
+	{ // Columns of the outer query keep their table inside a subquery
+		noteRepo := database.NewRepository[int64, ProfileNote](service)
+		_, err := noteRepo.Insert(t.Context(), ProfileNote{Data: ProfileData{Color: "blue"}})
+		assert.NilError(t, err)
+
+		redProfile, err := noteRepo.Subquery(t.Context(), nil, database.WithAdditionalWhere(database.And(
+			database.JSONPath(&repo.T.Data, "$.Color").Equal("red"),
+			database.JSONContains(&repo.T.Data, map[string]any{"Tags": []string{"sql"}}),
+		)))
+		assert.NilError(t, err)
+
+		assert.DeepEqual(t, find(database.Exists(redProfile)), []int64{aliceID})
+	}
+
+	{ // Invalid paths
+		_, err := repo.SelectMultiple(t.Context(), database.WithAdditionalWhere(database.And(database.JSONPath(&repo.T.Data, "Color").Equal("red"))))
+		assert.ErrorIs(t, err, database.ErrInvalidJSONPath)
+	}
+}
+
+func TestJSONColumnsPostgres(t *testing.T) {
+	ddl, err := database.SchemaDDL(database.NewDriverPostgres(database.DriverPostgresConfig{}), []database.Entity{Profile{}})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(ddl, `"data" jsonb NOT NULL`), ddl)
+}
@@ -0,0 +1,278 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLockNotAcquired = errors.New("lock is held by someone else")
+	ErrLockLost        = errors.New("lock expired or was released")
+)
+
+// Lock is held until it is unlocked or its ttl passes without a refresh
+type Lock struct {
+	name  string
+	ttl   time.Duration
+	held  heldLock
+	mutex sync.Mutex
+}
+
+// heldLock is the driver specific part of a lock
+type heldLock interface {
+	refresh(ctx context.Context, ttl time.Duration) error
+	release(ctx context.Context) error
+}
+
+// Lock takes the named lock across every instance using the database. It
+// doesn't wait, ErrLockNotAcquired is returned when the lock is already held.
+func (service *Service) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	held, err := service.driver.lockAcquire(ctx, service, name, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lock{
+		name: name,
+		ttl:  ttl,
+		held: held,
+	}, nil
+}
+
+func (lock *Lock) Name() string {
+	return lock.name
+}
+
+// Refresh pushes the expiry of the lock back by its ttl, ErrLockLost is
+// returned when it already expired
+func (lock *Lock) Refresh(ctx context.Context) error {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	if lock.held == nil {
+		return ErrLockLost
+	}
+
+	return lock.held.refresh(ctx, lock.ttl)
+}
+
+func (lock *Lock) Unlock(ctx context.Context) error {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	if lock.held == nil {
+		return ErrLockLost
+	}
+
+	held := lock.held
+	lock.held = nil
+
+	return held.release(ctx)
+}
+
+// sessionLock is a lock tied to a database session (Postgres advisory locks
+// and MySQL named locks), the connection is kept out of the pool while the
+// lock is held and closed afterwards so the lock can't outlive it. The session
+// is given an idle timeout of the ttl so the database drops it, and the lock
+// with it, when a stuck holder stops refreshing.
+type sessionLock struct {
+	conn        *sql.Conn
+	unlockQuery string
+	args        []any
+	timer       *time.Timer
+	expired     bool
+	mutex       sync.Mutex
+}
+
+func acquireSessionLock(ctx context.Context, db *sql.DB, timeoutQuery string, lockQuery string, unlockQuery string, args []any, ttl time.Duration) (heldLock, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(ctx, timeoutQuery); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	acquired := sql.NullBool{}
+	if err := conn.QueryRowContext(ctx, lockQuery, args...).Scan(&acquired); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if !acquired.Bool {
+		_ = conn.Close()
+		return nil, ErrLockNotAcquired
+	}
+
+	lock := &sessionLock{
+		conn:        conn,
+		unlockQuery: unlockQuery,
+		args:        args,
+	}
+
+	// Releases the lock once the ttl passes while this process still runs, the
+	// idle timeout of the session covers holders that stopped altogether
+	lock.timer = time.AfterFunc(ttl, func() {
+		lock.mutex.Lock()
+		defer lock.mutex.Unlock()
+
+		if lock.expired {
+			return
+		}
+
+		lock.expired = true
+		_ = lock.close(context.Background())
+	})
+
+	return lock, nil
+}
+
+func (lock *sessionLock) refresh(ctx context.Context, ttl time.Duration) error {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	if lock.expired {
+		return ErrLockLost
+	}
+
+	// A lost connection means the database already released the lock
+	if err := lock.conn.PingContext(ctx); err != nil {
+		lock.expired = true
+		lock.timer.Stop()
+		_ = lock.close(ctx)
+
+		return errors.Join(ErrLockLost, err)
+	}
+
+	lock.timer.Reset(ttl)
+
+	return nil
+}
+
+func (lock *sessionLock) release(ctx context.Context) error {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	if lock.expired {
+		return ErrLockLost
+	}
+
+	lock.expired = true
+	lock.timer.Stop()
+
+	return lock.close(ctx)
+}
+
+func (lock *sessionLock) close(ctx context.Context) error {
+	_, unlockErr := lock.conn.ExecContext(ctx, lock.unlockQuery, lock.args...)
+
+	// Discard the connection rather than returning it to the pool in case
+	// the unlock didn't go through, failing with ErrBadConn closes it
+	_ = lock.conn.Raw(func(driverConn any) error {
+		return driver.ErrBadConn
+	})
+
+	return unlockErr
+}
+
+// tableLock is a lock stored as a row in the athena_lock table, for databases
+// without locking functions
+type tableLock struct {
+	service *Service
+	name    string
+	token   string
+}
+
+type lockRecord struct {
+	ID        int64  `db:"id,primaryKey,autoIncrement"`
+	Name      string `db:"name"`
+	Token     string `db:"token"`
+	ExpiresAt int64  `db:"expires_at"`
+}
+
+func (e lockRecord) TableStructure() Table {
+	return Table{
+		Name: "athena_lock",
+		Indexes: []TableIndex{
+			{
+				Name:    "ux_athena_lock_name",
+				Columns: []string{"name"},
+				Unique:  true,
+			},
+		},
+	}
+}
+
+func (lock *tableLock) refresh(ctx context.Context, ttl time.Duration) error {
+	result, err := lock.service.runExecute(ctx, statement{
+		Query: "UPDATE `athena_lock` SET `expires_at` = :expires_at WHERE `name` = :name AND `token` = :token AND `expires_at` > :now",
+		Parameters: map[string]any{
+			":expires_at": time.Now().Add(ttl).UnixNano(),
+			":name":       lock.name,
+			":token":      lock.token,
+			":now":        time.Now().UnixNano(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tableLockAffected(result)
+}
+
+func (lock *tableLock) release(ctx context.Context) error {
+	result, err := lock.service.runExecute(ctx, statement{
+		Query: "DELETE FROM `athena_lock` WHERE `name` = :name AND `token` = :token AND `expires_at` > :now",
+		Parameters: map[string]any{
+			":name":  lock.name,
+			":token": lock.token,
+			":now":   time.Now().UnixNano(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tableLockAffected(result)
+}
+
+// lockKey turns the name into the number Postgres advisory locks are keyed by
+func lockKey(name string) int64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(name))
+
+	return int64(hash.Sum64())
+}
+
+// mysqlLockName keeps the name within the 64 characters MySQL allows
+func mysqlLockName(name string) string {
+	if len(name) <= 64 {
+		return name
+	}
+
+	hash := sha256.Sum256([]byte(name))
+
+	return hex.EncodeToString(hash[:])
+}
+
+func tableLockAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrLockLost
+	}
+
+	return nil
+}
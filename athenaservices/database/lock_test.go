@@ -0,0 +1,61 @@
+package database_test
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func TestLockExpiry(t *testing.T) {
+	service := newSQLiteTestService(t, nil)
+
+	lock, err := service.Lock(t.Context(), "nightly-import", 50*time.Millisecond)
+	assert.NilError(t, err)
+	assert.Equal(t, lock.Name(), "nightly-import")
+
+	_, err = service.Lock(t.Context(), "other-job", time.Minute)
+	assert.NilError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Once expired someone else can take the lock and the old handle is lost
+	next, err := service.Lock(t.Context(), "nightly-import", time.Minute)
+	assert.NilError(t, err)
+
+	assert.ErrorIs(t, lock.Refresh(t.Context()), database.ErrLockLost)
+	assert.ErrorIs(t, lock.Unlock(t.Context()), database.ErrLockLost)
+
+	assert.NilError(t, next.Unlock(t.Context()))
+	assert.ErrorIs(t, next.Unlock(t.Context()), database.ErrLockLost)
+}
+
+func TestLockTableDropped(t *testing.T) {
+	var db *sql.DB
+	service, err := database.New(
+		database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())),
+		database.WithPostConnectFunc(func(postConnectDB *sql.DB) error {
+			db = postConnectDB
+			return nil
+		}),
+	)
+	assert.NilError(t, err)
+
+	lock, err := service.Lock(t.Context(), "nightly-import", time.Minute)
+	assert.NilError(t, err)
+	assert.NilError(t, lock.Unlock(t.Context()))
+
+	_, err = db.ExecContext(t.Context(), "DROP TABLE `athena_lock`")
+	assert.NilError(t, err)
+
+	lock, err = service.Lock(t.Context(), "nightly-import", time.Minute)
+	assert.NilError(t, err)
+
+	_, err = service.Lock(t.Context(), "nightly-import", time.Minute)
+	assert.ErrorIs(t, err, database.ErrLockNotAcquired)
+
+	assert.NilError(t, lock.Unlock(t.Context()))
+}
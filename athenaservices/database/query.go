@@ -1,5 +1,7 @@
 package database
 
+import "time"
+
 type statement struct {
 	Query      string
 	Parameters map[string]any
@@ -16,4 +18,6 @@ type Query struct {
 		Count  int
 		Offset int
 	}
+	CacheTTL  time.Duration
+	relevance *searchMatch
 }
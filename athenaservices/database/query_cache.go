@@ -0,0 +1,265 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lunagic/athena/athenaservices/database/internal/utils"
+)
+
+// QueryCacheDriver is satisfied by every cache.Driver
+type QueryCacheDriver interface {
+	Delete(ctx context.Context, key string) error
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value string, duration time.Duration) error
+}
+
+const queryCacheVersionDuration = time.Hour * 24
+
+// WithQueryCache stores the results of selects using the WithCache modifier in
+// the cache driver. Inserts, updates and deletes made through the service
+// invalidate every cached result reading the table they touch. A failing
+// invalidation is logged rather than failing the write that already happened,
+// results cached before it can then be served until their TTL runs out.
+func WithQueryCache(driver QueryCacheDriver) ServiceConfigFunc {
+	return func(service *Service) error {
+		service.queryCache = driver
+		return nil
+	}
+}
+
+var ErrQueryNotCacheable = errors.New("queries with joins can't be cached, the tables they read are unknown")
+
+// WithCache caches the results of the query for the TTL. Subqueries are
+// followed to invalidate the results when any table they read changes, joins
+// can't be and make the select fail with ErrQueryNotCacheable.
+func WithCache(ttl time.Duration) QueryModifier {
+	return func(query Query) Query {
+		query.CacheTTL = ttl
+
+		return query
+	}
+}
+
+func (service *Service) runCachedSelect(
+	ctx context.Context,
+	query Query,
+	statement statement,
+	targetPointer any,
+) error {
+	if service.queryCache == nil || query.CacheTTL <= 0 {
+		return service.runSelect(ctx, statement, targetPointer)
+	}
+
+	if len(query.Joins) > 0 {
+		return ErrQueryNotCacheable
+	}
+
+	// A broken cache should slow reads down, not take them down
+	key, err := service.queryCacheKey(ctx, queryTables(query), statement)
+	if err != nil {
+		return service.runSelect(ctx, statement, targetPointer)
+	}
+
+	if cached, err := service.queryCache.Get(ctx, key); err == nil {
+		if err := decodeCachedRows(cached, targetPointer); err == nil {
+			return nil
+		}
+	}
+
+	if err := service.runSelect(ctx, statement, targetPointer); err != nil {
+		return err
+	}
+
+	encoded, err := encodeCachedRows(targetPointer)
+	if err != nil {
+		return err
+	}
+
+	_ = service.queryCache.Set(ctx, key, encoded, query.CacheTTL)
+
+	return nil
+}
+
+// queryTables lists the tables the query reads, those of its subqueries
+// included
+func queryTables(query Query) []string {
+	tables := []string{query.From}
+
+	var walk func(operator OperatorOfEvaluation)
+	walk = func(operator OperatorOfEvaluation) {
+		switch o := operator.(type) {
+		case simpleOperatorOfLogic:
+			for _, child := range o.operatorsEvaluation {
+				walk(child)
+			}
+		case subqueryOperator:
+			for _, table := range queryTables(o.Subquery.query) {
+				if !slices.Contains(tables, table) {
+					tables = append(tables, table)
+				}
+			}
+		}
+	}
+
+	if query.Where != nil {
+		walk(query.Where)
+	}
+
+	return tables
+}
+
+// queryCacheKey hashes the current version of every table the query reads
+// into the key so bumping any of them orphans the results cached for it
+func (service *Service) queryCacheKey(ctx context.Context, tableNames []string, statement statement) (string, error) {
+	preparedQuery, preparedArgs, err := utils.Prepare(statement.Query, statement.Parameters, service.driver.usesNumberedParameters())
+	if err != nil {
+		return "", err
+	}
+
+	argsBytes, err := json.Marshal(preparedArgs)
+	if err != nil {
+		return "", err
+	}
+
+	versions := []string{}
+	for _, tableName := range tableNames {
+		versionKey := queryCacheVersionKey(tableName)
+		version, err := service.queryCache.Get(ctx, versionKey)
+		if err != nil {
+			version = uuid.NewString()
+			if err := service.queryCache.Set(ctx, versionKey, version, queryCacheVersionDuration); err != nil {
+				return "", err
+			}
+		}
+
+		versions = append(versions, tableName+"="+version)
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(versions, ",") + "\x00" + preparedQuery + "\x00" + string(argsBytes)))
+
+	return fmt.Sprintf("athena-query-%s-%s", tableNames[0], hex.EncodeToString(hash[:])), nil
+}
+
+// invalidateQueryCache also invalidates the tables that reference the table
+// since cascading deletes change their rows too. It runs after the write
+// succeeded so failures are only logged, reporting the write as failed would
+// get it retried.
+func (service *Service) invalidateQueryCache(ctx context.Context, tableName string) {
+	if service.queryCache == nil || service.deferInvalidation(ctx, tableName) {
+		return
+	}
+
+	pending := []string{tableName}
+	invalidated := map[string]bool{}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		if invalidated[current] {
+			continue
+		}
+		invalidated[current] = true
+
+		if err := service.queryCache.Set(ctx, queryCacheVersionKey(current), uuid.NewString(), queryCacheVersionDuration); err != nil {
+			service.logger.ErrorContext(ctx, "Query Cache Invalidation Failed", "table", current, "error", err)
+		}
+
+		pending = append(pending, service.referencedBy[current]...)
+	}
+}
+
+// registerForeignKeys remembers which tables point at which so cache
+// invalidation can follow them
+func (service *Service) registerForeignKeys(entity Entity) {
+	tableName := entity.TableStructure().Name
+	_ = utils.LoopOverStructFields(reflect.ValueOf(entity), func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
+		tag := utils.ParseTag(fieldDefinition.Tag)
+		if tag.ForeignKeyTargetTable == "" || slices.Contains(service.referencedBy[tag.ForeignKeyTargetTable], tableName) {
+			return nil
+		}
+
+		service.referencedBy[tag.ForeignKeyTargetTable] = append(service.referencedBy[tag.ForeignKeyTargetTable], tableName)
+
+		return nil
+	})
+}
+
+func queryCacheVersionKey(tableName string) string {
+	return fmt.Sprintf("athena-query-version-%s", tableName)
+}
+
+// encodeCachedRows stores each row by column name so the json tags of the
+// entity have no say in what gets cached
+func encodeCachedRows(targetPointer any) (string, error) {
+	target := reflect.ValueOf(targetPointer).Elem()
+
+	rows := []map[string]json.RawMessage{}
+	for i := range target.Len() {
+		row := map[string]json.RawMessage{}
+		if err := utils.LoopOverStructFields(target.Index(i), func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
+			tag := utils.ParseTag(fieldDefinition.Tag)
+			if tag.Column == "" {
+				return nil
+			}
+
+			valueBytes, err := json.Marshal(fieldValue.Interface())
+			if err != nil {
+				return err
+			}
+
+			row[tag.Column] = valueBytes
+
+			return nil
+		}); err != nil {
+			return "", err
+		}
+
+		rows = append(rows, row)
+	}
+
+	rowsBytes, err := json.Marshal(rows)
+	if err != nil {
+		return "", err
+	}
+
+	return string(rowsBytes), nil
+}
+
+func decodeCachedRows(cached string, targetPointer any) error {
+	rows := []map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(cached), &rows); err != nil {
+		return err
+	}
+
+	target := reflect.ValueOf(targetPointer).Elem()
+	decoded := reflect.MakeSlice(target.Type(), 0, len(rows))
+	for _, row := range rows {
+		value := reflect.New(target.Type().Elem()).Elem()
+		if err := utils.LoopOverStructFields(value, func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
+			tag := utils.ParseTag(fieldDefinition.Tag)
+			valueBytes, found := row[tag.Column]
+			if tag.Column == "" || !found {
+				return nil
+			}
+
+			return json.Unmarshal(valueBytes, fieldValue.Addr().Interface())
+		}); err != nil {
+			return err
+		}
+
+		decoded = reflect.Append(decoded, value)
+	}
+
+	target.Set(decoded)
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/cache"
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func TestQueryCache(t *testing.T) {
+	cacheDriver, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	queryCount := 0
+	service, err := database.New(
+		database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())),
+		database.WithQueryCache(cacheDriver),
+		database.WithPreRunFunc(func(ctx context.Context, statement string, args []any) error {
+			queryCount++
+			return nil
+		}),
+	)
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{Company{}, UserV2{}})
+	assert.NilError(t, err)
+
+	companyRepo := database.NewRepository[CompanyID, Company](service)
+	userRepo := database.NewRepository[UserID, UserV2](service)
+
+	companyID, err := companyRepo.Insert(t.Context(), Company{String: "before"})
+	assert.NilError(t, err)
+	_, err = userRepo.Insert(t.Context(), UserV2{CompanyID: companyID, Email: "someone@example.com"})
+	assert.NilError(t, err)
+
+	selectCompany := func() Company {
+		company, err := companyRepo.SelectSingle(t.Context(),
+			database.WithCache(time.Minute),
+			database.WithAdditionalWhere(database.And(database.Equal(&companyRepo.T.ID, companyID))),
+		)
+		assert.NilError(t, err)
+
+		return company
+	}
+
+	{ // Only the first select hits the database
+		before := queryCount
+		assert.Equal(t, selectCompany().String, "before")
+		assert.Equal(t, selectCompany().String, "before")
+		assert.Equal(t, queryCount, before+1)
+	}
+
+	{ // Selects without the modifier are never cached
+		before := queryCount
+		_, err := companyRepo.SelectMultiple(t.Context())
+		assert.NilError(t, err)
+		_, err = companyRepo.SelectMultiple(t.Context())
+		assert.NilError(t, err)
+		assert.Equal(t, queryCount, before+2)
+	}
+
+	{ // Updates invalidate the table
+		assert.NilError(t, companyRepo.Update(t.Context(), Company{ID: companyID, String: "after"}))
+		assert.Equal(t, selectCompany().String, "after")
+	}
+
+	{ // Deletes invalidate the tables that cascade from it too
+		users, err := userRepo.SelectMultiple(t.Context(), database.WithCache(time.Minute))
+		assert.NilError(t, err)
+		assert.Equal(t, len(users), 1)
+
+		assert.NilError(t, companyRepo.Delete(t.Context(), Company{ID: companyID}))
+
+		users, err = userRepo.SelectMultiple(t.Context(), database.WithCache(time.Minute))
+		assert.NilError(t, err)
+		assert.Equal(t, len(users), 0)
+	}
+
+	{ // Changes to the tables of subqueries invalidate the query too
+		activeID, err := companyRepo.Insert(t.Context(), Company{String: "active", Bool: true})
+		assert.NilError(t, err)
+		_, err = userRepo.Insert(t.Context(), UserV2{CompanyID: activeID, Email: "active@example.com"})
+		assert.NilError(t, err)
+
+		usersOfActiveCompanies := func() int {
+			activeCompanies, err := companyRepo.Subquery(t.Context(), &companyRepo.T.ID, database.WithAdditionalWhere(database.And(
+				database.Equal(&companyRepo.T.Bool, true),
+			)))
+			assert.NilError(t, err)
+
+			users, err := userRepo.SelectMultiple(t.Context(),
+				database.WithCache(time.Minute),
+				database.WithAdditionalWhere(database.And(database.InSubquery(&userRepo.T.CompanyID, activeCompanies))),
+			)
+			assert.NilError(t, err)
+
+			return len(users)
+		}
+
+		assert.Equal(t, usersOfActiveCompanies(), 1)
+		assert.NilError(t, companyRepo.Update(t.Context(), Company{ID: activeID, String: "active"}))
+		assert.Equal(t, usersOfActiveCompanies(), 0)
+	}
+
+	{ // The tables of joins are unknown
+		_, err := companyRepo.SelectMultiple(t.Context(), database.WithCache(time.Minute), func(query database.Query) database.Query {
+			query.Joins = append(query.Joins, "JOIN user ON user.company_id = company.id")
+			return query
+		})
+		assert.ErrorIs(t, err, database.ErrQueryNotCacheable)
+	}
+}
+
+// failingQueryCache fails every write once broken
+type failingQueryCache struct {
+	database.QueryCacheDriver
+	broken bool
+}
+
+func (cache *failingQueryCache) Set(ctx context.Context, key string, value string, duration time.Duration) error {
+	if cache.broken {
+		return errors.New("cache is down")
+	}
+
+	return cache.QueryCacheDriver.Set(ctx, key, value, duration)
+}
+
+func TestQueryCacheFailingInvalidation(t *testing.T) {
+	cacheDriver, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	queryCache := &failingQueryCache{QueryCacheDriver: cacheDriver}
+	service, err := database.New(
+		database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())),
+		database.WithQueryCache(queryCache),
+	)
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{Company{}})
+	assert.NilError(t, err)
+
+	companyRepo := database.NewRepository[CompanyID, Company](service)
+	companyID, err := companyRepo.Insert(t.Context(), Company{String: "before"})
+	assert.NilError(t, err)
+
+	// The write already happened, failing it would only get it retried
+	queryCache.broken = true
+	assert.NilError(t, companyRepo.Update(t.Context(), Company{ID: companyID, String: "after"}))
+
+	company, err := companyRepo.SelectSingle(t.Context())
+	assert.NilError(t, err)
+	assert.Equal(t, company.String, "after")
+}
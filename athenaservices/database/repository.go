@@ -20,11 +20,14 @@ func NewRepository[ID ~int64, T Entity](service *Service, baseModifiers ...func(
 	}
 
 	r := Repository[ID, T]{
-		selector:      NewSelector[T](service, baseQuery),
-		T:             new(T),
-		BaseModifiers: baseModifiers,
+		selector:         NewSelector[T](service, baseQuery),
+		T:                new(T),
+		BaseModifiers:    baseModifiers,
+		tenantFieldIndex: -1,
 	}
 
+	service.registerForeignKeys(*r.T)
+
 	value := reflect.ValueOf(r.T).Elem()
 	for i := range value.NumField() {
 		fieldValue := value.Field(i)
@@ -40,111 +43,159 @@ func NewRepository[ID ~int64, T Entity](service *Service, baseModifiers ...func(
 			continue
 		}
 
-		service.mapping[fieldValue.UnsafeAddr()] = columnName
+		if service.tenantColumn != "" && columnName == service.tenantColumn {
+			r.tenantFieldIndex = i
+		}
+
+		service.mapping[fieldValue.UnsafeAddr()] = mappedColumn{
+			Table:  baseQuery.From,
+			Column: columnName,
+		}
 	}
 
 	return r
 }
 
 type Repository[ID ~int64, T Entity] struct {
-	selector      Selector[T]
-	T             *T
-	BaseModifiers []func(ctx context.Context, t *T) (QueryModifier, error)
+	selector         Selector[T]
+	T                *T
+	BaseModifiers    []func(ctx context.Context, t *T) (QueryModifier, error)
+	tenantFieldIndex int
 }
 
 func (repository *Repository[ID, T]) SelectMultiple(ctx context.Context, mods ...QueryModifier) ([]T, error) {
-	for _, mod := range repository.BaseModifiers {
-		queryModifier, err := mod(ctx, repository.T)
-		if err != nil {
-			return nil, err
-		}
-
-		// Prepend the base modifiers
-		mods = append([]QueryModifier{queryModifier}, mods...)
+	mods, err := repository.prependBaseModifiers(ctx, mods)
+	if err != nil {
+		return nil, err
 	}
 
 	return repository.selector.SelectMultiple(ctx, mods...)
 }
 
 func (repository *Repository[ID, T]) SelectSingle(ctx context.Context, mods ...QueryModifier) (T, error) {
+	mods, err := repository.prependBaseModifiers(ctx, mods)
+	if err != nil {
+		return *new(T), err
+	}
+
+	return repository.selector.SelectSingle(ctx, mods...)
+}
+
+func (repository *Repository[ID, T]) prependBaseModifiers(ctx context.Context, mods []QueryModifier) ([]QueryModifier, error) {
 	for _, mod := range repository.BaseModifiers {
 		queryModifier, err := mod(ctx, repository.T)
 		if err != nil {
-			return *new(T), err
+			return nil, err
 		}
 
 		// Prepend the base modifiers
 		mods = append([]QueryModifier{queryModifier}, mods...)
 	}
-	return repository.selector.SelectSingle(ctx, mods...)
-}
 
-func (repository *Repository[ID, T]) Insert(ctx context.Context, entity T) (ID, error) {
-	statement, err := repository.selector.service.driver.generateInsert(entity)
+	tenantWhere, err := repository.tenantWhere(ctx)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	if !repository.selector.service.driver.usesLastInsertId() {
-		lastInsertID := []struct {
-			ID ID `db:"id"`
-		}{}
-		if err := repository.selector.service.runSelect(ctx, statement, &lastInsertID); err != nil {
-			return 0, err
-		}
-
-		return lastInsertID[0].ID, nil
+	if tenantWhere != nil {
+		mods = append([]QueryModifier{WithAdditionalWhere(tenantWhere)}, mods...)
 	}
 
-	result, err := repository.selector.service.runExecute(ctx, statement)
-	if err != nil {
-		return 0, err
-	}
+	return mods, nil
+}
 
-	lastInsertID, err := result.LastInsertId()
+func (repository *Repository[ID, T]) Insert(ctx context.Context, entity T) (ID, error) {
+	entity, err := repository.tenantStamp(ctx, entity)
 	if err != nil {
 		return 0, err
 	}
 
-	if lastInsertID == 0 {
-		if err := utils.LoopOverStructFields(reflect.ValueOf(entity), func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
-			// Grab the primary key if it wasn't from an AUTO_INCREMENT
-			tag := utils.ParseTag(fieldDefinition.Tag)
-			if tag.PrimaryKey {
-				lastInsertID = fieldValue.Int()
-			}
-
-			return nil
-		}); err != nil {
-			return 0, nil
+	lastInsertID := int64(0)
+	if err := repository.selector.service.audited(ctx, func(ctx context.Context) error {
+		lastInsertID, err = repository.selector.service.insert(ctx, entity, false, false)
+		if err != nil {
+			return err
 		}
+
+		entity, primaryKey := entityWithPrimaryKey(entity, lastInsertID)
+
+		return repository.selector.service.recordAudit(ctx, AuditOperationInsert, primaryKey, nil, entity)
+	}); err != nil {
+		return 0, err
 	}
 
 	return ID(lastInsertID), nil
 }
 
 func (repository *Repository[ID, T]) Update(ctx context.Context, entity T) error {
-	statement, err := repository.selector.service.driver.generateUpdate(entity)
+	entity, err := repository.tenantStamp(ctx, entity)
+	if err != nil {
+		return err
+	}
+
+	tenantWhere, err := repository.tenantWhere(ctx)
 	if err != nil {
 		return err
 	}
 
-	if _, err := repository.selector.service.runExecute(ctx, statement); err != nil {
+	statement, err := repository.selector.service.driver.generateUpdate(entity, tenantWhere)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return repository.selector.service.audited(ctx, func(ctx context.Context) error {
+		before, err := repository.auditSnapshot(ctx, entity)
+		if err != nil {
+			return err
+		}
+
+		result, err := repository.selector.service.runExecute(ctx, statement)
+		if err != nil {
+			return err
+		}
+
+		repository.selector.service.invalidateQueryCache(ctx, entity.TableStructure().Name)
+
+		if before == nil || !auditRowsAffected(result) {
+			return nil
+		}
+
+		_, primaryKey := entityPrimaryKey(reflect.ValueOf(repository.T).Elem(), entity)
+
+		return repository.selector.service.recordAudit(ctx, AuditOperationUpdate, primaryKey, *before, entity)
+	})
 }
 
 func (repository *Repository[ID, T]) Delete(ctx context.Context, entity T) error {
-	statement, err := repository.selector.service.driver.generateDelete(entity)
+	tenantWhere, err := repository.tenantWhere(ctx)
 	if err != nil {
 		return err
 	}
 
-	if _, err := repository.selector.service.runExecute(ctx, statement); err != nil {
+	statement, err := repository.selector.service.driver.generateDelete(entity, tenantWhere)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return repository.selector.service.audited(ctx, func(ctx context.Context) error {
+		before, err := repository.auditSnapshot(ctx, entity)
+		if err != nil {
+			return err
+		}
+
+		result, err := repository.selector.service.runExecute(ctx, statement)
+		if err != nil {
+			return err
+		}
+
+		repository.selector.service.invalidateQueryCache(ctx, entity.TableStructure().Name)
+
+		if before == nil || !auditRowsAffected(result) {
+			return nil
+		}
+
+		_, primaryKey := entityPrimaryKey(reflect.ValueOf(repository.T).Elem(), entity)
+
+		return repository.selector.service.recordAudit(ctx, AuditOperationDelete, primaryKey, *before, nil)
+	})
 }
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+)
+
+type DiagramFormat string
+
+const (
+	DiagramFormatMermaid  DiagramFormat = "mermaid"
+	DiagramFormatGraphviz DiagramFormat = "graphviz"
+)
+
+// SchemaDDL renders the statements AutoMigrate would run against an empty
+// database for the given entities. The driver is never opened.
+func SchemaDDL(driver Driver, entities []Entity) (string, error) {
+	schema, err := resolveSchema(driver, entities)
+	if err != nil {
+		return "", err
+	}
+
+	parts := []string{}
+	for _, entry := range schema {
+		statements, err := driver.autoMigrateTableCreate(entry.table)
+		if err != nil {
+			return "", err
+		}
+
+		for _, statement := range statements {
+			parts = append(parts, strings.TrimSuffix(strings.TrimSpace(statement.Query), ";")+";")
+		}
+	}
+
+	return strings.Join(parts, "\n") + "\n", nil
+}
+
+// SchemaDiagram renders an entity-relationship diagram for the given entities
+// using the column types of the driver.
+func SchemaDiagram(driver Driver, entities []Entity, format DiagramFormat) (string, error) {
+	schema, err := resolveSchema(driver, entities)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case DiagramFormatMermaid:
+		return renderMermaid(schema), nil
+	case DiagramFormatGraphviz:
+		return renderGraphviz(schema), nil
+	}
+
+	return "", fmt.Errorf("invalid diagram format: %s", format)
+}
+
+type schemaEntry struct {
+	entity Entity
+	table  Table
+}
+
+// resolveSchema hydrates the tables of the entities and orders them so every
+// table comes after the tables its foreign keys point to
+func resolveSchema(driver Driver, entities []Entity) ([]schemaEntry, error) {
+	pending := []schemaEntry{}
+	for _, entity := range entities {
+		table := entity.TableStructure()
+		if err := table.hydrateColumns(driver, entity); err != nil {
+			return nil, err
+		}
+
+		pending = append(pending, schemaEntry{
+			entity: entity,
+			table:  driver.autoMigrateAdjustTableDefinition(table),
+		})
+	}
+
+	known := map[string]bool{}
+	for _, entry := range pending {
+		known[entry.table.Name] = true
+	}
+
+	resolved := []schemaEntry{}
+	placed := map[string]bool{}
+	for len(pending) > 0 {
+		remaining := []schemaEntry{}
+		for _, entry := range pending {
+			ready := true
+			for _, column := range entry.table.columns {
+				target := column.ForeignKey.TargetTable
+				if target == "" || target == entry.table.Name || !known[target] {
+					continue
+				}
+
+				if !placed[target] {
+					ready = false
+					break
+				}
+			}
+
+			if !ready {
+				remaining = append(remaining, entry)
+				continue
+			}
+
+			resolved = append(resolved, entry)
+			placed[entry.table.Name] = true
+		}
+
+		// Circular references can't be ordered, keep them in the order they were given
+		if len(remaining) == len(pending) {
+			resolved = append(resolved, remaining...)
+			break
+		}
+
+		pending = remaining
+	}
+
+	return resolved, nil
+}
+
+func renderMermaid(schema []schemaEntry) string {
+	lines := []string{"erDiagram"}
+
+	for _, entry := range schema {
+		lines = append(lines, fmt.Sprintf("    %s {", entry.table.Name))
+		for _, column := range entry.table.columns {
+			keys := []string{}
+			if column.PrimaryKey {
+				keys = append(keys, "PK")
+			}
+			if column.ForeignKey.TargetTable != "" {
+				keys = append(keys, "FK")
+			}
+
+			line := fmt.Sprintf("        %s %s", strings.ReplaceAll(column.Type, " ", "_"), column.Name)
+			if len(keys) > 0 {
+				line += " " + strings.Join(keys, ", ")
+			}
+			if column.Comment != "" {
+				line += fmt.Sprintf(" %q", column.Comment)
+			}
+
+			lines = append(lines, line)
+		}
+		lines = append(lines, "    }")
+	}
+
+	for _, entry := range schema {
+		for _, column := range entry.table.columns {
+			if column.ForeignKey.TargetTable == "" {
+				continue
+			}
+
+			// A nullable foreign key means the row might not have a parent
+			parent := "||"
+			if column.Nullable {
+				parent = "|o"
+			}
+
+			lines = append(lines, fmt.Sprintf(
+				"    %s %s--o{ %s : %q",
+				column.ForeignKey.TargetTable,
+				parent,
+				entry.table.Name,
+				column.Name,
+			))
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func renderGraphviz(schema []schemaEntry) string {
+	lines := []string{
+		"digraph schema {",
+		"    rankdir=LR;",
+		"    node [shape=plaintext];",
+	}
+
+	for _, entry := range schema {
+		rows := []string{fmt.Sprintf(`<tr><td bgcolor="lightgrey"><b>%s</b></td></tr>`, html.EscapeString(entry.table.Name))}
+		for _, column := range entry.table.columns {
+			keys := []string{}
+			if column.PrimaryKey {
+				keys = append(keys, "PK")
+			}
+			if column.ForeignKey.TargetTable != "" {
+				keys = append(keys, "FK")
+			}
+
+			label := fmt.Sprintf("%s %s", column.Name, column.Type)
+			if len(keys) > 0 {
+				label += " (" + strings.Join(keys, ", ") + ")"
+			}
+
+			rows = append(rows, fmt.Sprintf(
+				`<tr><td port="%s" align="left">%s</td></tr>`,
+				html.EscapeString(column.Name),
+				html.EscapeString(label),
+			))
+		}
+
+		lines = append(lines, fmt.Sprintf(
+			`    "%s" [label=<<table border="0" cellborder="1" cellspacing="0">%s</table>>];`,
+			entry.table.Name,
+			strings.Join(rows, ""),
+		))
+	}
+
+	edges := []string{}
+	for _, entry := range schema {
+		for _, column := range entry.table.columns {
+			if column.ForeignKey.TargetTable == "" {
+				continue
+			}
+
+			edges = append(edges, fmt.Sprintf(
+				`    "%s":"%s" -> "%s":"%s";`,
+				entry.table.Name,
+				column.Name,
+				column.ForeignKey.TargetTable,
+				column.ForeignKey.TargetColumn,
+			))
+		}
+	}
+	slices.Sort(edges)
+
+	lines = append(lines, edges...)
+	lines = append(lines, "}")
+
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,62 @@
+package database_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func TestSchemaDDL(t *testing.T) {
+	// The user table is listed first on purpose to confirm foreign key ordering
+	entities := []database.Entity{
+		UserV2{},
+		Company{},
+	}
+
+	for _, driver := range []database.Driver{
+		database.NewDriverSQLite(t.TempDir() + "/database.sqlite"),
+		database.NewDriverPostgres(database.DriverPostgresConfig{}),
+		database.NewDriverMySQL(database.DriverMySQLConfig{}),
+	} {
+		ddl, err := database.SchemaDDL(driver, entities)
+		assert.NilError(t, err)
+
+		companyIndex := strings.Index(ddl, "CREATE TABLE")
+		userIndex := strings.LastIndex(ddl, "CREATE TABLE")
+		assert.Assert(t, companyIndex != userIndex)
+		assert.Assert(t, strings.Contains(ddl[companyIndex:userIndex], "company"))
+		assert.Assert(t, strings.Contains(ddl[userIndex:], "user"))
+		assert.Assert(t, strings.Contains(ddl, "ix_user_company_id"))
+	}
+}
+
+func TestSchemaDiagram(t *testing.T) {
+	driver := database.NewDriverSQLite(t.TempDir() + "/database.sqlite")
+	entities := []database.Entity{
+		Company{},
+		UserV2{},
+	}
+
+	{ // Mermaid
+		diagram, err := database.SchemaDiagram(driver, entities, database.DiagramFormatMermaid)
+		assert.NilError(t, err)
+		assert.Assert(t, strings.HasPrefix(diagram, "erDiagram\n"))
+		assert.Assert(t, strings.Contains(diagram, "INTEGER id PK"))
+		assert.Assert(t, strings.Contains(diagram, "INTEGER company_id FK"))
+		assert.Assert(t, strings.Contains(diagram, `company ||--o{ user : "company_id"`))
+	}
+
+	{ // Graphviz
+		diagram, err := database.SchemaDiagram(driver, entities, database.DiagramFormatGraphviz)
+		assert.NilError(t, err)
+		assert.Assert(t, strings.HasPrefix(diagram, "digraph schema {\n"))
+		assert.Assert(t, strings.Contains(diagram, `"user":"company_id" -> "company":"id";`))
+	}
+
+	{ // Unknown format
+		_, err := database.SchemaDiagram(driver, entities, "unknown")
+		assert.ErrorContains(t, err, "invalid diagram format")
+	}
+}
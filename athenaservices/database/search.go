@@ -0,0 +1,60 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrSearchUnavailable = errors.New("full text search not available")
+
+// Match finds the rows where the searchable column contains every word of the
+// query
+func Match[T ~string](column *T, query string) OperatorOfEvaluation {
+	return searchMatch{
+		Column: column,
+		Query:  query,
+	}
+}
+
+// WithRelevance orders the rows by how well the searchable column matches the
+// query, best matches first
+func WithRelevance[T ~string](column *T, query string) QueryModifier {
+	return func(q Query) Query {
+		q.relevance = &searchMatch{
+			Column: column,
+			Query:  query,
+		}
+
+		return q
+	}
+}
+
+type searchMatch struct {
+	Column any
+	Query  string
+}
+
+func (o searchMatch) haveDriverRender(driver Driver) (statement, error) {
+	return driver.generateSearchMatch(o)
+}
+
+// parameterKey includes the query so searches of one column for different
+// queries don't share a parameter
+func (o searchMatch) parameterKey(column mappedColumn) string {
+	hash := sha256.Sum256(fmt.Appendf(nil, "%#v", []any{column, o.Query}))
+
+	return fmt.Sprintf(":%s_search_%s", parameterKeyCleaner.ReplaceAllString(column.Column, "_"), hex.EncodeToString(hash[:6]))
+}
+
+func searchIndexName(table Table, column string) string {
+	return fmt.Sprintf("ix_%s_%s_search", table.Name, column)
+}
+
+// searchTerms splits the query into words so punctuation in user input can't
+// be mistaken for search syntax
+func searchTerms(query string) []string {
+	return strings.Fields(query)
+}
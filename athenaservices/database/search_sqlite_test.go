@@ -0,0 +1,81 @@
+//go:build sqlite_fts5
+
+package database_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func TestSearchSQLite(t *testing.T) {
+	service, err := database.New(database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())))
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{Post{}})
+	assert.NilError(t, err)
+
+	{ // Nothing changes on the second run
+		changes, err := service.AutoMigrate(t.Context(), []database.Entity{Post{}})
+		assert.NilError(t, err)
+		assert.Equal(t, changes, 0)
+	}
+
+	repo := database.NewRepository[int64, Post](service)
+	_, err = repo.Insert(t.Context(), Post{Title: "Gophers", Body: "a post about gophers"})
+	assert.NilError(t, err)
+	bestID, err := repo.Insert(t.Context(), Post{Title: "More gophers", Body: "gophers gophers and more gophers"})
+	assert.NilError(t, err)
+	updatedID, err := repo.Insert(t.Context(), Post{Title: "Unrelated", Body: "nothing to see"})
+	assert.NilError(t, err)
+
+	search := func(query string) []Post {
+		posts, err := repo.SelectMultiple(t.Context(),
+			database.WithAdditionalWhere(database.And(database.Match(&repo.T.Body, query))),
+			database.WithRelevance(&repo.T.Body, query),
+		)
+		assert.NilError(t, err)
+
+		return posts
+	}
+
+	{ // Matches are ordered by relevance
+		posts := search("gophers")
+		assert.Equal(t, len(posts), 2)
+		assert.Equal(t, posts[0].ID, bestID)
+	}
+
+	{ // Every word has to match and search syntax is treated as text
+		assert.Equal(t, len(search("gophers about")), 1)
+		assert.Equal(t, len(search(`"gophers" OR NEAR(`)), 0)
+		assert.Equal(t, len(search("")), 0)
+	}
+
+	{ // Searches of one column for different queries keep their own text
+		posts, err := repo.SelectMultiple(t.Context(),
+			database.WithAdditionalWhere(database.And(
+				database.Match(&repo.T.Body, "about"),
+				database.Match(&repo.T.Body, "more"),
+			)),
+			database.WithRelevance(&repo.T.Body, "gophers"),
+		)
+		assert.NilError(t, err)
+		assert.Equal(t, len(posts), 0)
+	}
+
+	{ // Updates and deletes are kept in sync
+		assert.NilError(t, repo.Update(t.Context(), Post{ID: updatedID, Title: "Related", Body: "gophers after all"}))
+		assert.Equal(t, len(search("gophers")), 3)
+
+		assert.NilError(t, repo.Delete(t.Context(), Post{ID: bestID}))
+		assert.Equal(t, len(search("gophers")), 2)
+	}
+
+	{ // The search table isn't reported as a table of its own
+		tables, err := service.Inspect(t.Context())
+		assert.NilError(t, err)
+		assert.Equal(t, len(tables), 1)
+	}
+}
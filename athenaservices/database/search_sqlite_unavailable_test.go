@@ -0,0 +1,19 @@
+//go:build !sqlite_fts5
+
+package database_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func TestSearchSQLiteUnavailable(t *testing.T) {
+	service, err := database.New(database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())))
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{Post{}})
+	assert.ErrorIs(t, err, database.ErrSearchUnavailable)
+}
@@ -0,0 +1,35 @@
+package database_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+type Post struct {
+	ID    int64  `db:"id,primaryKey,autoIncrement"`
+	Title string `db:"title,searchable"`
+	Body  string `db:"body,searchable"`
+}
+
+func (e Post) TableStructure() database.Table {
+	return database.Table{
+		Name: "post",
+	}
+}
+
+func TestSearchIndexes(t *testing.T) {
+	{ // Postgres
+		ddl, err := database.SchemaDDL(database.NewDriverPostgres(database.DriverPostgresConfig{}), []database.Entity{Post{}})
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(ddl, `CREATE INDEX "ix_post_body_search" ON "post" USING gin (to_tsvector('simple', "body"))`), ddl)
+	}
+
+	{ // MySQL
+		ddl, err := database.SchemaDDL(database.NewDriverMySQL(database.DriverMySQLConfig{}), []database.Entity{Post{}})
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(ddl, "FULLTEXT KEY `ix_post_body_search` (`body`)"), ddl)
+	}
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type Seeder func(ctx context.Context, service *Service) error
+
+func NewSeederRegistry() *SeederRegistry {
+	return &SeederRegistry{
+		names:   []string{},
+		seeders: map[string]Seeder{},
+	}
+}
+
+// SeederRegistry runs named seeders in the order they were registered. Each
+// seeder only ever runs once per database, the names of the seeders that
+// already ran are kept in the athena_seeder table.
+type SeederRegistry struct {
+	names   []string
+	seeders map[string]Seeder
+}
+
+func (registry *SeederRegistry) Register(name string, seeder Seeder) error {
+	if _, found := registry.seeders[name]; found {
+		return fmt.Errorf("duplicate seeder: %s", name)
+	}
+
+	registry.names = append(registry.names, name)
+	registry.seeders[name] = seeder
+
+	return nil
+}
+
+func (registry *SeederRegistry) Run(ctx context.Context, service *Service) (seedersRan int, err error) {
+	if _, err := service.AutoMigrate(ctx, []Entity{seederRecord{}}); err != nil {
+		return 0, err
+	}
+
+	baseQuery, err := generateBaseQuery(seederRecord{})
+	if err != nil {
+		return 0, err
+	}
+
+	selector := NewSelector[seederRecord](service, baseQuery)
+	records, err := selector.SelectMultiple(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	alreadyRan := map[string]bool{}
+	for _, record := range records {
+		alreadyRan[record.Name] = true
+	}
+
+	for _, name := range registry.names {
+		if alreadyRan[name] {
+			continue
+		}
+
+		if err := registry.seeders[name](ctx, service); err != nil {
+			return seedersRan, fmt.Errorf("seeder %s: %w", name, err)
+		}
+
+		if _, err := service.insert(ctx, seederRecord{Name: name}, false, false); err != nil {
+			return seedersRan, err
+		}
+
+		seedersRan++
+	}
+
+	return seedersRan, nil
+}
+
+type seederRecord struct {
+	ID    int64     `db:"id,primaryKey,autoIncrement"`
+	Name  string    `db:"name"`
+	RanAt time.Time `db:"ran_at,readOnly,default=CURRENT_TIMESTAMP"`
+}
+
+func (e seederRecord) TableStructure() Table {
+	return Table{
+		Name: "athena_seeder",
+		Indexes: []TableIndex{
+			{
+				Name:    "ux_athena_seeder_name",
+				Columns: []string{"name"},
+				Unique:  true,
+			},
+		},
+	}
+}
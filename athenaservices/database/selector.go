@@ -61,7 +61,7 @@ func (selector *Selector[T]) SelectMultiple(ctx context.Context, mods ...QueryMo
 		return nil, err
 	}
 
-	if err := selector.service.runSelect(ctx, statement, &target); err != nil {
+	if err := selector.service.runCachedSelect(ctx, query, statement, &target); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,48 @@
+package database_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func TestSelectOrderAndLimit(t *testing.T) {
+	service, err := database.New(database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())))
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{Company{}})
+	assert.NilError(t, err)
+
+	companyRepo := database.NewRepository[CompanyID, Company](service)
+	for _, name := range []string{"c", "a", "d", "b"} {
+		_, err := companyRepo.Insert(t.Context(), Company{String: name})
+		assert.NilError(t, err)
+	}
+
+	byName := func(query database.Query) database.Query {
+		query.OrderBy = "name"
+		return query
+	}
+
+	names := func(mods ...database.QueryModifier) []string {
+		companies, err := companyRepo.SelectMultiple(t.Context(), mods...)
+		assert.NilError(t, err)
+
+		names := []string{}
+		for _, company := range companies {
+			names = append(names, company.String)
+		}
+
+		return names
+	}
+
+	assert.DeepEqual(t, names(byName), []string{"a", "b", "c", "d"})
+	assert.DeepEqual(t, names(byName, database.WithLimitOverride(2, 1)), []string{"b", "c"})
+	assert.DeepEqual(t, names(database.WithLimitOverride(3, 0)), []string{"c", "a", "d"})
+
+	first, err := companyRepo.SelectSingle(t.Context(), byName)
+	assert.NilError(t, err)
+	assert.Equal(t, first.String, "a")
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"reflect"
 	"time"
 
@@ -16,7 +17,19 @@ type Service struct {
 	standardLibraryDB *sql.DB
 	preRunFuncs       []func(ctx context.Context, statement string, args []any) error
 	postRunFuncs      []func(ctx context.Context) error
-	mapping           map[uintptr]string
+	mapping           map[uintptr]mappedColumn
+	logger            *slog.Logger
+	queryCache        QueryCacheDriver
+	referencedBy      map[string][]string
+	tenantColumn      string
+	auditSink         AuditSink
+	auditActor        func(ctx context.Context) string
+}
+
+// mappedColumn is what a field pointer of a repository refers to
+type mappedColumn struct {
+	Table  string
+	Column string
 }
 
 func New(
@@ -33,7 +46,9 @@ func New(
 		standardLibraryDB: db,
 		preRunFuncs:       []func(ctx context.Context, statement string, args []any) error{},
 		postRunFuncs:      []func(ctx context.Context) error{},
-		mapping:           map[uintptr]string{},
+		mapping:           map[uintptr]mappedColumn{},
+		logger:            slog.Default(),
+		referencedBy:      map[string][]string{},
 	}
 
 	driver.setMapping(service.mapping)
@@ -71,7 +86,7 @@ func (service *Service) runSelect(
 		}
 	}
 
-	rows, err := service.standardLibraryDB.Query(preparedQuery, preparedArgs...)
+	rows, err := service.executor(ctx).Query(preparedQuery, preparedArgs...)
 	if err != nil {
 		return err
 	}
@@ -174,7 +189,7 @@ func (service *Service) runExecute(
 		}
 	}
 
-	result, err := service.standardLibraryDB.Exec(preparedQuery, preparedArgs...)
+	result, err := service.executor(ctx).Exec(preparedQuery, preparedArgs...)
 	if err != nil {
 		return nil, err
 	}
@@ -188,6 +203,54 @@ func (service *Service) runExecute(
 	return result, nil
 }
 
+func (service *Service) insert(ctx context.Context, entity Entity, withPrimaryKey bool, withReadOnly bool) (int64, error) {
+	statement, err := service.driver.generateInsert(entity, withPrimaryKey, withReadOnly)
+	if err != nil {
+		return 0, err
+	}
+
+	if !service.driver.usesLastInsertId() {
+		lastInsertID := []struct {
+			ID int64 `db:"id"`
+		}{}
+		if err := service.runSelect(ctx, statement, &lastInsertID); err != nil {
+			return 0, err
+		}
+
+		service.invalidateQueryCache(ctx, entity.TableStructure().Name)
+
+		return lastInsertID[0].ID, nil
+	}
+
+	result, err := service.runExecute(ctx, statement)
+	if err != nil {
+		return 0, err
+	}
+
+	service.invalidateQueryCache(ctx, entity.TableStructure().Name)
+
+	lastInsertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if lastInsertID == 0 {
+		if err := utils.LoopOverStructFields(reflect.ValueOf(entity), func(fieldDefinition reflect.StructField, fieldValue reflect.Value) error {
+			// Grab the primary key if it wasn't from an AUTO_INCREMENT
+			tag := utils.ParseTag(fieldDefinition.Tag)
+			if tag.PrimaryKey {
+				lastInsertID = fieldValue.Int()
+			}
+
+			return nil
+		}); err != nil {
+			return 0, nil
+		}
+	}
+
+	return lastInsertID, nil
+}
+
 func shouldBeJson(fieldDefinition reflect.StructField) bool {
 	// JSON encode slices
 	if fieldDefinition.Type.Kind() == reflect.Slice {
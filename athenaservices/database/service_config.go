@@ -36,6 +36,7 @@ func WithPostRunFunc(postRunFunc func(ctx context.Context) error) ServiceConfigF
 
 func WithLogger(logger *slog.Logger) ServiceConfigFunc {
 	return func(service *Service) error {
+		service.logger = logger
 		service.preRunFuncs = append(service.preRunFuncs, func(ctx context.Context, statement string, args []any) error {
 			logger.Info("Database Run",
 				"statement", statement,
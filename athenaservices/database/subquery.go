@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ColumnEqual compares two columns, columns of other tables can be used to
+// correlate a subquery with the query it is part of
+func ColumnEqual[T any](column *T, other *T) OperatorOfEvaluation {
+	return columnComparison{Left: column, Operator: "=", Right: other}
+}
+
+func ColumnNotEqual[T any](column *T, other *T) OperatorOfEvaluation {
+	return columnComparison{Left: column, Operator: "!=", Right: other}
+}
+
+func ColumnGreaterThan[T any](column *T, other *T) OperatorOfEvaluation {
+	return columnComparison{Left: column, Operator: ">", Right: other}
+}
+
+func ColumnGreaterThanOrEqual[T any](column *T, other *T) OperatorOfEvaluation {
+	return columnComparison{Left: column, Operator: ">=", Right: other}
+}
+
+func ColumnLessThan[T any](column *T, other *T) OperatorOfEvaluation {
+	return columnComparison{Left: column, Operator: "<", Right: other}
+}
+
+func ColumnLessThanOrEqual[T any](column *T, other *T) OperatorOfEvaluation {
+	return columnComparison{Left: column, Operator: "<=", Right: other}
+}
+
+type columnComparison struct {
+	Left     any
+	Operator string
+	Right    any
+}
+
+func (o columnComparison) haveDriverRender(driver Driver) (statement, error) {
+	return driver.generateColumnComparison(o)
+}
+
+// Subquery is a select used inside the where clause of another query
+type Subquery struct {
+	query  Query
+	column any
+}
+
+// Subquery selects the column (or every column when it is nil, which is
+// enough for Exists) of the rows matching the modifiers
+func (selector *Selector[T]) Subquery(column any, mods ...QueryModifier) Subquery {
+	query := selector.baseQuery
+	for _, mod := range mods {
+		query = mod(query)
+	}
+
+	return Subquery{
+		query:  query,
+		column: column,
+	}
+}
+
+// Subquery is like Selector.Subquery with the base modifiers and tenant scope
+// of the repository applied
+func (repository *Repository[ID, T]) Subquery(ctx context.Context, column any, mods ...QueryModifier) (Subquery, error) {
+	mods, err := repository.prependBaseModifiers(ctx, mods)
+	if err != nil {
+		return Subquery{}, err
+	}
+
+	return repository.selector.Subquery(column, mods...), nil
+}
+
+func InSubquery[T any](column *T, subquery Subquery) OperatorOfEvaluation {
+	return subqueryOperator{Column: column, Keyword: "IN", Subquery: subquery}
+}
+
+func NotInSubquery[T any](column *T, subquery Subquery) OperatorOfEvaluation {
+	return subqueryOperator{Column: column, Keyword: "NOT IN", Subquery: subquery}
+}
+
+func Exists(subquery Subquery) OperatorOfEvaluation {
+	return subqueryOperator{Keyword: "EXISTS", Subquery: subquery}
+}
+
+func NotExists(subquery Subquery) OperatorOfEvaluation {
+	return subqueryOperator{Keyword: "NOT EXISTS", Subquery: subquery}
+}
+
+type subqueryOperator struct {
+	Column   any
+	Keyword  string
+	Subquery Subquery
+}
+
+func (o subqueryOperator) haveDriverRender(driver Driver) (statement, error) {
+	return driver.generateSubquery(o)
+}
+
+// renderSubquery renders the operator with the column already quoted by the
+// driver, it is empty for EXISTS
+func renderSubquery(driver Driver, mapping map[uintptr]mappedColumn, o subqueryOperator, quotedColumn string) (statement, error) {
+	query := o.Subquery.query
+	if o.Subquery.column != nil {
+		column, err := lookupColumn(mapping, o.Subquery.column)
+		if err != nil {
+			return statement{}, err
+		}
+
+		query.Select = []string{column.Column}
+	}
+
+	// Caching only applies to the outer query
+	query.CacheTTL = 0
+
+	subquery, err := driver.generateSelect(query)
+	if err != nil {
+		return statement{}, err
+	}
+
+	if quotedColumn == "" {
+		subquery.Query = fmt.Sprintf("%s (%s)", o.Keyword, subquery.Query)
+	} else {
+		subquery.Query = fmt.Sprintf("%s %s (%s)", quotedColumn, o.Keyword, subquery.Query)
+	}
+
+	return subquery, nil
+}
+
+func lookupColumn(mapping map[uintptr]mappedColumn, column any) (mappedColumn, error) {
+	mapped, found := mapping[uintptr(reflect.ValueOf(column).UnsafePointer())]
+	if !found {
+		return mappedColumn{}, errors.New("unknown column")
+	}
+
+	return mapped, nil
+}
@@ -0,0 +1,80 @@
+package database_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func TestSubqueries(t *testing.T) {
+	service, err := database.New(database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())))
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{Company{}, UserV2{}})
+	assert.NilError(t, err)
+
+	companyRepo := database.NewRepository[CompanyID, Company](service)
+	userRepo := database.NewRepository[UserID, UserV2](service)
+
+	activeID, err := companyRepo.Insert(t.Context(), Company{String: "active", Bool: true})
+	assert.NilError(t, err)
+	inactiveID, err := companyRepo.Insert(t.Context(), Company{String: "inactive"})
+	assert.NilError(t, err)
+
+	activeUserID, err := userRepo.Insert(t.Context(), UserV2{Email: "a@example.com", NewForV2: "z", CompanyID: activeID})
+	assert.NilError(t, err)
+	inactiveUserID, err := userRepo.Insert(t.Context(), UserV2{Email: "b@example.com", NewForV2: "a", CompanyID: inactiveID})
+	assert.NilError(t, err)
+
+	find := func(operators ...database.OperatorOfEvaluation) []UserID {
+		users, err := userRepo.SelectMultiple(t.Context(), database.WithAdditionalWhere(database.And(operators...)))
+		assert.NilError(t, err)
+
+		ids := []UserID{}
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+
+		return ids
+	}
+
+	{ // IN
+		activeCompanies, err := companyRepo.Subquery(t.Context(), &companyRepo.T.ID, database.WithAdditionalWhere(database.And(
+			database.Equal(&companyRepo.T.Bool, true),
+		)))
+		assert.NilError(t, err)
+
+		assert.DeepEqual(t, find(database.InSubquery(&userRepo.T.CompanyID, activeCompanies)), []UserID{activeUserID})
+		assert.DeepEqual(t, find(database.NotInSubquery(&userRepo.T.CompanyID, activeCompanies)), []UserID{inactiveUserID})
+	}
+
+	{ // Correlated EXISTS with parameters of both queries on the same column name
+		activeCompany, err := companyRepo.Subquery(t.Context(), nil, database.WithAdditionalWhere(database.And(
+			database.ColumnEqual(&companyRepo.T.ID, &userRepo.T.CompanyID),
+			database.Equal(&companyRepo.T.Bool, true),
+			database.Like(&companyRepo.T.String, "act%"),
+		)))
+		assert.NilError(t, err)
+
+		assert.DeepEqual(t, find(database.Exists(activeCompany)), []UserID{activeUserID})
+		assert.DeepEqual(t, find(database.NotExists(activeCompany)), []UserID{inactiveUserID})
+		assert.DeepEqual(t, find(database.Exists(activeCompany), database.Like(&userRepo.T.Email, "b%")), []UserID{})
+	}
+
+	{ // Columns of the outer query keep their table inside the subquery
+		activeCompanyForUser, err := companyRepo.Subquery(t.Context(), nil, database.WithAdditionalWhere(database.And(
+			database.Equal(&companyRepo.T.Bool, true),
+			database.Equal(&userRepo.T.ID, inactiveUserID),
+		)))
+		assert.NilError(t, err)
+
+		assert.DeepEqual(t, find(database.Exists(activeCompanyForUser)), []UserID{inactiveUserID})
+	}
+
+	{ // Column to column
+		assert.DeepEqual(t, find(database.ColumnGreaterThan(&userRepo.T.NewForV2, &userRepo.T.Email)), []UserID{activeUserID})
+		assert.DeepEqual(t, find(database.ColumnLessThan(&userRepo.T.NewForV2, &userRepo.T.Email)), []UserID{inactiveUserID})
+	}
+}
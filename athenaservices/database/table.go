@@ -25,6 +25,9 @@ type Table struct {
 	Comment string
 	columns []TableColumn
 	Indexes []TableIndex
+	// searchColumns are the columns tagged searchable, kept out of the columns
+	// since the search index lives outside of them
+	searchColumns []string
 }
 
 type tableLookups struct {
@@ -116,6 +119,11 @@ func fieldToType(driver Driver, field reflect.StructField) (TableColumn, error)
 	}
 	column.Type = columnType
 
+	// Strings are limited to varchar(255) on MySQL otherwise
+	if tag.LongText && fieldType.Kind() == reflect.String {
+		column.Type = driver.convertTypeLongText()
+	}
+
 	return column, nil
 }
 
@@ -133,6 +141,14 @@ func (table *Table) hydrateColumns(driver Driver, entity Entity) error {
 			continue
 		}
 
+		if utils.ParseTag(field.Tag).Searchable {
+			if field.Type.Kind() != reflect.String {
+				return fmt.Errorf("searchable column %s must be a string", column.Name)
+			}
+
+			table.searchColumns = append(table.searchColumns, column.Name)
+		}
+
 		columns = append(columns, column)
 	}
 
@@ -173,6 +189,16 @@ type TableIndex struct {
 	Name    string
 	Columns []string
 	Unique  bool
+	// Descending lists the columns that are sorted in descending order
+	Descending []string
+	// Expressions are indexed after the columns, for example lower(email).
+	// Postgres needs anything but a function call wrapped in parentheses.
+	Expressions []string
+	// Where turns the index into a partial index, for example deleted_at IS NULL
+	Where string
+	// Method is the index method (btree, hash, gin, gist, brin...), btree is
+	// used when it is empty
+	Method string
 }
 
 type tableForeignKey struct {
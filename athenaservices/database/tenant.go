@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var ErrNoTenant = errors.New("no tenant in context")
+
+type tenantContextKey struct{}
+
+type tenantBypassContextKey struct{}
+
+// WithTenantScope scopes every repository whose entity has the column to the
+// tenant found in the context. Selects are filtered by it, inserts and
+// updates have it stamped on the entity, and updates and deletes only touch
+// rows of the tenant. Operations on scoped repositories fail with ErrNoTenant
+// when the context has no tenant.
+func WithTenantScope(column string) ServiceConfigFunc {
+	return func(service *Service) error {
+		service.tenantColumn = column
+		return nil
+	}
+}
+
+// ContextWithTenant scopes the repositories to the tenant, it has to be of the
+// type of the tenant fields
+func ContextWithTenant(ctx context.Context, tenant any) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// ContextWithoutTenantScope lets trusted code like background jobs and
+// seeders work across every tenant
+func ContextWithoutTenantScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantBypassContextKey{}, true)
+}
+
+func TenantFromContext(ctx context.Context) (any, bool) {
+	tenant := ctx.Value(tenantContextKey{})
+
+	return tenant, tenant != nil
+}
+
+// tenantValue returns the tenant as the type of the tenant field, scoped is
+// false when the repository isn't tenant scoped for this context. Tenants of
+// other types are refused rather than converted, a conversion could scope to
+// another tenant (an int turning into a one rune string, an int64 narrowed).
+func (repository *Repository[ID, T]) tenantValue(ctx context.Context) (value reflect.Value, scoped bool, err error) {
+	if repository.tenantFieldIndex < 0 {
+		return reflect.Value{}, false, nil
+	}
+
+	if bypass, _ := ctx.Value(tenantBypassContextKey{}).(bool); bypass {
+		return reflect.Value{}, false, nil
+	}
+
+	tenant, found := TenantFromContext(ctx)
+	if !found {
+		return reflect.Value{}, false, ErrNoTenant
+	}
+
+	fieldType := reflect.TypeFor[T]().Field(repository.tenantFieldIndex).Type
+	tenantValue := reflect.ValueOf(tenant)
+	if !tenantValue.Type().AssignableTo(fieldType) {
+		return reflect.Value{}, false, fmt.Errorf("tenant of type %s can not be used as %s", tenantValue.Type(), fieldType)
+	}
+
+	return tenantValue, true, nil
+}
+
+func (repository *Repository[ID, T]) tenantWhere(ctx context.Context) (OperatorOfLogic, error) {
+	value, scoped, err := repository.tenantValue(ctx)
+	if err != nil || !scoped {
+		return nil, err
+	}
+
+	return And(simpleOperatorOfEquality{
+		Column:   reflect.ValueOf(repository.T).Elem().Field(repository.tenantFieldIndex).Addr().Interface(),
+		Operator: "=",
+		Value:    value.Interface(),
+	}), nil
+}
+
+func (repository *Repository[ID, T]) tenantStamp(ctx context.Context, entity T) (T, error) {
+	value, scoped, err := repository.tenantValue(ctx)
+	if err != nil || !scoped {
+		return entity, err
+	}
+
+	reflect.ValueOf(&entity).Elem().Field(repository.tenantFieldIndex).Set(value)
+
+	return entity, nil
+}
@@ -0,0 +1,85 @@
+package database_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+func TestTenantScope(t *testing.T) {
+	service, err := database.New(
+		database.NewDriverSQLite(fmt.Sprintf("%s/database.sqlite", t.TempDir())),
+		database.WithTenantScope("company_id"),
+	)
+	assert.NilError(t, err)
+
+	_, err = service.AutoMigrate(t.Context(), []database.Entity{Company{}, UserV2{}})
+	assert.NilError(t, err)
+
+	// Company has no tenant column so it is never scoped
+	companyRepo := database.NewRepository[CompanyID, Company](service)
+	userRepo := database.NewRepository[UserID, UserV2](service)
+
+	companyA, err := companyRepo.Insert(t.Context(), Company{String: "a"})
+	assert.NilError(t, err)
+	companyB, err := companyRepo.Insert(t.Context(), Company{String: "b"})
+	assert.NilError(t, err)
+
+	ctxA := database.ContextWithTenant(t.Context(), companyA)
+	ctxB := database.ContextWithTenant(t.Context(), companyB)
+
+	{ // Tenants of another type are refused instead of converted
+		for _, tenant := range []any{int64(companyA), int(companyA), fmt.Sprint(companyA)} {
+			_, err := userRepo.SelectMultiple(database.ContextWithTenant(t.Context(), tenant))
+			assert.ErrorContains(t, err, "can not be used as")
+		}
+	}
+
+	{ // Fails closed without a tenant
+		_, err := userRepo.Insert(t.Context(), UserV2{Email: "nobody@example.com"})
+		assert.ErrorIs(t, err, database.ErrNoTenant)
+
+		_, err = userRepo.SelectMultiple(t.Context())
+		assert.ErrorIs(t, err, database.ErrNoTenant)
+
+		assert.ErrorIs(t, userRepo.Update(t.Context(), UserV2{ID: 1}), database.ErrNoTenant)
+		assert.ErrorIs(t, userRepo.Delete(t.Context(), UserV2{ID: 1}), database.ErrNoTenant)
+	}
+
+	// Inserts are stamped with the tenant, even when asking for another one
+	userA, err := userRepo.Insert(ctxA, UserV2{Email: "a@example.com", CompanyID: companyB})
+	assert.NilError(t, err)
+	userB, err := userRepo.Insert(ctxB, UserV2{Email: "b@example.com"})
+	assert.NilError(t, err)
+
+	{ // Selects only see the rows of the tenant
+		users, err := userRepo.SelectMultiple(ctxA)
+		assert.NilError(t, err)
+		assert.Equal(t, len(users), 1)
+		assert.Equal(t, users[0].ID, userA)
+		assert.Equal(t, users[0].CompanyID, companyA)
+
+		_, err = userRepo.SelectSingle(ctxA, database.WithAdditionalWhere(database.And(
+			database.Equal(&userRepo.T.ID, userB),
+		)))
+		assert.ErrorIs(t, err, database.ErrNoRows)
+	}
+
+	{ // Updates and deletes can't reach across tenants
+		assert.NilError(t, userRepo.Update(ctxA, UserV2{ID: userB, Email: "hijacked@example.com"}))
+		assert.NilError(t, userRepo.Delete(ctxA, UserV2{ID: userB}))
+
+		user, err := userRepo.SelectSingle(ctxB)
+		assert.NilError(t, err)
+		assert.Equal(t, user.ID, userB)
+		assert.Equal(t, user.Email, "b@example.com")
+	}
+
+	{ // Trusted code can opt out of the scope
+		users, err := userRepo.SelectMultiple(database.ContextWithoutTenantScope(t.Context()))
+		assert.NilError(t, err)
+		assert.Equal(t, len(users), 2)
+	}
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+)
+
+type transactionContextKey struct{}
+
+// transaction is the transaction a service runs the statements of a context
+// in, the query cache is only invalidated once it is committed
+type transaction struct {
+	service     *Service
+	tx          *sql.Tx
+	invalidated []string
+}
+
+// executor is what statements run on, the database or a transaction
+type executor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func (service *Service) currentTransaction(ctx context.Context) *transaction {
+	current, _ := ctx.Value(transactionContextKey{}).(*transaction)
+	if current == nil || current.service != service {
+		return nil
+	}
+
+	return current
+}
+
+func (service *Service) executor(ctx context.Context) executor {
+	if current := service.currentTransaction(ctx); current != nil {
+		return current.tx
+	}
+
+	return service.standardLibraryDB
+}
+
+// inTransaction runs the function with every statement of the service using
+// the context in one transaction, joining the transaction already running
+// for the context if there is one
+func (service *Service) inTransaction(ctx context.Context, run func(ctx context.Context) error) error {
+	if service.currentTransaction(ctx) != nil {
+		return run(ctx)
+	}
+
+	tx, err := service.standardLibraryDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	current := &transaction{
+		service: service,
+		tx:      tx,
+	}
+
+	if err := run(context.WithValue(ctx, transactionContextKey{}, current)); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, tableName := range current.invalidated {
+		service.invalidateQueryCache(ctx, tableName)
+	}
+
+	return nil
+}
+
+// deferInvalidation holds the invalidation back until the transaction of the
+// context commits, invalidating earlier lets a concurrent select cache the
+// rows from before it. It is false when there is no transaction.
+func (service *Service) deferInvalidation(ctx context.Context, tableName string) bool {
+	current := service.currentTransaction(ctx)
+	if current == nil {
+		return false
+	}
+
+	if !slices.Contains(current.invalidated, tableName) {
+		current.invalidated = append(current.invalidated, tableName)
+	}
+
+	return true
+}
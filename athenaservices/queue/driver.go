@@ -3,28 +3,282 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Driver interface {
+	// CreateQueue also creates the dead-letter queue of the queue
 	CreateQueue(ctx context.Context, queueName string) error
 	Publish(ctx context.Context, queueName string, payload []byte) error
-	Consume(ctx context.Context, queueName string, handler func(ctx context.Context, payload []byte) error) error
+	// Consume handles messages until the context is cancelled, then waits for
+	// the handlers in flight. Messages are acknowledged once the handler
+	// succeeds, failed ones are retried or dead lettered according to the
+	// options. Messages whose handler failed after the drain timeout cancelled
+	// it are handed back without counting the attempt.
+	Consume(ctx context.Context, queueName string, options ConsumeOptions, handler func(ctx context.Context, payload []byte) error) error
 }
 
-func NewQueue[T any](ctx context.Context, driver Driver, name string) (Queue[T], error) {
+const (
+	deadLetterSuffix = ".dead-letter"
+
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+	defaultDrainTimeout   = 30 * time.Second
+)
+
+// deadLetterName is the name of the queue messages end up in once they run
+// out of attempts
+func deadLetterName(queueName string) string {
+	return queueName + deadLetterSuffix
+}
+
+// ConsumeOptions control how messages are handled, zero values fall back to
+// the defaults
+type ConsumeOptions struct {
+	// Concurrency is how many messages are handled at the same time
+	Concurrency int
+	// MaxAttempts is how often a message is handled before it is dead
+	// lettered, or discarded when consuming a dead-letter queue
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, it doubles with
+	// every attempt up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// DrainTimeout is how long handlers in flight get to finish once consuming
+	// stops, their context is cancelled after it
+	DrainTimeout time.Duration
+}
+
+type settlement int
+
+const (
+	settleAck settlement = iota
+	settleRetry
+	settleDeadLetter
+	settleDiscard
+)
+
+// settle decides what happens to a message after the handler ran. Messages
+// running out of attempts on a dead-letter queue are discarded, there is
+// nowhere left to put them.
+func (options ConsumeOptions) settle(queueName string, attempt int, err error) settlement {
+	if err == nil {
+		return settleAck
+	}
+
+	if !errors.As(err, &rejectedError{}) && attempt < options.maxAttempts() {
+		return settleRetry
+	}
+
+	if strings.HasSuffix(queueName, deadLetterSuffix) {
+		return settleDiscard
+	}
+
+	return settleDeadLetter
+}
+
+func (options ConsumeOptions) maxAttempts() int {
+	if options.MaxAttempts <= 0 {
+		return defaultMaxAttempts
+	}
+
+	return options.MaxAttempts
+}
+
+// backoff is the delay before the attempt after the given one
+func (options ConsumeOptions) backoff(attempt int) time.Duration {
+	delay := options.InitialBackoff
+	if delay <= 0 {
+		delay = defaultInitialBackoff
+	}
+
+	maxDelay := options.MaxBackoff
+	if maxDelay <= 0 {
+		maxDelay = max(defaultMaxBackoff, delay)
+	}
+
+	for range attempt - 1 {
+		if delay >= maxDelay {
+			break
+		}
+		delay *= 2
+	}
+
+	return min(delay, maxDelay)
+}
+
+// Reject marks the error of a message that can never be handled, it is dead
+// lettered without being retried
+func Reject(err error) error {
+	return rejectedError{err: err}
+}
+
+type rejectedError struct {
+	err error
+}
+
+func (err rejectedError) Error() string {
+	return "rejected: " + err.err.Error()
+}
+
+func (err rejectedError) Unwrap() error {
+	return err.err
+}
+
+type attemptContextKey struct{}
+
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptContextKey{}, attempt)
+}
+
+// Attempt is how often the message being handled has been handled, starting
+// at 1
+func Attempt(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptContextKey{}).(int); ok {
+		return attempt
+	}
+
+	return 1
+}
+
+var errDeliveriesClosed = errors.New("queue deliveries closed")
+
+// consume hands the messages to as many workers as the options allow until the
+// context is done or a worker fails. Handlers in flight get the drain timeout
+// to finish before their context is cancelled too.
+func consume[M any](ctx context.Context, options ConsumeOptions, messages <-chan M, handle func(ctx context.Context, message M) error) error {
+	drainTimeout := options.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	drained := make(chan struct{})
+	defer close(drained)
+
+	go func() {
+		select {
+		case <-drained:
+			return
+		case <-ctx.Done():
+		}
+
+		select {
+		case <-drained:
+		case <-time.After(drainTimeout):
+			cancelHandlers()
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for range max(options.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case message, ok := <-messages:
+					if !ok {
+						cancel(errDeliveriesClosed)
+						return
+					}
+
+					if err := handle(handlerCtx, message); err != nil {
+						cancel(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// Stopping because the context is done isn't a failure
+	if err := context.Cause(ctx); !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	return nil
+}
+
+type ConfigFunc func(options *ConsumeOptions)
+
+// WithConcurrency handles up to concurrency messages at the same time, for
+// RabbitMQ that is also how many messages are prefetched
+func WithConcurrency(concurrency int) ConfigFunc {
+	return func(options *ConsumeOptions) {
+		options.Concurrency = concurrency
+	}
+}
+
+func WithMaxAttempts(maxAttempts int) ConfigFunc {
+	return func(options *ConsumeOptions) {
+		options.MaxAttempts = maxAttempts
+	}
+}
+
+// WithDrainTimeout gives handlers in flight up to timeout to finish once
+// consuming stops, then cancels their context
+func WithDrainTimeout(timeout time.Duration) ConfigFunc {
+	return func(options *ConsumeOptions) {
+		options.DrainTimeout = timeout
+	}
+}
+
+// WithBackoff waits initial before the first retry, doubling the delay with
+// every attempt up to maximum
+func WithBackoff(initial time.Duration, maximum time.Duration) ConfigFunc {
+	return func(options *ConsumeOptions) {
+		options.InitialBackoff = initial
+		options.MaxBackoff = maximum
+	}
+}
+
+func NewQueue[T any](ctx context.Context, driver Driver, name string, configFuncs ...ConfigFunc) (Queue[T], error) {
 	if err := driver.CreateQueue(ctx, name); err != nil {
 		return Queue[T]{}, err
 	}
 
+	options := ConsumeOptions{
+		Concurrency:    1,
+		MaxAttempts:    defaultMaxAttempts,
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+		DrainTimeout:   defaultDrainTimeout,
+	}
+
+	for _, configFunc := range configFuncs {
+		configFunc(&options)
+	}
+
 	return Queue[T]{
-		driver: driver,
-		name:   name,
+		driver:  driver,
+		name:    name,
+		options: options,
 	}, nil
 }
 
 type Queue[T any] struct {
-	driver Driver
-	name   string
+	driver  Driver
+	name    string
+	options ConsumeOptions
+}
+
+func (q Queue[T]) Name() string {
+	return q.name
 }
 
 func (q Queue[T]) Publish(ctx context.Context, message T) error {
@@ -36,15 +290,28 @@ func (q Queue[T]) Publish(ctx context.Context, message T) error {
 	return q.driver.Publish(ctx, q.name, payload)
 }
 
+// Consume handles messages until the context is cancelled and the handlers in
+// flight are done, it only returns an error when the driver fails
 func (q Queue[T]) Consume(ctx context.Context, handler Handler[T]) error {
-	return q.driver.Consume(ctx, q.name, func(ctx context.Context, payload []byte) error {
+	return q.driver.Consume(ctx, q.name, q.options, func(ctx context.Context, payload []byte) error {
 		target := *new(T)
 		if err := json.Unmarshal(payload, &target); err != nil {
-			return err
+			// Retrying won't make it any more valid
+			return Reject(err)
 		}
 
 		return handler(ctx, target)
 	})
 }
 
+// DeadLetter is the queue messages of this queue end up in once they run out
+// of attempts, for inspecting or replaying them
+func (q Queue[T]) DeadLetter() Queue[T] {
+	return Queue[T]{
+		driver:  q.driver,
+		name:    deadLetterName(q.name),
+		options: q.options,
+	}
+}
+
 type Handler[T any] func(ctx context.Context, payload T) error
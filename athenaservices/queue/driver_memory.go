@@ -3,33 +3,134 @@ package queue
 import (
 	"context"
 	"errors"
+	"sync"
+	"time"
 )
 
 func NewDriverMemory() (Driver, error) {
 	return &driverMemory{
-		queues: map[string]chan []byte{},
+		queues: map[string]*memoryQueue{},
 	}, nil
 }
 
 type driverMemory struct {
-	queues map[string]chan []byte
+	mutex  sync.Mutex
+	queues map[string]*memoryQueue
+}
+
+type memoryMessage struct {
+	payload []byte
+	attempt int
+}
+
+// memoryQueue holds the messages until a consumer takes them, adding one
+// never waits for a consumer
+type memoryQueue struct {
+	mutex    sync.Mutex
+	messages []memoryMessage
+	// ready wakes a waiting consumer once messages are added
+	ready chan struct{}
+}
+
+func newMemoryQueue() *memoryQueue {
+	return &memoryQueue{
+		ready: make(chan struct{}, 1),
+	}
+}
+
+func (queue *memoryQueue) push(message memoryMessage) {
+	queue.mutex.Lock()
+	queue.messages = append(queue.messages, message)
+	queue.mutex.Unlock()
+
+	queue.signal()
+}
+
+// unshift puts a message taken but not handled back in front
+func (queue *memoryQueue) unshift(message memoryMessage) {
+	queue.mutex.Lock()
+	queue.messages = append([]memoryMessage{message}, queue.messages...)
+	queue.mutex.Unlock()
+
+	queue.signal()
+}
+
+func (queue *memoryQueue) signal() {
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (queue *memoryQueue) shift() (memoryMessage, bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if len(queue.messages) == 0 {
+		return memoryMessage{}, false
+	}
+
+	message := queue.messages[0]
+	queue.messages = queue.messages[1:]
+
+	return message, true
+}
+
+// deliver hands the messages to the channel until the context is done
+func (queue *memoryQueue) deliver(ctx context.Context, messages chan<- memoryMessage) {
+	for {
+		message, found := queue.shift()
+		if !found {
+			select {
+			case <-ctx.Done():
+				return
+			case <-queue.ready:
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			queue.unshift(message)
+			return
+		case messages <- message:
+		}
+	}
 }
 
 func (driver *driverMemory) CreateQueue(ctx context.Context, queueName string) error {
-	driver.queues[queueName] = make(chan []byte)
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+
+	for _, name := range []string{queueName, deadLetterName(queueName)} {
+		if _, found := driver.queues[name]; !found {
+			driver.queues[name] = newMemoryQueue()
+		}
+	}
+
 	return nil
 }
 
-func (driver *driverMemory) Publish(ctx context.Context, queueName string, payload []byte) error {
+func (driver *driverMemory) queue(queueName string) (*memoryQueue, error) {
+	driver.mutex.Lock()
+	defer driver.mutex.Unlock()
+
 	// make sure queue exists
 	queue, found := driver.queues[queueName]
 	if !found {
-		return errors.New("queue does not exist")
+		return nil, errors.New("queue does not exist")
 	}
 
-	go func() {
-		queue <- payload
-	}()
+	return queue, nil
+}
+
+func (driver *driverMemory) Publish(ctx context.Context, queueName string, payload []byte) error {
+	queue, err := driver.queue(queueName)
+	if err != nil {
+		return err
+	}
+
+	queue.push(memoryMessage{payload: payload, attempt: 1})
 
 	return nil
 }
@@ -37,17 +138,48 @@ func (driver *driverMemory) Publish(ctx context.Context, queueName string, paylo
 func (driver *driverMemory) Consume(
 	ctx context.Context,
 	queueName string,
+	options ConsumeOptions,
 	handler func(ctx context.Context, payload []byte) error,
 ) error {
-	queue, found := driver.queues[queueName]
-	if !found {
-		return errors.New("queue does not exist")
+	queue, err := driver.queue(queueName)
+	if err != nil {
+		return err
 	}
 
-	for item := range queue {
-		if err := handler(ctx, item); err != nil {
-			return err
-		}
+	deliverCtx, stopDelivering := context.WithCancel(ctx)
+	defer stopDelivering()
+
+	messages := make(chan memoryMessage)
+	go queue.deliver(deliverCtx, messages)
+
+	return consume(deliverCtx, options, messages, func(ctx context.Context, message memoryMessage) error {
+		return driver.handle(ctx, queueName, queue, options, message, handler)
+	})
+}
+
+func (driver *driverMemory) handle(
+	ctx context.Context,
+	queueName string,
+	queue *memoryQueue,
+	options ConsumeOptions,
+	message memoryMessage,
+	handler func(ctx context.Context, payload []byte) error,
+) error {
+	err := handler(withAttempt(ctx, message.attempt), message.payload)
+
+	// The handler was cut short by the drain timeout, the attempt doesn't count
+	if err != nil && ctx.Err() != nil {
+		queue.unshift(message)
+		return nil
+	}
+
+	switch options.settle(queueName, message.attempt, err) {
+	case settleRetry:
+		time.AfterFunc(options.backoff(message.attempt), func() {
+			queue.push(memoryMessage{payload: message.payload, attempt: message.attempt + 1})
+		})
+	case settleDeadLetter:
+		return driver.Publish(ctx, deadLetterName(queueName), message.payload)
 	}
 
 	return nil
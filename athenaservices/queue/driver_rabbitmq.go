@@ -2,11 +2,19 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+const (
+	attemptHeader = "x-athena-attempt"
+	errorHeader   = "x-athena-error"
+)
+
 type DriverRabbitMQConfig struct {
 	Host string
 	Pass string
@@ -34,43 +42,99 @@ type driverRabbitMQ struct {
 	channel *amqp.Channel
 }
 
+// CreateQueue declares the queue and its dead-letter queue, the retry queues
+// depend on the backoff and are declared when consuming
 func (driver *driverRabbitMQ) CreateQueue(ctx context.Context, queueName string) error {
+	for _, name := range []string{queueName, deadLetterName(queueName)} {
+		if err := driver.declare(name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// declareRetryQueues declares a retry queue for every step of the backoff.
+// Retried messages wait in it until the ttl of the queue passes, then
+// RabbitMQ dead letters them back into the queue. With a single ttl per
+// queue a message never waits behind one with a longer delay.
+func (driver *driverRabbitMQ) declareRetryQueues(queueName string, options ConsumeOptions) error {
+	for attempt := 1; attempt < options.maxAttempts(); attempt++ {
+		delay := options.backoff(attempt)
+		if err := driver.declare(retryQueueName(queueName, delay), amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queueName,
+		}); err != nil {
+			return err
+		}
+
+		// Every later attempt waits just as long
+		if delay == options.backoff(attempt+1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func retryQueueName(queueName string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%d", queueName, delay.Milliseconds())
+}
+
+func (driver *driverRabbitMQ) declare(queueName string, arguments amqp.Table) error {
 	_, err := driver.channel.QueueDeclare(
 		queueName, // name
 		false,     // durable
 		false,     // delete when unused
 		false,     // exclusive
 		false,     // no-wait
-		nil,       // arguments
+		arguments, // arguments
 	)
 
 	return err
 }
 
 func (driver *driverRabbitMQ) Publish(ctx context.Context, queueName string, payload []byte) error {
+	return driver.publish(ctx, queueName, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        payload,
+	})
+}
+
+func (driver *driverRabbitMQ) publish(ctx context.Context, queueName string, publishing amqp.Publishing) error {
 	return driver.channel.PublishWithContext(
 		ctx,
 		"",        // exchange
 		queueName, // routing key
 		true,      // mandatory
 		false,     // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        payload,
-		},
+		publishing,
 	)
 }
 
 func (driver *driverRabbitMQ) Consume(
 	ctx context.Context,
 	queueName string,
+	options ConsumeOptions,
 	handler func(ctx context.Context, payload []byte) error,
 ) error {
+	// Only hand this consumer as many messages as it handles at once so the
+	// rest stay available to other workers
+	if err := driver.channel.Qos(max(options.Concurrency, 1), 0, false); err != nil {
+		return err
+	}
+
+	if err := driver.declareRetryQueues(queueName, options); err != nil {
+		return err
+	}
+
+	consumer := uuid.NewString()
 
 	msgs, err := driver.channel.Consume(
 		queueName, // queue
-		"",        // consumer
-		true,      // auto-ack
+		consumer,  // consumer
+		false,     // auto-ack
 		false,     // exclusive
 		false,     // no-local
 		false,     // no-wait
@@ -80,11 +144,66 @@ func (driver *driverRabbitMQ) Consume(
 		return err
 	}
 
+	consumeErr := consume(ctx, options, msgs, func(ctx context.Context, d amqp.Delivery) error {
+		return driver.handle(ctx, queueName, options, d, handler)
+	})
+
+	if err := driver.channel.Cancel(consumer, false); err != nil {
+		return errors.Join(consumeErr, err)
+	}
+
+	// Hand back what was delivered but not handled yet
 	for d := range msgs {
-		if err := handler(ctx, d.Body); err != nil {
+		_ = d.Nack(false, true)
+	}
+
+	return consumeErr
+}
+
+// handle settles the delivery once the handler ran. The delivery is only
+// acknowledged after its retry or dead letter is published so a failing
+// worker never loses it.
+func (driver *driverRabbitMQ) handle(
+	ctx context.Context,
+	queueName string,
+	options ConsumeOptions,
+	d amqp.Delivery,
+	handler func(ctx context.Context, payload []byte) error,
+) error {
+	attempt := 1
+	if value, ok := d.Headers[attemptHeader].(int32); ok {
+		attempt = int(value)
+	}
+
+	handlerErr := handler(withAttempt(ctx, attempt), d.Body)
+
+	// The handler was cut short by the drain timeout, the attempt doesn't count
+	if handlerErr != nil && ctx.Err() != nil {
+		return d.Nack(false, true)
+	}
+
+	switch options.settle(queueName, attempt, handlerErr) {
+	case settleRetry:
+		if err := driver.publish(ctx, retryQueueName(queueName, options.backoff(attempt)), amqp.Publishing{
+			ContentType: d.ContentType,
+			Body:        d.Body,
+			Headers: amqp.Table{
+				attemptHeader: int32(attempt + 1),
+			},
+		}); err != nil {
+			return err
+		}
+	case settleDeadLetter:
+		if err := driver.publish(ctx, deadLetterName(queueName), amqp.Publishing{
+			ContentType: d.ContentType,
+			Body:        d.Body,
+			Headers: amqp.Table{
+				errorHeader: handlerErr.Error(),
+			},
+		}); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return d.Ack(false)
 }
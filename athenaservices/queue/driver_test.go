@@ -3,27 +3,258 @@ package queue_test
 import (
 	"context"
 	"errors"
+	"slices"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/lunagic/athena/athenaservices/queue"
 	"gotest.tools/v3/assert"
 )
 
+func receive[T any](t *testing.T, c <-chan T) T {
+	t.Helper()
+
+	select {
+	case value := <-c:
+		return value
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the queue")
+		return *new(T)
+	}
+}
+
 func testSuite(t *testing.T, driver queue.Driver) {
-	queueThing, err := queue.NewQueue[string](t.Context(), driver, uuid.NewString())
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	queueThing, err := queue.NewQueue[string](
+		ctx,
+		driver,
+		uuid.NewString(),
+		queue.WithMaxAttempts(3),
+		queue.WithBackoff(10*time.Millisecond, 50*time.Millisecond),
+	)
 	assert.NilError(t, err)
 
-	publishErr := queueThing.Publish(t.Context(), uuid.NewString())
-	assert.NilError(t, publishErr)
+	succeeded := make(chan int, 1)
+	consumeErr := make(chan error, 1)
+	go func() {
+		consumeErr <- queueThing.Consume(ctx, func(ctx context.Context, payload string) error {
+			switch payload {
+			case "flaky":
+				if queue.Attempt(ctx) < 3 {
+					return errors.New("not yet")
+				}
+
+				succeeded <- queue.Attempt(ctx)
+				return nil
+			case "invalid":
+				return queue.Reject(errors.New("invalid"))
+			}
+
+			return errors.New(uuid.NewString())
+		})
+	}()
 
-	expectedError := errors.New(uuid.NewString())
+	deadLetters := make(chan string, 2)
+	go func() {
+		_ = queueThing.DeadLetter().Consume(ctx, func(ctx context.Context, payload string) error {
+			deadLetters <- payload
+			return nil
+		})
+	}()
 
-	consumeErr := queueThing.Consume(
+	// A failing handler doesn't stop the consumer
+	for _, payload := range []string{"poison", "invalid", "flaky"} {
+		assert.NilError(t, queueThing.Publish(ctx, payload))
+	}
+
+	assert.Equal(t, receive(t, succeeded), 3)
+
+	received := []string{receive(t, deadLetters), receive(t, deadLetters)}
+	slices.Sort(received)
+	assert.DeepEqual(t, received, []string{"invalid", "poison"})
+
+	cancel()
+	assert.NilError(t, receive(t, consumeErr))
+
+	testDeadLetterAttempts(t, driver)
+	testConcurrency(t, driver)
+	testDrainTimeout(t, driver)
+	testRetryAfterStop(t, driver)
+}
+
+func testDrainTimeout(t *testing.T, driver queue.Driver) {
+	queueThing, err := queue.NewQueue[string](
 		t.Context(),
-		func(ctx context.Context, payload string) error {
-			return expectedError
-		},
+		driver,
+		uuid.NewString(),
+		queue.WithDrainTimeout(50*time.Millisecond),
+	)
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	started := make(chan struct{}, 1)
+	consumeErr := make(chan error, 1)
+	go func() {
+		consumeErr <- queueThing.Consume(ctx, func(ctx context.Context, payload string) error {
+			started <- struct{}{}
+			<-ctx.Done()
+
+			return ctx.Err()
+		})
+	}()
+
+	assert.NilError(t, queueThing.Publish(t.Context(), "stuck"))
+	receive(t, started)
+
+	// Handlers that don't finish in time see their context cancelled
+	cancel()
+	assert.NilError(t, receive(t, consumeErr))
+
+	// The message cut short is handed back without using up an attempt
+	ctx, cancel = context.WithCancel(t.Context())
+	defer cancel()
+
+	attempts := make(chan int, 1)
+	go func() {
+		_ = queueThing.Consume(ctx, func(ctx context.Context, payload string) error {
+			attempts <- queue.Attempt(ctx)
+			return nil
+		})
+	}()
+
+	assert.Equal(t, receive(t, attempts), 1)
+}
+
+func testRetryAfterStop(t *testing.T, driver queue.Driver) {
+	queueThing, err := queue.NewQueue[string](
+		t.Context(),
+		driver,
+		uuid.NewString(),
+		queue.WithBackoff(50*time.Millisecond, 50*time.Millisecond),
+	)
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	failed := make(chan struct{}, 1)
+	consumeErr := make(chan error, 1)
+	go func() {
+		consumeErr <- queueThing.Consume(ctx, func(ctx context.Context, payload string) error {
+			failed <- struct{}{}
+			return errors.New("not yet")
+		})
+	}()
+
+	assert.NilError(t, queueThing.Publish(t.Context(), "retried"))
+	receive(t, failed)
+
+	// The retry comes due while nobody consumes, the next consumer gets it
+	cancel()
+	assert.NilError(t, receive(t, consumeErr))
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel = context.WithCancel(t.Context())
+	defer cancel()
+
+	attempts := make(chan int, 1)
+	go func() {
+		_ = queueThing.Consume(ctx, func(ctx context.Context, payload string) error {
+			attempts <- queue.Attempt(ctx)
+			return nil
+		})
+	}()
+
+	assert.Equal(t, receive(t, attempts), 2)
+}
+
+func testDeadLetterAttempts(t *testing.T, driver queue.Driver) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	queueThing, err := queue.NewQueue[string](
+		ctx,
+		driver,
+		uuid.NewString(),
+		queue.WithMaxAttempts(2),
+		queue.WithBackoff(10*time.Millisecond, 10*time.Millisecond),
 	)
-	assert.Equal(t, expectedError, consumeErr)
+	assert.NilError(t, err)
+
+	attempts := make(chan int, 10)
+	go func() {
+		_ = queueThing.DeadLetter().Consume(ctx, func(ctx context.Context, payload string) error {
+			attempts <- queue.Attempt(ctx)
+			return errors.New("still failing")
+		})
+	}()
+
+	assert.NilError(t, queueThing.Publish(ctx, "invalid"))
+	go func() {
+		_ = queueThing.Consume(ctx, func(ctx context.Context, payload string) error {
+			return queue.Reject(errors.New("invalid"))
+		})
+	}()
+
+	// Dead-letter queues give up on a message once it runs out of attempts
+	assert.Equal(t, receive(t, attempts), 1)
+	assert.Equal(t, receive(t, attempts), 2)
+
+	select {
+	case attempt := <-attempts:
+		t.Fatalf("dead letter handled again on attempt %d", attempt)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func testConcurrency(t *testing.T, driver queue.Driver) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	queueThing, err := queue.NewQueue[int](ctx, driver, uuid.NewString(), queue.WithConcurrency(3))
+	assert.NilError(t, err)
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	finished := atomic.Int64{}
+
+	consumeErr := make(chan error, 1)
+	go func() {
+		consumeErr <- queueThing.Consume(ctx, func(ctx context.Context, payload int) error {
+			started <- struct{}{}
+			<-release
+
+			// Handlers in flight aren't cut short by the shutdown
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			finished.Add(1)
+			return nil
+		})
+	}()
+
+	for i := range 3 {
+		assert.NilError(t, queueThing.Publish(ctx, i))
+	}
+
+	// All messages are handled at the same time
+	for range 3 {
+		receive(t, started)
+	}
+
+	cancel()
+
+	// Consuming waits for the handlers in flight
+	select {
+	case err := <-consumeErr:
+		t.Fatalf("consume returned before the handlers finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NilError(t, receive(t, consumeErr))
+	assert.Equal(t, finished.Load(), int64(3))
 }
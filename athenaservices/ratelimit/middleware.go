@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lunagic/poseidon/poseidon"
+)
+
+// KeyFunc picks what requests are counted by, requests it returns an empty
+// key for aren't limited
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP counts requests by the address of the client. Behind a proxy that
+// is the proxy unless RemoteAddr is rewritten before this middleware runs.
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Middleware rejects requests over the limit with 429 Too Many Requests and
+// reports the state of the limit in RateLimit-* headers. Requests are let
+// through when the cache can't be reached so an outage of the cache doesn't
+// take the API down with it, the error is logged.
+func Middleware(limiter *Limiter, keyFunc KeyFunc) poseidon.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFunc(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := limiter.Allow(r.Context(), key)
+			if err != nil {
+				limiter.logger.ErrorContext(r.Context(), "Rate Limit Failed", "key", key, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+			w.Header().Set("RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+			w.Header().Set("RateLimit-Reset", headerSeconds(result.ResetAfter))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", headerSeconds(result.RetryAfter))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// headerSeconds rounds up so clients never retry too early
+func headerSeconds(duration time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(duration.Seconds())), 10)
+}
@@ -0,0 +1,195 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/cache"
+)
+
+var ErrInvalidWindow = errors.New("rate limit window must be positive")
+
+type Algorithm int
+
+const (
+	// AlgorithmFixedWindow counts requests per window, bursts of up to twice
+	// the limit can pass around the start of a window
+	AlgorithmFixedWindow Algorithm = iota
+	// AlgorithmSlidingWindow weighs the previous window by how much of it is
+	// still within the last window length, smoothing out those bursts
+	AlgorithmSlidingWindow
+)
+
+// Result describes the state of the limit after a request
+type Result struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// ResetAfter is when the current window ends
+	ResetAfter time.Duration
+	// RetryAfter is how long to wait before a request is allowed again, zero
+	// for allowed requests
+	RetryAfter time.Duration
+}
+
+type ConfigFunc func(limiter *Limiter)
+
+func WithAlgorithm(algorithm Algorithm) ConfigFunc {
+	return func(limiter *Limiter) {
+		limiter.algorithm = algorithm
+	}
+}
+
+// WithPrefix namespaces the counters so limiters sharing a driver don't
+// interfere
+func WithPrefix(prefix string) ConfigFunc {
+	return func(limiter *Limiter) {
+		limiter.prefix = prefix
+	}
+}
+
+// WithLogger sets where the middleware reports cache errors, defaults to
+// slog.Default()
+func WithLogger(logger *slog.Logger) ConfigFunc {
+	return func(limiter *Limiter) {
+		limiter.logger = logger
+	}
+}
+
+// New allows limit requests per window for every key. The counters are kept
+// in the driver so every instance using it shares them.
+func New(driver cache.Driver, limit int64, window time.Duration, configFuncs ...ConfigFunc) (*Limiter, error) {
+	if window <= 0 {
+		return nil, ErrInvalidWindow
+	}
+
+	limiter := &Limiter{
+		driver:    driver,
+		limit:     limit,
+		window:    window,
+		algorithm: AlgorithmFixedWindow,
+		prefix:    "athena-ratelimit",
+		logger:    slog.Default(),
+	}
+
+	for _, configFunc := range configFuncs {
+		configFunc(limiter)
+	}
+
+	return limiter, nil
+}
+
+type Limiter struct {
+	driver    cache.Driver
+	limit     int64
+	window    time.Duration
+	algorithm Algorithm
+	prefix    string
+	logger    *slog.Logger
+}
+
+// Allow counts a request for the key and reports whether it is within the
+// limit
+func (limiter *Limiter) Allow(ctx context.Context, key string) (Result, error) {
+	now := time.Now()
+	windowIndex := now.UnixNano() / limiter.window.Nanoseconds()
+	windowEnd := time.Unix(0, (windowIndex+1)*limiter.window.Nanoseconds())
+
+	if limiter.algorithm == AlgorithmSlidingWindow {
+		return limiter.allowSliding(ctx, key, windowIndex, windowEnd, now)
+	}
+
+	return limiter.allowFixed(ctx, key, windowIndex, windowEnd, now)
+}
+
+func (limiter *Limiter) allowFixed(ctx context.Context, key string, windowIndex int64, windowEnd time.Time, now time.Time) (Result, error) {
+	counterKey := limiter.counterKey(key, windowIndex)
+	count, err := limiter.increment(ctx, counterKey, limiter.window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{
+		Allowed:    count <= limiter.limit,
+		Limit:      limiter.limit,
+		Remaining:  max(limiter.limit-count, 0),
+		ResetAfter: windowEnd.Sub(now),
+	}
+
+	if !result.Allowed {
+		result.RetryAfter = result.ResetAfter
+	}
+
+	return result, nil
+}
+
+func (limiter *Limiter) allowSliding(ctx context.Context, key string, windowIndex int64, windowEnd time.Time, now time.Time) (Result, error) {
+	currentKey := limiter.counterKey(key, windowIndex)
+	previousKey := limiter.counterKey(key, windowIndex-1)
+
+	// The current window is still weighed in during the next one
+	count, err := limiter.increment(ctx, currentKey, 2*limiter.window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	previous := int64(0)
+	if value, err := limiter.driver.Get(ctx, previousKey); err == nil {
+		previous, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return Result{}, cache.ErrNotInteger
+		}
+	} else if !errors.Is(err, cache.ErrNotFound) {
+		return Result{}, err
+	}
+
+	remainingWindow := windowEnd.Sub(now)
+	weight := float64(remainingWindow) / float64(limiter.window)
+	estimate := int64(math.Floor(float64(previous)*weight)) + count
+
+	result := Result{
+		Allowed:    estimate <= limiter.limit,
+		Limit:      limiter.limit,
+		Remaining:  max(limiter.limit-estimate, 0),
+		ResetAfter: remainingWindow,
+	}
+
+	if result.Allowed {
+		return result, nil
+	}
+
+	// Take the denied request back out so it doesn't hold back later ones
+	if _, err := limiter.driver.Decrement(ctx, currentKey, 1); err != nil {
+		return Result{}, err
+	}
+	count--
+
+	if count >= limiter.limit {
+		// Nothing fits before this window becomes the previous one, then its
+		// weight has to drop below the limit
+		result.RetryAfter = remainingWindow + time.Duration((1-float64(limiter.limit)/float64(count))*float64(limiter.window))
+
+		return result, nil
+	}
+
+	// The weight of the previous window has to drop until a request fits
+	fitsAt := time.Duration(float64(limiter.limit-count) / float64(previous) * float64(limiter.window))
+	result.RetryAfter = max(remainingWindow-fitsAt, time.Millisecond)
+
+	return result, nil
+}
+
+// increment counts the request, the counter gets its expiry in the same step
+// so a failure in between can't leave it counting forever
+func (limiter *Limiter) increment(ctx context.Context, counterKey string, ttl time.Duration) (int64, error) {
+	return limiter.driver.IncrementWithExpiry(ctx, counterKey, 1, ttl)
+}
+
+func (limiter *Limiter) counterKey(key string, windowIndex int64) string {
+	return fmt.Sprintf("%s:%s:%d", limiter.prefix, key, windowIndex)
+}
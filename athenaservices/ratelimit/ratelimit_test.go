@@ -0,0 +1,189 @@
+package ratelimit_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunagic/athena/athenaservices/cache"
+	"github.com/lunagic/athena/athenaservices/ratelimit"
+	"gotest.tools/v3/assert"
+)
+
+// untilNextWindow waits for a new window to start so the test isn't split
+// across two windows
+func untilNextWindow(window time.Duration) {
+	time.Sleep(time.Until(time.Now().Truncate(window).Add(window)) + 10*time.Millisecond)
+}
+
+func TestFixedWindow(t *testing.T) {
+	t.Parallel()
+
+	driver, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	limiter, err := ratelimit.New(driver, 3, time.Second, ratelimit.WithPrefix("fixed"))
+	assert.NilError(t, err)
+	untilNextWindow(time.Second)
+
+	for i := range 3 {
+		result, err := limiter.Allow(t.Context(), "user-1")
+		assert.NilError(t, err)
+		assert.Assert(t, result.Allowed)
+		assert.Equal(t, result.Remaining, int64(2-i))
+	}
+
+	result, err := limiter.Allow(t.Context(), "user-1")
+	assert.NilError(t, err)
+	assert.Assert(t, !result.Allowed)
+	assert.Assert(t, result.RetryAfter > 0 && result.RetryAfter <= time.Second)
+
+	// Keys are counted separately
+	result, err = limiter.Allow(t.Context(), "user-2")
+	assert.NilError(t, err)
+	assert.Assert(t, result.Allowed)
+
+	// The next window starts over
+	untilNextWindow(time.Second)
+	result, err = limiter.Allow(t.Context(), "user-1")
+	assert.NilError(t, err)
+	assert.Assert(t, result.Allowed)
+
+	// Counters are created with their expiry
+	windowIndex := time.Now().UnixNano() / time.Second.Nanoseconds()
+	ttl, err := driver.TTL(t.Context(), fmt.Sprintf("fixed:user-1:%d", windowIndex))
+	assert.NilError(t, err)
+	assert.Assert(t, ttl > 0 && ttl <= time.Second)
+}
+
+func TestInvalidWindow(t *testing.T) {
+	t.Parallel()
+
+	driver, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	for _, window := range []time.Duration{0, -time.Second} {
+		_, err := ratelimit.New(driver, 1, window)
+		assert.ErrorIs(t, err, ratelimit.ErrInvalidWindow)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	t.Parallel()
+
+	driver, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	limiter, err := ratelimit.New(driver, 5, time.Second, ratelimit.WithAlgorithm(ratelimit.AlgorithmSlidingWindow))
+	assert.NilError(t, err)
+	untilNextWindow(time.Second)
+
+	for range 5 {
+		result, err := limiter.Allow(t.Context(), "user")
+		assert.NilError(t, err)
+		assert.Assert(t, result.Allowed)
+	}
+
+	result, err := limiter.Allow(t.Context(), "user")
+	assert.NilError(t, err)
+	assert.Assert(t, !result.Allowed)
+
+	// The previous window still weighs in at the start of the next one, a
+	// fixed window would allow the whole limit again
+	untilNextWindow(time.Second)
+
+	result, err = limiter.Allow(t.Context(), "user")
+	assert.NilError(t, err)
+	assert.Assert(t, result.Allowed)
+
+	result, err = limiter.Allow(t.Context(), "user")
+	assert.NilError(t, err)
+	assert.Assert(t, !result.Allowed)
+	assert.Assert(t, result.RetryAfter > 0 && result.RetryAfter < time.Second)
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	driver, err := cache.NewDriverMemory()
+	assert.NilError(t, err)
+
+	limiter, err := ratelimit.New(driver, 1, time.Hour)
+	assert.NilError(t, err)
+
+	handler := ratelimit.Middleware(
+		limiter,
+		func(r *http.Request) string {
+			return r.Header.Get("X-User")
+		},
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := func(user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User", user)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	response := request("alice")
+	assert.Equal(t, response.Code, http.StatusNoContent)
+	assert.Equal(t, response.Header().Get("RateLimit-Limit"), "1")
+	assert.Equal(t, response.Header().Get("RateLimit-Remaining"), "0")
+
+	response = request("alice")
+	assert.Equal(t, response.Code, http.StatusTooManyRequests)
+	assert.Assert(t, response.Header().Get("Retry-After") != "")
+	assert.Assert(t, response.Header().Get("RateLimit-Reset") != "")
+
+	// Requests without a key aren't limited
+	for range 3 {
+		assert.Equal(t, request("").Code, http.StatusNoContent)
+	}
+
+	// Keys come from the function
+	assert.Equal(t, ratelimit.KeyByIP(httptest.NewRequest(http.MethodGet, "/", nil)), "192.0.2.1")
+}
+
+// failingDriver stands in for a cache that can't be reached
+type failingDriver struct {
+	cache.Driver
+}
+
+func (driver failingDriver) IncrementWithExpiry(ctx context.Context, key string, delta int64, duration time.Duration) (int64, error) {
+	return 0, errors.New("cache unreachable")
+}
+
+func TestMiddlewareCacheError(t *testing.T) {
+	t.Parallel()
+
+	logs := &bytes.Buffer{}
+	limiter, err := ratelimit.New(
+		failingDriver{},
+		1,
+		time.Hour,
+		ratelimit.WithLogger(slog.New(slog.NewTextHandler(logs, nil))),
+	)
+	assert.NilError(t, err)
+
+	handler := ratelimit.Middleware(limiter, ratelimit.KeyByIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	// The request passes but the error isn't lost
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, w.Code, http.StatusNoContent)
+	assert.Assert(t, strings.Contains(logs.String(), "cache unreachable"))
+}
@@ -0,0 +1,17 @@
+package athenatest
+
+import (
+	"testing"
+
+	"github.com/lunagic/athena/athenaservices/database"
+	"gotest.tools/v3/assert"
+)
+
+// AssertNoFullTableScan fails the test when the plan reads every row of the
+// entity's table
+func AssertNoFullTableScan(t *testing.T, plan database.QueryPlan, entity database.Entity) {
+	t.Helper()
+
+	tableName := entity.TableStructure().Name
+	assert.Assert(t, !plan.FullTableScan(tableName), "full table scan on %s: %s %v", tableName, plan.Statement, plan.Steps)
+}